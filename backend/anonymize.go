@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+var errUserAlreadyAnonymized = errors.New("user_already_anonymized")
+
+// anonymizedEmailDomain is a reserved TLD (RFC 2606), so scrubbed addresses
+// can never be delivered to anyone.
+const anonymizedEmailDomain = "anonymized.invalid"
+
+// anonymizeUser scrubs the personal data of a user while keeping the row and
+// its ID, so records that reference the user stay intact. The username
+// becomes deleted_user_<id>, the email a random undeliverable address, the
+// password an unusable value, and bio, avatar and timezone are cleared. The
+// user is soft-deleted if they weren't already, their tokens are removed and
+// the action is audited in the same transaction. It returns sql.ErrNoRows for
+// unknown users.
+func anonymizeUser(db *sql.DB, store BlobStore, id int, actorID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var anonymizedAt sql.NullTime
+	var avatarKey string
+	err = tx.QueryRow("SELECT anonymized_at, avatar_key FROM users WHERE id = $1 FOR UPDATE", id).Scan(&anonymizedAt, &avatarKey)
+	if err != nil {
+		return err
+	}
+	if anonymizedAt.Valid {
+		return errUserAlreadyAnonymized
+	}
+
+	mailbox, err := randomToken()
+	if err != nil {
+		return err
+	}
+	password, err := randomToken()
+	if err != nil {
+		return err
+	}
+	// The password column normally holds a bcrypt hash; a random hex string
+	// never matches any password.
+	_, err = tx.Exec(`UPDATE users SET username = $1, email = $2, password = $3,
+		bio = '', profile_picture_url = '', avatar_key = '', timezone = '',
+		verification_token = NULL, pending_email = NULL,
+		tokens_revoked_at = NOW(), deleted_at = COALESCE(deleted_at, NOW()),
+		anonymized_at = NOW(), updated_at = NOW()
+		WHERE id = $4`,
+		fmt.Sprintf("deleted_user_%d", id), mailbox[:32]+"@"+anonymizedEmailDomain, password, id)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"password_reset_tokens", "refresh_tokens", "unlock_tokens"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = $1", id); err != nil {
+			return err
+		}
+	}
+	if err := writeAuditLog(tx, "user.anonymize", id, actorID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	invalidateUser(id)
+	if avatarKey != "" {
+		if err := store.Delete(avatarKey); err != nil {
+			log.Warnf("Error deleting avatar %s of anonymized user %d: %v", avatarKey, id, err)
+		}
+	}
+	return nil
+}
+
+// anonymizeHandler serves POST /users/:id/anonymize. It must run after
+// RequireAuth and RequireRole.
+func anonymizeHandler(db *sql.DB, store BlobStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
+		}
+		err = anonymizeUser(db, store, id, authenticatedUserID(c))
+		switch {
+		case err == sql.ErrNoRows:
+			return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+		case err == errUserAlreadyAnonymized:
+			return newAPIError(http.StatusConflict, "user_already_anonymized", "User is already anonymized")
+		case err != nil:
+			log.Errorf("request %s: anonymizing user %d: %v", requestID(c), id, err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_anonymize_user", "Failed to anonymize user")
+		}
+		log.Infof("request %s: user %d anonymized by user %d", requestID(c), id, authenticatedUserID(c))
+		return c.NoContent(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Anonymize User", func() {
+	var testUser, admin User
+	var store *memoryBlobStore
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "anonuser", Email: "anonuser@example.com", Password: "password123", Bio: "Personal details"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+		admin = User{Username: "anonadmin", Email: "anonadmin@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &admin)).Should(gomega.Succeed())
+		_, err := db.Exec("UPDATE users SET role = $1 WHERE id = $2", roleAdmin, admin.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		store = newMemoryBlobStore()
+		url, err := store.Put("avatars/anon.png", bytes.NewReader(smallPNG()), "image/png")
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, err = setAvatar(db, testUser.ID, url, "avatars/anon.png")
+		gomega.Expect(err).Should(gomega.BeNil())
+	})
+
+	anonymize := func(id int) *httptest.ResponseRecorder {
+		server := echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.POST("/users/:id/anonymize", anonymizeHandler(db, store), RequireAuth(cfg, db), RequireRole(db, roleAdmin))
+
+		token, err := issueToken(cfg, admin.ID, roleAdmin)
+		gomega.Expect(err).Should(gomega.BeNil())
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/users/%d/anonymize", id), nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	ginkgo.It("Should scrub personal data but keep the row and ID", func() {
+		_, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		rec := anonymize(testUser.ID)
+		gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusNoContent))
+
+		var username, email, bio, pictureURL, avatarKey string
+		var deletedAt, anonymizedAt *time.Time
+		err = db.QueryRow("SELECT username, email, bio, profile_picture_url, avatar_key, deleted_at, anonymized_at FROM users WHERE id = $1", testUser.ID).
+			Scan(&username, &email, &bio, &pictureURL, &avatarKey, &deletedAt, &anonymizedAt)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(username).Should(gomega.Equal(fmt.Sprintf("deleted_user_%d", testUser.ID)))
+		gomega.Expect(email).Should(gomega.HaveSuffix("@" + anonymizedEmailDomain))
+		gomega.Expect(email).ShouldNot(gomega.ContainSubstring("anonuser"))
+		gomega.Expect(bio).Should(gomega.BeEmpty())
+		gomega.Expect(pictureURL).Should(gomega.BeEmpty())
+		gomega.Expect(avatarKey).Should(gomega.BeEmpty())
+		gomega.Expect(deletedAt).ShouldNot(gomega.BeNil())
+		gomega.Expect(anonymizedAt).ShouldNot(gomega.BeNil())
+		gomega.Expect(store.Keys()).Should(gomega.BeEmpty())
+
+		var tokens, audits int
+		gomega.Expect(db.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1", testUser.ID).Scan(&tokens)).Should(gomega.Succeed())
+		gomega.Expect(tokens).Should(gomega.BeZero())
+		gomega.Expect(db.QueryRow("SELECT COUNT(*) FROM audit_logs WHERE action = 'user.anonymize' AND user_id = $1 AND actor_id = $2", testUser.ID, admin.ID).Scan(&audits)).Should(gomega.Succeed())
+		gomega.Expect(audits).Should(gomega.Equal(1))
+
+		_, err = authenticateUser(db, cfg, testEmailSender, 0, "anonuser", "password123")
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("Should refuse to restore an anonymized user", func() {
+		gomega.Expect(anonymize(testUser.ID).Code).Should(gomega.Equal(http.StatusNoContent))
+		gomega.Expect(restoreUser(db, testUser.ID, time.Hour)).Should(gomega.Equal(errUserAnonymized))
+	})
+
+	ginkgo.It("Should return 409 for an already anonymized user", func() {
+		gomega.Expect(anonymize(testUser.ID).Code).Should(gomega.Equal(http.StatusNoContent))
+		gomega.Expect(anonymize(testUser.ID).Code).Should(gomega.Equal(http.StatusConflict))
+	})
+
+	ginkgo.It("Should return 404 for an unknown user", func() {
+		gomega.Expect(anonymize(999999).Code).Should(gomega.Equal(http.StatusNotFound))
+	})
+})
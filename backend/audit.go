@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// writeAuditLog records an action performed against a user. actorID is the
+// user who performed it, or 0 when the action was not made by a user. Pass a
+// transaction to record the action atomically with the change itself.
+func writeAuditLog(db execer, action string, userID int, actorID int) error {
+	_, err := db.Exec("INSERT INTO audit_logs (action, user_id, actor_id) VALUES ($1, $2, $3)", action, userID, actorID)
+	return err
+}
+
+// pruneAuditLogs deletes audit rows older than retention, batchSize rows at a
+// time so a large backlog doesn't hold one long-running delete. It returns the
+// number of rows removed.
+func pruneAuditLogs(db *sql.DB, retention time.Duration, batchSize int) (int64, error) {
+	cutoff := time.Now().Add(-retention)
+
+	var total int64
+	for {
+		result, err := db.Exec("DELETE FROM audit_logs WHERE id IN (SELECT id FROM audit_logs WHERE created_at < $1 ORDER BY id LIMIT $2)", cutoff, batchSize)
+		if err != nil {
+			return total, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += rowsAffected
+
+		if rowsAffected < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// runAuditPruner prunes the audit log on the configured interval until ctx is
+// cancelled.
+func runAuditPruner(ctx context.Context, db *sql.DB, cfg *Config) {
+	ticker := time.NewTicker(cfg.App.AuditPruneInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := pruneAuditLogs(db, cfg.App.AuditRetention.Duration, cfg.App.AuditPruneBatchSize)
+			if err != nil {
+				log.Errorf("Error pruning audit logs: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Infof("Pruned %d audit log rows", removed)
+			}
+		}
+	}
+}
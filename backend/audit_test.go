@@ -0,0 +1,37 @@
+package main
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Audit Log", func() {
+	ginkgo.BeforeEach(func() {
+		db.Exec("DELETE FROM audit_logs")
+	})
+
+	ginkgo.Context("pruneAuditLogs", func() {
+		ginkgo.It("Should remove only rows older than the retention period", func() {
+			now := time.Now()
+			for _, createdAt := range []time.Time{now.Add(-72 * time.Hour), now.Add(-48 * time.Hour), now.Add(-time.Hour), now} {
+				_, err := db.Exec("INSERT INTO audit_logs (action, user_id, actor_id, created_at) VALUES ($1, $2, $3, $4)", "user.updated", 1, 0, createdAt)
+				gomega.Expect(err).Should(gomega.BeNil())
+			}
+
+			removed, err := pruneAuditLogs(db, 24*time.Hour, 1)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(removed).Should(gomega.Equal(int64(2)))
+
+			var remaining int
+			err = db.QueryRow("SELECT COUNT(*) FROM audit_logs WHERE created_at < $1", now.Add(-24*time.Hour)).Scan(&remaining)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(remaining).Should(gomega.Equal(0))
+
+			err = db.QueryRow("SELECT COUNT(*) FROM audit_logs").Scan(&remaining)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(remaining).Should(gomega.Equal(2))
+		})
+	})
+})
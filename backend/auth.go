@@ -0,0 +1,409 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+var errInvalidCredentials = errors.New("invalid_credentials")
+
+// Roles stored in users.role.
+const (
+	roleUser  = "user"
+	roleAdmin = "admin"
+)
+
+var knownRoles = []string{roleUser, roleAdmin}
+
+func isKnownRole(role string) bool {
+	return containsString(knownRoles, role)
+}
+
+// tokenClaims are the claims carried by the access tokens issued on login.
+// Role is what the user had when the token was issued, for clients to adjust
+// their UI; the server checks the current role with RequireRole.
+type tokenClaims struct {
+	UserID int    `json:"user_id"`
+	Role   string `json:"role,omitempty"`
+	jwt.StandardClaims
+}
+
+type LoginRequest struct {
+	TenantID int    `json:"tenant_id"`
+	Login    string `json:"login" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// randomToken returns 32 random bytes, hex encoded, for use in verification
+// and reset links.
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// issueToken signs an access token for userID with role that expires after
+// Config.App.TokenTTL. Each token gets a random ID (jti) so it can be
+// revoked on its own by POST /logout.
+func issueToken(cfg *Config, userID int, role string) (string, error) {
+	jti, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := tokenClaims{
+		UserID: userID,
+		Role:   role,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   strconv.Itoa(userID),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(cfg.App.TokenTTL.Duration).Unix(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.App.JwtSecret))
+}
+
+// parseToken validates the signature and expiry of tokenString.
+func parseToken(cfg *Config, tokenString string) (*tokenClaims, error) {
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(cfg.App.JwtSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// loginQuery finds an active user by username or email, ignoring case like
+// the uniqueness checks do. It keeps the deleted_at IS NULL predicate of the
+// partial users_active_* indexes.
+const loginQuery = "SELECT id, email, password, locked_until FROM users WHERE tenant_id = $1 AND (LOWER(username) = LOWER($2) OR LOWER(email) = LOWER($2)) AND deleted_at IS NULL"
+
+// authenticateUser checks a username or email and password against the
+// stored hash. Locked accounts get errAccountLocked without the
+// password being checked. A wrong password may lock the account, in which
+// case the user is emailed an unlock link if Config.App.NotifyOnLock is set.
+func authenticateUser(db *sql.DB, cfg *Config, sender EmailSender, tenantID int, login string, password string) (int, error) {
+	var id int
+	var email, hashedPassword string
+	var lockedUntil sql.NullTime
+	err := db.QueryRow(loginQuery, tenantID, login).Scan(&id, &email, &hashedPassword, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return 0, errInvalidCredentials
+	}
+	if err != nil {
+		return 0, err
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		return 0, errAccountLocked
+	}
+
+	ok, err := verifyPassword(hashedPassword, password)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		locked, err := recordFailedLogin(db, cfg, id)
+		if err != nil {
+			return 0, err
+		}
+		if locked && cfg.App.NotifyOnLock {
+			if err := sendLockNotification(db, cfg, sender, id, email); err != nil {
+				log.Warnf("Error sending lock notification to user %d: %v", id, err)
+			}
+		}
+		return 0, errInvalidCredentials
+	}
+	if err := recordLogin(db, id); err != nil {
+		return 0, err
+	}
+	if cfg.App.RehashPasswords && passwordHasher.NeedsRehash(hashedPassword) {
+		if err := rehashPassword(db, id, hashedPassword, password); err != nil {
+			log.Warnf("Error rehashing password of user %d: %v", id, err)
+		}
+	}
+	return id, nil
+}
+
+// rehashPassword replaces the stored hash of a password that just verified
+// with one made by passwordHasher. It leaves the row alone if the password
+// was changed in the meantime.
+func rehashPassword(db *sql.DB, id int, oldHash string, password string) error {
+	newHash, err := passwordHasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE users SET password = $1 WHERE id = $2 AND password = $3", newHash, id, oldHash)
+	return err
+}
+
+// revokeTokens invalidates every access token issued to userID up to now.
+func revokeTokens(db *sql.DB, userID int) error {
+	result, err := db.Exec("UPDATE users SET tokens_revoked_at = NOW() WHERE id = $1", userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// tokenRevoked reports whether claims were logged out with POST /logout or
+// issued before the user's tokens were last revoked. Tokens issued in the
+// same second as the revocation are treated as revoked.
+func tokenRevoked(db *sql.DB, claims *tokenClaims) (bool, error) {
+	var revokedAt sql.NullTime
+	var loggedOut bool
+	err := db.QueryRow(`SELECT (SELECT tokens_revoked_at FROM users WHERE id = $1),
+		EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $2)`, claims.UserID, claims.Id).Scan(&revokedAt, &loggedOut)
+	if err != nil {
+		return false, err
+	}
+	return loggedOut || (revokedAt.Valid && claims.IssuedAt <= revokedAt.Time.Unix()), nil
+}
+
+// revokeToken records the ID of an access token so RequireAuth refuses it
+// until it would have expired anyway.
+func revokeToken(db *sql.DB, claims *tokenClaims) error {
+	_, err := db.Exec("INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING", claims.Id, time.Unix(claims.ExpiresAt, 0))
+	return err
+}
+
+// pruneRevokedTokens deletes revoked token IDs whose tokens have expired.
+func pruneRevokedTokens(db *sql.DB) (int64, error) {
+	result, err := db.Exec("DELETE FROM revoked_tokens WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+type LogoutRequest struct {
+	// RefreshToken, if sent, is revoked along with the rest of its chain.
+	RefreshToken string `json:"refresh_token"`
+}
+
+// logoutHandler serves POST /logout. It revokes the access token used for
+// the request and, if one is sent, the refresh token chain from the same
+// login. It must run after RequireAuth.
+func logoutHandler(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req LogoutRequest
+		if c.Request().ContentLength != 0 {
+			if err := c.Bind(&req); err != nil {
+				return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+			}
+		}
+		claims := authenticatedClaims(c)
+		if claims.Id == "" {
+			return newAPIError(http.StatusBadRequest, "token_not_revocable", "Token has no ID; it expires on its own")
+		}
+		if err := revokeToken(db, claims); err != nil {
+			log.Errorf("request %s: revoking token: %v", requestID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_log_out", "Failed to log out")
+		}
+		if req.RefreshToken != "" {
+			if err := revokeRefreshTokenFamily(db, claims.UserID, req.RefreshToken); err != nil {
+				log.Errorf("request %s: revoking refresh tokens: %v", requestID(c), err)
+				return newAPIError(http.StatusInternalServerError, "failed_to_log_out", "Failed to log out")
+			}
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// RequireAuth rejects requests without a valid "Authorization: Bearer" token,
+// including tokens revoked by a logout or forced logout, and stores the
+// token's user ID in the context under "user_id" and its claims under
+// "token_claims".
+func RequireAuth(cfg *Config, db *sql.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			tokenString, found := strings.CutPrefix(header, "Bearer ")
+			if !found || tokenString == "" {
+				return newAPIError(http.StatusUnauthorized, "missing_token", "Missing bearer token")
+			}
+
+			claims, err := parseToken(cfg, tokenString)
+			if err != nil {
+				return newAPIError(http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+			}
+			revoked, err := tokenRevoked(db, claims)
+			if err != nil {
+				return newAPIError(http.StatusInternalServerError, "failed_to_check_token", "Failed to check token")
+			}
+			if revoked {
+				return newAPIError(http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+			}
+
+			c.Set("user_id", claims.UserID)
+			c.Set("token_claims", claims)
+			return next(c)
+		}
+	}
+}
+
+// RequireInternalToken guards internal endpoints such as /metrics with a
+// static bearer token. An empty token leaves the endpoint open.
+func RequireInternalToken(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if token == "" {
+				return next(c)
+			}
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			provided, found := strings.CutPrefix(header, "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				return newAPIError(http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireSelf rejects requests whose authenticated user doesn't match the
+// :id route parameter. It must run after RequireAuth.
+func RequireSelf() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				return newAPIError(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
+			}
+			if authenticatedUserID(c) != id {
+				return newAPIError(http.StatusForbidden, "forbidden", "You are not allowed to do this")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireSelfOrRole is RequireSelf that also lets through users with role,
+// checked like RequireRole. It must run after RequireAuth.
+func RequireSelfOrRole(db *sql.DB, role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				return newAPIError(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
+			}
+			if authenticatedUserID(c) == id {
+				return next(c)
+			}
+			userRole, err := getUserRole(db, authenticatedUserID(c))
+			if err != nil && err != sql.ErrNoRows {
+				return newAPIError(http.StatusInternalServerError, "failed_to_check_role", "Failed to check role")
+			}
+			if userRole != role {
+				return newAPIError(http.StatusForbidden, "forbidden", "You are not allowed to do this")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireRole rejects requests whose authenticated user doesn't have role.
+// The role is read from the database on each request so a demotion takes
+// effect immediately. It must run after RequireAuth.
+func RequireRole(db *sql.DB, role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userRole, err := getUserRole(db, authenticatedUserID(c))
+			if err != nil && err != sql.ErrNoRows {
+				return newAPIError(http.StatusInternalServerError, "failed_to_check_role", "Failed to check role")
+			}
+			if userRole != role {
+				return newAPIError(http.StatusForbidden, "forbidden", "You are not allowed to do this")
+			}
+			return next(c)
+		}
+	}
+}
+
+// getUserRole returns the role of an active user, or sql.ErrNoRows if there
+// is none.
+func getUserRole(db *sql.DB, id int) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM users WHERE id = $1 AND deleted_at IS NULL", id).Scan(&role)
+	return role, err
+}
+
+// isAdminRequest reports whether c carries a valid, unrevoked bearer token
+// for an admin. It is for routes open to everyone that show admins more;
+// any failure counts as not an admin.
+func isAdminRequest(cfg *Config, db *sql.DB, c echo.Context) bool {
+	tokenString, found := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if !found || tokenString == "" {
+		return false
+	}
+	claims, err := parseToken(cfg, tokenString)
+	if err != nil {
+		return false
+	}
+	if revoked, err := tokenRevoked(db, claims); err != nil || revoked {
+		return false
+	}
+	role, err := getUserRole(db, claims.UserID)
+	return err == nil && role == roleAdmin
+}
+
+// hasAdminClaim reports whether c carries a validly signed bearer token whose
+// role claim is admin. Unlike isAdminRequest it doesn't check revocation or
+// the stored role, so it costs no database query; a token keeps its claim
+// until it expires.
+func hasAdminClaim(cfg *Config, c echo.Context) bool {
+	tokenString, found := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if !found || tokenString == "" {
+		return false
+	}
+	claims, err := parseToken(cfg, tokenString)
+	return err == nil && claims.Role == roleAdmin
+}
+
+// authenticatedUserID returns the user ID stored by RequireAuth, or 0 for
+// unauthenticated requests.
+func authenticatedUserID(c echo.Context) int {
+	id, _ := c.Get("user_id").(int)
+	return id
+}
+
+// authenticatedClaims returns the token claims stored by RequireAuth.
+func authenticatedClaims(c echo.Context) *tokenClaims {
+	claims, _ := c.Get("token_claims").(*tokenClaims)
+	if claims == nil {
+		return &tokenClaims{}
+	}
+	return claims
+}
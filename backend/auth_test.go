@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Auth", func() {
+	var protected *echo.Echo
+
+	ginkgo.BeforeEach(func() {
+		protected = echo.New()
+		protected.HTTPErrorHandler = httpErrorHandler
+		protected.PUT("/users/:id", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		}, RequireAuth(cfg, db), RequireSelf())
+	})
+
+	send := func(target string, token string) int {
+		req := httptest.NewRequest(http.MethodPut, target, nil)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		protected.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	ginkgo.Context("RequireAuth", func() {
+		ginkgo.It("Should allow a valid token for the same user", func() {
+			token, err := issueToken(cfg, 42, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(send("/users/42", token)).Should(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("Should return 401 without a token", func() {
+			gomega.Expect(send("/users/42", "")).Should(gomega.Equal(http.StatusUnauthorized))
+		})
+
+		ginkgo.It("Should return 401 for a malformed token", func() {
+			gomega.Expect(send("/users/42", "not-a-jwt")).Should(gomega.Equal(http.StatusUnauthorized))
+		})
+
+		ginkgo.It("Should return 401 for an expired token", func() {
+			expiredCfg := *cfg
+			expiredCfg.App.TokenTTL = Duration{-time.Minute}
+			token, err := issueToken(&expiredCfg, 42, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(send("/users/42", token)).Should(gomega.Equal(http.StatusUnauthorized))
+		})
+
+		ginkgo.It("Should return 401 for a token signed with another secret", func() {
+			otherCfg := *cfg
+			otherCfg.App.JwtSecret = "another-secret"
+			token, err := issueToken(&otherCfg, 42, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(send("/users/42", token)).Should(gomega.Equal(http.StatusUnauthorized))
+		})
+	})
+
+	ginkgo.Context("RequireSelf", func() {
+		ginkgo.It("Should return 403 when the token belongs to another user", func() {
+			token, err := issueToken(cfg, 7, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(send("/users/42", token)).Should(gomega.Equal(http.StatusForbidden))
+		})
+	})
+
+	ginkgo.Context("authenticateUser", func() {
+		ginkgo.It("Should accept the correct password and reject a wrong one", func() {
+			testUser := User{Username: "loginuser", Email: "loginuser@example.com", Password: "password123"}
+			err := createUser(context.Background(), db, testEmailSender, &testUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			userID, err := authenticateUser(db, cfg, testEmailSender, 0, "loginuser@example.com", "password123")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(userID).Should(gomega.Equal(testUser.ID))
+
+			_, err = authenticateUser(db, cfg, testEmailSender, 0, "loginuser", "wrong-password")
+			gomega.Expect(err).Should(gomega.Equal(errInvalidCredentials))
+		})
+
+		ginkgo.It("Should match the username regardless of case", func() {
+			testUser := User{Username: "LoginCase", Email: "logincase@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+
+			userID, err := authenticateUser(db, cfg, testEmailSender, 0, "logincase", "password123")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(userID).Should(gomega.Equal(testUser.ID))
+		})
+	})
+	ginkgo.Context("Force logout", func() {
+		var target, bystander User
+
+		ginkgo.BeforeEach(func() {
+			target = User{Username: "target", Email: "target@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &target)).Should(gomega.Succeed())
+			bystander = User{Username: "bystander", Email: "bystander@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &bystander)).Should(gomega.Succeed())
+		})
+
+		ginkgo.It("Should stop the target's existing tokens from working", func() {
+			token, err := issueToken(cfg, target.ID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(send(fmt.Sprintf("/users/%d", target.ID), token)).Should(gomega.Equal(http.StatusOK))
+
+			gomega.Expect(revokeTokens(db, target.ID)).Should(gomega.Succeed())
+
+			gomega.Expect(send(fmt.Sprintf("/users/%d", target.ID), token)).Should(gomega.Equal(http.StatusUnauthorized))
+		})
+
+		ginkgo.It("Should leave other users' tokens alone", func() {
+			token, err := issueToken(cfg, bystander.ID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(revokeTokens(db, target.ID)).Should(gomega.Succeed())
+
+			gomega.Expect(send(fmt.Sprintf("/users/%d", bystander.ID), token)).Should(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("Should report an unknown user", func() {
+			gomega.Expect(revokeTokens(db, 999999)).Should(gomega.Equal(sql.ErrNoRows))
+		})
+
+		ginkgo.It("Should deny non-admins", func() {
+			admin := echo.New()
+			admin.HTTPErrorHandler = httpErrorHandler
+			admin.POST("/admin/users/:id/logout", func(c echo.Context) error {
+				return c.NoContent(http.StatusNoContent)
+			}, RequireAuth(cfg, db), RequireRole(db, roleAdmin))
+
+			token, err := issueToken(cfg, bystander.ID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/users/%d/logout", target.ID), nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			admin.ServeHTTP(rec, req)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusForbidden))
+		})
+	})
+
+	ginkgo.Context("Admin only routes", func() {
+		var admin, member User
+
+		ginkgo.BeforeEach(func() {
+			admin = User{Username: "listadmin", Email: "listadmin@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &admin)).Should(gomega.Succeed())
+			_, err := db.Exec("UPDATE users SET role = $1 WHERE id = $2", roleAdmin, admin.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			member = User{Username: "listmember", Email: "listmember@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &member)).Should(gomega.Succeed())
+
+			protected.GET("/users", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, RequireAuth(cfg, db), RequireRole(db, roleAdmin))
+		})
+
+		list := func(token string) int {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			protected.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		ginkgo.It("Should carry the role in the token", func() {
+			token, err := issueToken(cfg, admin.ID, roleAdmin)
+			gomega.Expect(err).Should(gomega.BeNil())
+			claims, err := parseToken(cfg, token)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(claims.Role).Should(gomega.Equal(roleAdmin))
+		})
+
+		ginkgo.It("Should let an admin list users", func() {
+			token, err := issueToken(cfg, admin.ID, roleAdmin)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(list(token)).Should(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("Should return 403 for a regular user", func() {
+			token, err := issueToken(cfg, member.ID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(list(token)).Should(gomega.Equal(http.StatusForbidden))
+		})
+
+		ginkgo.It("Should go by the current role, not the one in the token", func() {
+			token, err := issueToken(cfg, member.ID, roleAdmin)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(list(token)).Should(gomega.Equal(http.StatusForbidden))
+		})
+	})
+
+	ginkgo.Context("RequireSelfOrRole", func() {
+		var admin, member, other User
+
+		ginkgo.BeforeEach(func() {
+			admin = User{Username: "deleteadmin", Email: "deleteadmin@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &admin)).Should(gomega.Succeed())
+			_, err := db.Exec("UPDATE users SET role = $1 WHERE id = $2", roleAdmin, admin.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			member = User{Username: "deletemember", Email: "deletemember@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &member)).Should(gomega.Succeed())
+			other = User{Username: "deleteother", Email: "deleteother@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &other)).Should(gomega.Succeed())
+
+			protected.DELETE("/users/:id", deleteUserHandler(db), RequireAuth(cfg, db), RequireSelfOrRole(db, roleAdmin))
+		})
+
+		remove := func(userID, targetID int) int {
+			token, err := issueToken(cfg, userID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+			req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/users/%d", targetID), nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			protected.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		ginkgo.It("Should let an admin delete another user", func() {
+			gomega.Expect(remove(admin.ID, other.ID)).Should(gomega.Equal(http.StatusNoContent))
+
+			var deleted bool
+			gomega.Expect(db.QueryRow("SELECT deleted_at IS NOT NULL FROM users WHERE id = $1", other.ID).Scan(&deleted)).Should(gomega.Succeed())
+			gomega.Expect(deleted).Should(gomega.BeTrue())
+		})
+
+		ginkgo.It("Should return 403 when a regular user deletes another user", func() {
+			gomega.Expect(remove(member.ID, other.ID)).Should(gomega.Equal(http.StatusForbidden))
+		})
+
+		ginkgo.It("Should let a regular user delete themselves", func() {
+			gomega.Expect(remove(member.ID, member.ID)).Should(gomega.Equal(http.StatusNoContent))
+		})
+	})
+
+	ginkgo.Context("Logout", func() {
+		var user User
+
+		ginkgo.BeforeEach(func() {
+			user = User{Username: "logoutuser", Email: "logoutuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			protected.POST("/logout", logoutHandler(db), RequireAuth(cfg, db))
+		})
+
+		logout := func(token, body string) int {
+			req := httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			protected.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		ginkgo.It("Should revoke only the token used to log out", func() {
+			token, err := issueToken(cfg, user.ID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+			other, err := issueToken(cfg, user.ID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(logout(token, "")).Should(gomega.Equal(http.StatusNoContent))
+
+			target := fmt.Sprintf("/users/%d", user.ID)
+			gomega.Expect(send(target, token)).Should(gomega.Equal(http.StatusUnauthorized))
+			gomega.Expect(logout(token, "")).Should(gomega.Equal(http.StatusUnauthorized))
+			gomega.Expect(send(target, other)).Should(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("Should revoke the refresh token chain when one is sent", func() {
+			token, err := issueToken(cfg, user.ID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+			refreshToken, err := createRefreshToken(db, cfg, user.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(logout(token, fmt.Sprintf(`{"refresh_token":%q}`, refreshToken))).Should(gomega.Equal(http.StatusNoContent))
+
+			_, _, err = rotateRefreshToken(db, cfg, refreshToken)
+			gomega.Expect(err).Should(gomega.Equal(errInvalidRefreshToken))
+		})
+
+		ginkgo.It("Should forget revoked IDs once the token has expired", func() {
+			jti, err := randomToken()
+			gomega.Expect(err).Should(gomega.BeNil())
+			claims := &tokenClaims{UserID: user.ID, StandardClaims: jwt.StandardClaims{Id: jti, ExpiresAt: time.Now().Add(-time.Minute).Unix()}}
+			gomega.Expect(revokeToken(db, claims)).Should(gomega.Succeed())
+
+			removed, err := pruneRevokedTokens(db)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(removed).Should(gomega.BeNumerically(">=", 1))
+		})
+	})
+
+	ginkgo.Context("default role", func() {
+		roleOf := func(id int) string {
+			var role string
+			gomega.Expect(db.QueryRow("SELECT role FROM users WHERE id = $1", id).Scan(&role)).Should(gomega.Succeed())
+			return role
+		}
+
+		ginkgo.It("Should default to the user role", func() {
+			testCfg := Config{}
+			applyConfigDefaults(&testCfg)
+			gomega.Expect(testCfg.App.DefaultRole).Should(gomega.Equal(roleUser))
+
+			user := User{Username: "plainuser", Email: "plainuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			gomega.Expect(roleOf(user.ID)).Should(gomega.Equal(roleUser))
+		})
+
+		ginkgo.It("Should give a new user the configured default role", func() {
+			testCfg := Config{}
+			testCfg.App.DefaultRole = roleAdmin
+			applyConfigDefaults(&testCfg)
+
+			user := User{Username: "roleuser", Email: "roleuser@example.com", Password: "password123", Role: testCfg.App.DefaultRole}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			gomega.Expect(roleOf(user.ID)).Should(gomega.Equal(roleAdmin))
+		})
+
+		ginkgo.It("Should only accept known roles", func() {
+			gomega.Expect(isKnownRole(roleUser)).Should(gomega.BeTrue())
+			gomega.Expect(isKnownRole(roleAdmin)).Should(gomega.BeTrue())
+			gomega.Expect(isKnownRole("superuser")).Should(gomega.BeFalse())
+			gomega.Expect(isKnownRole("")).Should(gomega.BeFalse())
+		})
+	})
+})
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// avatarTypes maps the accepted image types to the extension they are
+// stored with.
+var avatarTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+type AvatarResponse struct {
+	ProfilePictureURL string `json:"profile_picture_url"`
+}
+
+// readAvatar reads the "file" field of a multipart upload. The type is
+// sniffed from the content rather than taken from the client, and anything
+// but JPEG or PNG, or larger than maxBytes, is refused.
+func readAvatar(c echo.Context, maxBytes int) ([]byte, string, error) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		return nil, "", newAPIError(http.StatusBadRequest, "missing_file", "Upload the image as the multipart field \"file\"")
+	}
+	if header.Size > int64(maxBytes) {
+		return nil, "", newAPIError(http.StatusBadRequest, "file_too_large", "Image is too large").With("max_bytes", maxBytes)
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, int64(maxBytes)+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxBytes {
+		return nil, "", newAPIError(http.StatusBadRequest, "file_too_large", "Image is too large").With("max_bytes", maxBytes)
+	}
+	contentType := http.DetectContentType(data)
+	if _, ok := avatarTypes[contentType]; !ok {
+		return nil, "", newAPIError(http.StatusBadRequest, "invalid_file_type", "Image must be a JPEG or PNG").With("content_type", contentType)
+	}
+	return data, contentType, nil
+}
+
+// setAvatar makes url, stored under key, the profile picture of an active
+// user and returns the key of the avatar it replaced, if any. It returns
+// sql.ErrNoRows for unknown or deleted users.
+func setAvatar(db *sql.DB, id int, url string, key string) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var oldKey string
+	err = tx.QueryRow("SELECT avatar_key FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&oldKey)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("UPDATE users SET profile_picture_url = $2, avatar_key = $3, updated_at = NOW() WHERE id = $1", id, url, key)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	invalidateUser(id)
+	return oldKey, nil
+}
+
+// avatarHandler serves POST /users/:id/avatar. It stores the uploaded image
+// in store, sets its URL as the user's profile picture and deletes the
+// avatar it replaces.
+func avatarHandler(cfg *Config, db *sql.DB, store BlobStore) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "Invalid user ID", "Invalid user ID")
+		}
+		// Leave room for the multipart framing around the file.
+		c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, int64(cfg.Storage.MaxAvatarBytes)+64<<10)
+
+		data, contentType, err := readAvatar(c, cfg.Storage.MaxAvatarBytes)
+		if err != nil {
+			if _, ok := err.(*APIError); ok {
+				return err
+			}
+			return newAPIError(http.StatusBadRequest, "invalid_upload", "Invalid upload")
+		}
+
+		suffix, err := randomToken()
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, "failed_to_store_avatar", "Failed to store avatar")
+		}
+		key := fmt.Sprintf("avatars/%d-%s%s", id, suffix[:16], avatarTypes[contentType])
+		url, err := store.Put(key, bytes.NewReader(data), contentType)
+		if err != nil {
+			log.Errorf("request %s: storing avatar: %v", requestID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_store_avatar", "Failed to store avatar")
+		}
+
+		oldKey, err := setAvatar(db, id, url, key)
+		if err != nil {
+			if deleteErr := store.Delete(key); deleteErr != nil {
+				log.Warnf("request %s: deleting unused avatar %s: %v", requestID(c), key, deleteErr)
+			}
+			if err == sql.ErrNoRows {
+				return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+			}
+			log.Errorf("request %s: setting avatar: %v", requestID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_update_user", "Failed to update user")
+		}
+		if oldKey != "" {
+			if err := store.Delete(oldKey); err != nil {
+				log.Warnf("request %s: deleting replaced avatar %s: %v", requestID(c), oldKey, err)
+			}
+		}
+		return c.JSON(http.StatusOK, AvatarResponse{ProfilePictureURL: url})
+	}
+}
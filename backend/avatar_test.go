@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+// memoryBlobStore is a BlobStore that keeps blobs in a map.
+type memoryBlobStore struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func newMemoryBlobStore() *memoryBlobStore {
+	return &memoryBlobStore{blobs: make(map[string][]byte)}
+}
+
+func (s *memoryBlobStore) Put(key string, r io.Reader, contentType string) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[key] = data
+	return "https://blobs.example.com/" + key, nil
+}
+
+func (s *memoryBlobStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, key)
+	return nil
+}
+
+func (s *memoryBlobStore) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.blobs))
+	for key := range s.blobs {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func smallPNG() []byte {
+	var buf bytes.Buffer
+	gomega.Expect(png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2)))).Should(gomega.Succeed())
+	return buf.Bytes()
+}
+
+var _ = ginkgo.Describe("Avatar Upload", func() {
+	var (
+		testUser User
+		store    *memoryBlobStore
+	)
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "avataruser", Email: "avataruser@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+		store = newMemoryBlobStore()
+	})
+
+	upload := func(data []byte) (int, map[string]interface{}) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", "avatar.png")
+		gomega.Expect(err).Should(gomega.BeNil())
+		part.Write(data)
+		gomega.Expect(writer.Close()).Should(gomega.Succeed())
+
+		testCfg := *cfg
+		testCfg.Storage.MaxAvatarBytes = 1024
+		server := echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.POST("/users/:id/avatar", avatarHandler(&testCfg, db, store))
+
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/users/%d/avatar", testUser.ID), &body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec.Code, response
+	}
+
+	ginkgo.It("Should store a PNG and set it as the profile picture", func() {
+		code, response := upload(smallPNG())
+		gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+		url, _ := response["profile_picture_url"].(string)
+		gomega.Expect(url).Should(gomega.HavePrefix(fmt.Sprintf("https://blobs.example.com/avatars/%d-", testUser.ID)))
+		gomega.Expect(url).Should(gomega.HaveSuffix(".png"))
+
+		var stored string
+		gomega.Expect(db.QueryRow("SELECT profile_picture_url FROM users WHERE id = $1", testUser.ID).Scan(&stored)).Should(gomega.Succeed())
+		gomega.Expect(stored).Should(gomega.Equal(url))
+		gomega.Expect(store.Keys()).Should(gomega.ConsistOf(strings.TrimPrefix(url, "https://blobs.example.com/")))
+	})
+
+	ginkgo.It("Should delete the avatar it replaces", func() {
+		_, first := upload(smallPNG())
+		_, second := upload(smallPNG())
+
+		gomega.Expect(second["profile_picture_url"]).ShouldNot(gomega.Equal(first["profile_picture_url"]))
+		gomega.Expect(store.Keys()).Should(gomega.ConsistOf(strings.TrimPrefix(second["profile_picture_url"].(string), "https://blobs.example.com/")))
+	})
+
+	ginkgo.It("Should reject a file that isn't an image", func() {
+		code, response := upload([]byte("not an image"))
+		gomega.Expect(code).Should(gomega.Equal(http.StatusBadRequest))
+		gomega.Expect(response["error"]).Should(gomega.Equal("invalid_file_type"))
+		gomega.Expect(store.Keys()).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("Should reject an oversized file", func() {
+		data := append(smallPNG(), make([]byte, 2048)...)
+		code, response := upload(data)
+		gomega.Expect(code).Should(gomega.Equal(http.StatusBadRequest))
+		gomega.Expect(response["error"]).Should(gomega.Equal("file_too_large"))
+	})
+})
+
+var _ = ginkgo.Describe("Blob Stores", func() {
+	ginkgo.It("Should write and delete files on local disk", func() {
+		dir, err := os.MkdirTemp("", "blobs")
+		gomega.Expect(err).Should(gomega.BeNil())
+		defer os.RemoveAll(dir)
+		store := &localBlobStore{dir: dir, baseURL: "http://localhost:8080/uploads/"}
+
+		url, err := store.Put("avatars/1-abc.png", bytes.NewReader([]byte("data")), "image/png")
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(url).Should(gomega.Equal("http://localhost:8080/uploads/avatars/1-abc.png"))
+		data, err := os.ReadFile(filepath.Join(dir, "avatars", "1-abc.png"))
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(string(data)).Should(gomega.Equal("data"))
+
+		gomega.Expect(store.Delete("avatars/1-abc.png")).Should(gomega.Succeed())
+		_, err = os.Stat(filepath.Join(dir, "avatars", "1-abc.png"))
+		gomega.Expect(os.IsNotExist(err)).Should(gomega.BeTrue())
+		gomega.Expect(store.Delete("avatars/1-abc.png")).Should(gomega.Succeed())
+	})
+
+	ginkgo.It("Should send signed PUT and DELETE requests to S3", func() {
+		type s3Request struct {
+			method, path, authorization, body string
+		}
+		var requests []s3Request
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests = append(requests, s3Request{r.Method, r.URL.Path, r.Header.Get("Authorization"), string(body)})
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		testCfg := *cfg
+		testCfg.Storage.S3Bucket = "avatars-bucket"
+		testCfg.Storage.S3Region = "eu-west-1"
+		testCfg.Storage.S3Endpoint = server.URL
+		testCfg.Storage.S3AccessKeyID = "AKIDEXAMPLE"
+		testCfg.Storage.S3SecretAccessKey = "secret"
+		store, err := newS3BlobStore(&testCfg)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		url, err := store.Put("avatars/1-abc.png", bytes.NewReader([]byte("data")), "image/png")
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(url).Should(gomega.Equal(server.URL + "/avatars-bucket/avatars/1-abc.png"))
+		gomega.Expect(store.Delete("avatars/1-abc.png")).Should(gomega.Succeed())
+
+		gomega.Expect(requests).Should(gomega.HaveLen(2))
+		gomega.Expect(requests[0].method).Should(gomega.Equal(http.MethodPut))
+		gomega.Expect(requests[0].path).Should(gomega.Equal("/avatars-bucket/avatars/1-abc.png"))
+		gomega.Expect(requests[0].body).Should(gomega.Equal("data"))
+		gomega.Expect(requests[0].authorization).Should(gomega.HavePrefix("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+		gomega.Expect(requests[1].method).Should(gomega.Equal(http.MethodDelete))
+	})
+
+	ginkgo.It("Should refuse an unknown driver", func() {
+		testCfg := *cfg
+		testCfg.Storage.Driver = "ftp"
+		_, err := newBlobStore(&testCfg)
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+	})
+})
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errBatchTooLarge = errors.New("batch_too_large")
+
+// decodeUserBatch stream-decodes a JSON array of users from r. Decoding stops
+// as soon as more than maxItems elements are seen, so an oversized payload is
+// rejected without being read into memory in full.
+func decodeUserBatch(r io.Reader, maxItems int) ([]User, error) {
+	decoder := json.NewDecoder(r)
+
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("expected a JSON array")
+	}
+
+	var users []User
+	for decoder.More() {
+		if len(users) == maxItems {
+			return nil, errBatchTooLarge
+		}
+		var user User
+		if err := decoder.Decode(&user); err != nil {
+			return nil, fmt.Errorf("item %d: %w", len(users), err)
+		}
+		users = append(users, user)
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Batch Decoding", func() {
+	userArray := func(n int) string {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = fmt.Sprintf(`{"username":"batchuser%d","email":"batchuser%d@example.com"}`, i, i)
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	}
+
+	ginkgo.It("Should decode an array within the cap", func() {
+		users, err := decodeUserBatch(strings.NewReader(userArray(3)), 3)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(users).Should(gomega.HaveLen(3))
+		gomega.Expect(users[2].Username).Should(gomega.Equal("batchuser2"))
+	})
+
+	ginkgo.It("Should reject an array exceeding the cap before parsing the rest", func() {
+		// The payload is cut off after the element that exceeds the cap, so
+		// reading any further would fail with a syntax error instead.
+		payload := strings.TrimSuffix(userArray(4), "]") + `,{"username":`
+
+		_, err := decodeUserBatch(strings.NewReader(payload), 3)
+		gomega.Expect(err).Should(gomega.Equal(errBatchTooLarge))
+	})
+
+	ginkgo.It("Should reject a payload that is not an array", func() {
+		_, err := decodeUserBatch(strings.NewReader(`{"username":"batchuser"}`), 3)
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+	})
+})
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore stores uploaded files and returns the URL they are served from.
+// Keys are slash-separated paths such as "avatars/42-1f2e.png".
+type BlobStore interface {
+	Put(key string, r io.Reader, contentType string) (string, error)
+	// Delete removes key. Deleting a key that doesn't exist is not an error.
+	Delete(key string) error
+}
+
+// newBlobStore returns the store selected by Config.Storage.Driver.
+func newBlobStore(cfg *Config) (BlobStore, error) {
+	switch cfg.Storage.Driver {
+	case "local":
+		return &localBlobStore{dir: cfg.Storage.LocalDir, baseURL: cfg.Storage.BaseURL}, nil
+	case "s3":
+		return newS3BlobStore(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported storage driver %q", cfg.Storage.Driver)
+	}
+}
+
+// localBlobStore writes files under dir. main serves dir at /uploads, and
+// baseURL is where that is reachable from outside.
+type localBlobStore struct {
+	dir     string
+	baseURL string
+}
+
+func (s *localBlobStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s *localBlobStore) Put(key string, r io.Reader, contentType string) (string, error) {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(s.baseURL, "/") + "/" + key, nil
+}
+
+func (s *localBlobStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
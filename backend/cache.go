@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// userCacheStore holds cached users keyed by ID. It is a go-cache with
+// per-entry expiry exposed by user ID.
+type userCacheStore struct {
+	*cache.Cache
+}
+
+func newUserCacheStore(defaultExpiration, cleanupInterval time.Duration) *userCacheStore {
+	return &userCacheStore{cache.New(defaultExpiration, cleanupInterval)}
+}
+
+// SetWithTTL caches user under id for ttl instead of the default expiration.
+func (s *userCacheStore) SetWithTTL(id int, user User, ttl time.Duration) {
+	s.Set(strconv.Itoa(id), user, ttl)
+}
+
+// DeleteMany evicts every key in ids.
+func (s *userCacheStore) DeleteMany(ids []string) {
+	for _, id := range ids {
+		s.Delete(id)
+	}
+}
+
+// userCacheTTL decides how long each user stays cached. main replaces it
+// with one built from Config.App.
+var userCacheTTL = newCacheTTLPolicy(5*time.Minute, 30*time.Minute, 10)
+
+// cacheTTLPolicy gives users read at least hotReads times within one
+// defaultTTL window the longer hotTTL; everyone else gets defaultTTL. Read
+// counts start over each window, so a user that cools down drops back.
+type cacheTTLPolicy struct {
+	defaultTTL time.Duration
+	hotTTL     time.Duration
+	hotReads   int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	reads       map[int]int
+}
+
+func newCacheTTLPolicy(defaultTTL, hotTTL time.Duration, hotReads int) *cacheTTLPolicy {
+	return &cacheTTLPolicy{defaultTTL: defaultTTL, hotTTL: hotTTL, hotReads: hotReads, windowStart: time.Now(), reads: make(map[int]int)}
+}
+
+// recordRead counts one read of id, cached or not, and reports whether this
+// read made it hot.
+func (p *cacheTTLPolicy) recordRead(id int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.windowStart) > p.defaultTTL {
+		p.windowStart = time.Now()
+		p.reads = make(map[int]int)
+	}
+	p.reads[id]++
+	return p.reads[id] == p.hotReads
+}
+
+// ttl returns how long id should be cached.
+func (p *cacheTTLPolicy) ttl(id int) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reads[id] >= p.hotReads {
+		return p.hotTTL
+	}
+	return p.defaultTTL
+}
+
+// userCacheVersions counts invalidations per user. getUserByID records the
+// version before reading the row and only caches the result if no update or
+// delete invalidated the entry in the meantime, so a read that raced a write
+// cannot put the pre-update row back in the cache.
+var userCacheVersions = &cacheVersions{versions: make(map[int]uint64)}
+
+type cacheVersions struct {
+	mu       sync.Mutex
+	versions map[int]uint64
+}
+
+func (v *cacheVersions) current(id int) uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.versions[id]
+}
+
+// invalidateUser evicts the cached user and bumps its version. Call it after
+// the write has been committed.
+func invalidateUser(id int) {
+	userCacheVersions.mu.Lock()
+	defer userCacheVersions.mu.Unlock()
+	userCacheVersions.versions[id]++
+	userCache.Delete(strconv.Itoa(id))
+}
+
+// invalidateUsers is invalidateUser for many users at once, for bulk
+// writes. The versions are bumped under a single lock and the entries evicted
+// with one DeleteMany.
+func invalidateUsers(ids []int) {
+	keys := make([]string, len(ids))
+	userCacheVersions.mu.Lock()
+	defer userCacheVersions.mu.Unlock()
+	for i, id := range ids {
+		userCacheVersions.versions[id]++
+		keys[i] = strconv.Itoa(id)
+	}
+	userCache.DeleteMany(keys)
+}
+
+// cacheUser stores user in the cache, for as long as userCacheTTL decides,
+// unless it was invalidated after version was read. It reports whether the
+// entry was stored.
+func cacheUser(id int, version uint64, user User) bool {
+	userCacheVersions.mu.Lock()
+	defer userCacheVersions.mu.Unlock()
+	if userCacheVersions.versions[id] != version {
+		return false
+	}
+	userCache.SetWithTTL(id, user, userCacheTTL.ttl(id))
+	return true
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("User Cache", func() {
+	var testUser User
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "cacheuser", Email: "cacheuser@example.com", Password: "password123", Bio: "Original bio"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+		userCache.Delete(strconv.Itoa(testUser.ID))
+	})
+
+	ginkgo.It("Should not cache a row read before an update committed", func() {
+		// A reader that missed the cache records the version and loads the
+		// row, then an update commits before the reader stores its result.
+		version := userCacheVersions.current(testUser.ID)
+		stale, err := getUserByID(context.Background(), db, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		userCache.Delete(strconv.Itoa(testUser.ID))
+
+		bio := "Updated bio"
+		_, err = patchUser(db, testUser.ID, UserPatch{Bio: &bio}, nil)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		gomega.Expect(cacheUser(testUser.ID, version, stale)).Should(gomega.BeFalse())
+		_, found := userCache.Get(strconv.Itoa(testUser.ID))
+		gomega.Expect(found).Should(gomega.BeFalse())
+
+		user, err := getUserByID(context.Background(), db, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(user.Bio).Should(gomega.Equal("Updated bio"))
+	})
+
+	ginkgo.It("Should cache the row when nothing invalidated it", func() {
+		version := userCacheVersions.current(testUser.ID)
+		gomega.Expect(cacheUser(testUser.ID, version, testUser)).Should(gomega.BeTrue())
+		_, found := userCache.Get(strconv.Itoa(testUser.ID))
+		gomega.Expect(found).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should serve the committed row after concurrent reads and updates", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				defer ginkgo.GinkgoRecover()
+				_, err := getUserByID(context.Background(), db, testUser.ID)
+				gomega.Expect(err).Should(gomega.BeNil())
+			}()
+			go func(i int) {
+				defer wg.Done()
+				defer ginkgo.GinkgoRecover()
+				bio := "Bio " + strconv.Itoa(i)
+				_, err := patchUser(db, testUser.ID, UserPatch{Bio: &bio}, nil)
+				gomega.Expect(err).Should(gomega.BeNil())
+			}(i)
+		}
+		wg.Wait()
+
+		var committed string
+		gomega.Expect(db.QueryRow("SELECT bio FROM users WHERE id = $1", testUser.ID).Scan(&committed)).Should(gomega.Succeed())
+		user, err := getUserByID(context.Background(), db, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(user.Bio).Should(gomega.Equal(committed))
+	})
+
+	ginkgo.Context("TTL policy", func() {
+		var saved *cacheTTLPolicy
+
+		ginkgo.BeforeEach(func() {
+			saved = userCacheTTL
+			userCacheTTL = newCacheTTLPolicy(50*time.Millisecond, time.Minute, 3)
+		})
+
+		ginkgo.AfterEach(func() {
+			userCacheTTL = saved
+		})
+
+		ginkgo.It("Should store an entry with the given TTL", func() {
+			userCache.SetWithTTL(testUser.ID, testUser, 20*time.Millisecond)
+			_, found := userCache.Get(strconv.Itoa(testUser.ID))
+			gomega.Expect(found).Should(gomega.BeTrue())
+			time.Sleep(40 * time.Millisecond)
+			_, found = userCache.Get(strconv.Itoa(testUser.ID))
+			gomega.Expect(found).Should(gomega.BeFalse())
+		})
+
+		ginkgo.It("Should keep a frequently read user cached longer than a default one", func() {
+			cold := User{Username: "colduser", Email: "colduser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &cold)).Should(gomega.Succeed())
+			userCache.Delete(strconv.Itoa(cold.ID))
+
+			for i := 0; i < 3; i++ {
+				_, err := getUserByID(context.Background(), db, testUser.ID)
+				gomega.Expect(err).Should(gomega.BeNil())
+			}
+			_, err := getUserByID(context.Background(), db, cold.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(userCacheTTL.ttl(testUser.ID)).Should(gomega.Equal(time.Minute))
+			gomega.Expect(userCacheTTL.ttl(cold.ID)).Should(gomega.Equal(50 * time.Millisecond))
+
+			time.Sleep(100 * time.Millisecond)
+			_, found := userCache.Get(strconv.Itoa(testUser.ID))
+			gomega.Expect(found).Should(gomega.BeTrue())
+			_, found = userCache.Get(strconv.Itoa(cold.ID))
+			gomega.Expect(found).Should(gomega.BeFalse())
+		})
+	})
+	ginkgo.Context("Bulk invalidation", func() {
+		ginkgo.It("Should evict every listed user in one batch and leave the rest cached", func() {
+			var ids []int
+			for i := 0; i < 3; i++ {
+				user := User{Username: "bulkuser" + strconv.Itoa(i), Email: "bulkuser" + strconv.Itoa(i) + "@example.com", Password: "password123"}
+				gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+				ids = append(ids, user.ID)
+			}
+			versions := map[int]uint64{}
+			for _, id := range append(ids, testUser.ID) {
+				_, err := getUserByID(context.Background(), db, id)
+				gomega.Expect(err).Should(gomega.BeNil())
+				versions[id] = userCacheVersions.current(id)
+			}
+
+			invalidateUsers(ids)
+
+			for _, id := range ids {
+				_, found := userCache.Get(strconv.Itoa(id))
+				gomega.Expect(found).Should(gomega.BeFalse())
+				gomega.Expect(userCacheVersions.current(id)).Should(gomega.Equal(versions[id] + 1))
+			}
+			_, found := userCache.Get(strconv.Itoa(testUser.ID))
+			gomega.Expect(found).Should(gomega.BeTrue())
+			gomega.Expect(userCacheVersions.current(testUser.ID)).Should(gomega.Equal(versions[testUser.ID]))
+		})
+	})
+})
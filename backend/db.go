@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// queryTimeout caps how long each user query may run, so one pathological
+// query can't hold a pool connection indefinitely. main sets it from
+// Config.Database.QueryTimeout.
+var queryTimeout = 5 * time.Second
+
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
+// isQueryTimeout reports whether err comes from a query that was cut off by
+// its context. pq reports a cancelled statement as query_canceled (57014)
+// rather than returning the context's error.
+func isQueryTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
+
+// openDB opens a Postgres pool whose connections all use timeZone as their
+// session time zone, whatever the server default is, so timestamps are never
+// read or written in an unexpected zone.
+func openDB(dsn string, timeZone string) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(sessionTimeZoneConnector{Connector: connector, timeZone: timeZone}), nil
+}
+
+// sessionTimeZoneConnector runs SET TIME ZONE on every new connection.
+type sessionTimeZoneConnector struct {
+	driver.Connector
+	timeZone string
+}
+
+func (c sessionTimeZoneConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("database connection does not support Exec")
+	}
+	// SET doesn't take bind parameters, so the zone is quoted instead.
+	if _, err := execer.ExecContext(ctx, "SET TIME ZONE "+pq.QuoteLiteral(c.timeZone), nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// uniqueUserFields maps the unique indexes on users in the migrations to the
+// field each one protects.
+var uniqueUserFields = map[string]string{
+	"users_tenant_username_key":       "username",
+	"users_tenant_email_key":          "email",
+	"users_tenant_lower_username_key": "username",
+	"users_tenant_lower_email_key":    "email",
+}
+
+// duplicateUserFromDB returns a *duplicateUserError if err is Postgres'
+// unique_violation (23505) on users, and nil otherwise. It catches the
+// duplicates that slip past the pre-checks when two writes race.
+func duplicateUserFromDB(err error) *duplicateUserError {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return nil
+	}
+	return &duplicateUserError{Field: uniqueUserFields[pqErr.Constraint]}
+}
+
+// configurePool applies the pool limits from cfg.Database to db. It refuses
+// an idle limit above the open limit, which database/sql would otherwise
+// silently lower.
+func configurePool(db *sql.DB, cfg *Config) error {
+	if cfg.Database.MaxOpenConns < 0 || cfg.Database.MaxIdleConns < 0 {
+		return fmt.Errorf("max_open_conns and max_idle_conns must not be negative")
+	}
+	if cfg.Database.MaxIdleConns > cfg.Database.MaxOpenConns {
+		return fmt.Errorf("max_idle_conns (%d) must not exceed max_open_conns (%d)", cfg.Database.MaxIdleConns, cfg.Database.MaxOpenConns)
+	}
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime.Duration)
+	return nil
+}
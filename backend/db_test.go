@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Database Session Time Zone", func() {
+	// The server default is overridden per connection with the timezone
+	// startup parameter, standing in for a server configured with a
+	// non-UTC default.
+	dsn := func() string {
+		return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s timezone=America/New_York",
+			os.Getenv("DB_HOST"),
+			os.Getenv("DB_USER"),
+			os.Getenv("DB_PASSWORD"),
+			os.Getenv("DB_NAME"),
+			getEnvAsInt("DB_PORT", 5432),
+			os.Getenv("DB_SSLMODE"),
+		)
+	}
+
+	sessionTimeZone := func(conn *sql.DB) string {
+		var zone string
+		gomega.Expect(conn.QueryRow("SHOW TIME ZONE").Scan(&zone)).Should(gomega.Succeed())
+		return zone
+	}
+
+	ginkgo.It("Should start from a non-UTC server default", func() {
+		plain, err := sql.Open("postgres", dsn())
+		gomega.Expect(err).Should(gomega.BeNil())
+		defer plain.Close()
+
+		gomega.Expect(sessionTimeZone(plain)).Should(gomega.Equal("America/New_York"))
+	})
+
+	ginkgo.It("Should set the session time zone on every connection", func() {
+		conn, err := openDB(dsn(), "UTC")
+		gomega.Expect(err).Should(gomega.BeNil())
+		defer conn.Close()
+		conn.SetMaxIdleConns(0)
+
+		for i := 0; i < 3; i++ {
+			gomega.Expect(sessionTimeZone(conn)).Should(gomega.Equal("UTC"))
+		}
+	})
+
+	ginkgo.It("Should store and read timestamps as UTC", func() {
+		conn, err := openDB(dsn(), "UTC")
+		gomega.Expect(err).Should(gomega.BeNil())
+		defer conn.Close()
+
+		testUser := User{Username: "tzuser", Email: "tzuser@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+
+		deletedAt := time.Date(2024, 3, 10, 6, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+		_, err = conn.Exec("UPDATE users SET deleted_at = $1 WHERE id = $2", deletedAt, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		var stored time.Time
+		gomega.Expect(conn.QueryRow("SELECT deleted_at FROM users WHERE id = $1", testUser.ID).Scan(&stored)).Should(gomega.Succeed())
+		gomega.Expect(stored.Equal(deletedAt)).Should(gomega.BeTrue())
+		_, offset := stored.Zone()
+		gomega.Expect(offset).Should(gomega.Equal(0))
+
+		var text string
+		gomega.Expect(conn.QueryRow("SELECT deleted_at::text FROM users WHERE id = $1", testUser.ID).Scan(&text)).Should(gomega.Succeed())
+		gomega.Expect(text).Should(gomega.Equal("2024-03-10 11:30:00+00"))
+	})
+})
+
+var _ = ginkgo.Describe("Database Connection Pool", func() {
+	ginkgo.It("Should apply the configured limits", func() {
+		pool, err := sql.Open("postgres", "")
+		gomega.Expect(err).Should(gomega.BeNil())
+		defer pool.Close()
+
+		testCfg := *cfg
+		testCfg.Database.MaxOpenConns = 7
+		testCfg.Database.MaxIdleConns = 3
+		testCfg.Database.ConnMaxLifetime = Duration{time.Minute}
+		gomega.Expect(configurePool(pool, &testCfg)).Should(gomega.Succeed())
+		gomega.Expect(pool.Stats().MaxOpenConnections).Should(gomega.Equal(7))
+	})
+
+	ginkgo.It("Should refuse more idle than open connections", func() {
+		pool, err := sql.Open("postgres", "")
+		gomega.Expect(err).Should(gomega.BeNil())
+		defer pool.Close()
+
+		testCfg := *cfg
+		testCfg.Database.MaxOpenConns = 5
+		testCfg.Database.MaxIdleConns = 10
+		gomega.Expect(configurePool(pool, &testCfg)).ShouldNot(gomega.Succeed())
+	})
+
+	ginkgo.It("Should default to a bounded pool", func() {
+		var defaults Config
+		applyConfigDefaults(&defaults)
+		gomega.Expect(defaults.Database.MaxOpenConns).Should(gomega.Equal(25))
+		gomega.Expect(defaults.Database.MaxIdleConns).Should(gomega.BeNumerically("<=", defaults.Database.MaxOpenConns))
+		gomega.Expect(defaults.Database.ConnMaxLifetime.Duration).Should(gomega.Equal(30 * time.Minute))
+	})
+})
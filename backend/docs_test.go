@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+// undocumentedRoutes are served but deliberately left out of the API docs.
+var undocumentedRoutes = map[string]bool{
+	"GET /metrics":   true,
+	"GET /swagger/*": true,
+}
+
+var routeParam = regexp.MustCompile(`:(\w+)`)
+
+// registeredRoutes lists every "METHOD /path" registered on the Echo
+// instance in the package's non-test files, with :param written as {param}.
+func registeredRoutes() []string {
+	files, err := filepath.Glob("*.go")
+	gomega.Expect(err).Should(gomega.BeNil())
+
+	var routes []string
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		gomega.Expect(err).Should(gomega.BeNil())
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if recv, ok := sel.X.(*ast.Ident); !ok || recv.Name != "e" {
+				return true
+			}
+			switch sel.Sel.Name {
+			case "GET", "POST", "PUT", "PATCH", "DELETE":
+			default:
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			path, err := strconv.Unquote(lit.Value)
+			gomega.Expect(err).Should(gomega.BeNil())
+			routes = append(routes, sel.Sel.Name+" "+path)
+			return true
+		})
+	}
+	return routes
+}
+
+var _ = ginkgo.Describe("API docs", func() {
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+		} `json:"paths"`
+	}
+
+	ginkgo.BeforeEach(func() {
+		data, err := os.ReadFile(filepath.Join("docs", "swagger.json"))
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(json.Unmarshal(data, &spec)).Should(gomega.Succeed())
+	})
+
+	ginkgo.It("Should document every registered route", func() {
+		routes := registeredRoutes()
+		gomega.Expect(routes).ShouldNot(gomega.BeEmpty())
+
+		var missing []string
+		for _, route := range routes {
+			if undocumentedRoutes[route] {
+				continue
+			}
+			method, path, _ := strings.Cut(route, " ")
+			path = routeParam.ReplaceAllString(path, "{$1}")
+			if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+				missing = append(missing, route)
+			}
+		}
+		gomega.Expect(missing).Should(gomega.BeEmpty(), "regenerate the docs with swag init after annotating these routes")
+	})
+
+	ginkgo.It("Should not document routes that don't exist", func() {
+		registered := make(map[string]bool)
+		for _, route := range registeredRoutes() {
+			method, path, _ := strings.Cut(route, " ")
+			registered[method+" "+routeParam.ReplaceAllString(path, "{$1}")] = true
+		}
+
+		var stale []string
+		for path, operations := range spec.Paths {
+			for method := range operations {
+				route := strings.ToUpper(method) + " " + path
+				if !registered[route] {
+					stale = append(stale, route)
+				}
+			}
+		}
+		gomega.Expect(stale).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("Should document the pagination parameters of GET /users", func() {
+		var params []string
+		for _, p := range spec.Paths["/users"]["get"].Parameters {
+			if p.In == "query" {
+				params = append(params, p.Name)
+			}
+		}
+		gomega.Expect(params).Should(gomega.ContainElements("page", "pageSize"))
+	})
+})
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// EmailSender delivers transactional email such as verification messages.
+type EmailSender interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPEmailSender sends email through the SMTP server in Config.SMTP.
+type SMTPEmailSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPEmailSender) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + s.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+
+	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
+	return smtp.SendMail(addr, auth, s.From, []string{to}, []byte(message))
+}
+
+// LogEmailSender logs messages instead of sending them. It is used when no
+// SMTP server is configured and in tests.
+type LogEmailSender struct{}
+
+func (LogEmailSender) Send(to, subject, body string) error {
+	log.Infof("Email to %s: %s", to, subject)
+	return nil
+}
+
+// newEmailSender returns an SMTP sender when an SMTP host is configured and a
+// LogEmailSender otherwise.
+func newEmailSender(cfg *Config) EmailSender {
+	if cfg.SMTP.Host == "" {
+		return LogEmailSender{}
+	}
+	return &SMTPEmailSender{
+		Host:     cfg.SMTP.Host,
+		Port:     cfg.SMTP.Port,
+		Username: cfg.SMTP.Username,
+		Password: cfg.SMTP.Password,
+		From:     cfg.SMTP.From,
+	}
+}
+
+// TestEmailRequest is the body of POST /admin/test-email.
+type TestEmailRequest struct {
+	To string `json:"to" validate:"required,email"`
+}
+
+// testEmailHandler sends a sample message through sender so operators can
+// check the SMTP settings of a deployment. A failed send is reported as 502
+// with the sender's error in details.
+func testEmailHandler(sender EmailSender) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req TestEmailRequest
+		if err := c.Bind(&req); err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+		}
+		if err := c.Validate(req); err != nil {
+			return validationError(req, err)
+		}
+
+		body := "This is a test message. If you received it, outgoing email is configured correctly."
+		if err := sender.Send(req.To, "Test email", body); err != nil {
+			log.Warnf("request %s: sending test email to %s: %v", requestID(c), req.To, err)
+			return newAPIError(http.StatusBadGateway, "email_send_failed", "Failed to send email").With("details", err.Error())
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
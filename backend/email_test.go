@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+type sentEmail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// fakeEmailSender records messages instead of sending them.
+type fakeEmailSender struct {
+	mu   sync.Mutex
+	sent []sentEmail
+	err  error
+}
+
+func (f *fakeEmailSender) Send(to, subject, body string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, sentEmail{To: to, Subject: subject, Body: body})
+	return f.err
+}
+
+func (f *fakeEmailSender) Sent() []sentEmail {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]sentEmail(nil), f.sent...)
+}
+
+var testEmailSender = &fakeEmailSender{}
+
+var _ = ginkgo.Describe("Email", func() {
+	ginkgo.Context("createUser", func() {
+		ginkgo.It("Should send the stored verification token to the new user", func() {
+			sender := &fakeEmailSender{}
+			testUser := User{Username: "emailuser", Email: "emailuser@example.com", Password: "password123"}
+			err := createUser(context.Background(), db, sender, &testUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			var verificationToken string
+			err = db.QueryRow("SELECT verification_token FROM users WHERE id = $1", testUser.ID).Scan(&verificationToken)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			sent := sender.Sent()
+			gomega.Expect(sent).Should(gomega.HaveLen(1))
+			gomega.Expect(sent[0].To).Should(gomega.Equal("emailuser@example.com"))
+			gomega.Expect(sent[0].Body).Should(gomega.ContainSubstring(verificationToken))
+		})
+
+		ginkgo.It("Should keep the user when sending fails", func() {
+			sender := &fakeEmailSender{err: errors.New("smtp unavailable")}
+			testUser := User{Username: "emailuser", Email: "emailuser@example.com", Password: "password123"}
+			err := createUser(context.Background(), db, sender, &testUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			user, err := getUserByID(context.Background(), db, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(user.Username).Should(gomega.Equal("emailuser"))
+		})
+	})
+
+	ginkgo.Context("testEmailHandler", func() {
+		send := func(sender EmailSender, body string) *httptest.ResponseRecorder {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			v, err := newValidator(cfg)
+			gomega.Expect(err).Should(gomega.BeNil())
+			server.Validator = &CustomValidator{validator: v}
+			server.POST("/admin/test-email", testEmailHandler(sender))
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/test-email", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec
+		}
+
+		ginkgo.It("Should send a sample email to the given recipient", func() {
+			sender := &fakeEmailSender{}
+			rec := send(sender, `{"to":"ops@example.com"}`)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusNoContent))
+
+			sent := sender.Sent()
+			gomega.Expect(sent).Should(gomega.HaveLen(1))
+			gomega.Expect(sent[0].To).Should(gomega.Equal("ops@example.com"))
+		})
+
+		ginkgo.It("Should report the send error", func() {
+			sender := &fakeEmailSender{err: errors.New("smtp unavailable")}
+			rec := send(sender, `{"to":"ops@example.com"}`)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusBadGateway))
+			gomega.Expect(rec.Body.String()).Should(gomega.ContainSubstring("email_send_failed"))
+			gomega.Expect(rec.Body.String()).Should(gomega.ContainSubstring("smtp unavailable"))
+		})
+
+		ginkgo.It("Should reject an invalid recipient without sending", func() {
+			sender := &fakeEmailSender{}
+			rec := send(sender, `{"to":"not-an-email"}`)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusBadRequest))
+			gomega.Expect(sender.Sent()).Should(gomega.BeEmpty())
+		})
+	})
+})
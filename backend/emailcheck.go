@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// EmailVerifier tells whether mail sent to an address can be delivered.
+// Signup only rejects addresses a verifier reports as undeliverable; errors
+// talking to the provider let the signup through.
+type EmailVerifier interface {
+	Deliverable(ctx context.Context, email string) (bool, error)
+}
+
+// checkDeliverable returns an email_undeliverable error if verifier reports
+// email as undeliverable, and nil otherwise. Every endpoint that creates
+// users runs it; a nil verifier checks nothing.
+func checkDeliverable(c echo.Context, verifier EmailVerifier, email string) *APIError {
+	if verifier == nil {
+		return nil
+	}
+	deliverable, err := verifier.Deliverable(c.Request().Context(), email)
+	if err != nil {
+		log.Warnf("request %s: checking email deliverability: %v", requestID(c), err)
+		return nil
+	}
+	if !deliverable {
+		return newAPIError(validationErrorStatus, "email_undeliverable", "Mail to this email address can't be delivered")
+	}
+	return nil
+}
+
+// newEmailVerifier returns the verifier selected by
+// Config.EmailVerification.Provider, or nil when none is configured.
+func newEmailVerifier(cfg *Config) (EmailVerifier, error) {
+	timeout := cfg.EmailVerification.Timeout.Duration
+	switch cfg.EmailVerification.Provider {
+	case "":
+		return nil, nil
+	case "mx":
+		return &mxEmailVerifier{resolver: net.DefaultResolver, timeout: timeout}, nil
+	case "http":
+		if cfg.EmailVerification.URL == "" {
+			return nil, errors.New("email verification provider http needs a url")
+		}
+		return &httpEmailVerifier{
+			url:    cfg.EmailVerification.URL,
+			apiKey: cfg.EmailVerification.APIKey,
+			client: &http.Client{Timeout: timeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported email verification provider %q", cfg.EmailVerification.Provider)
+	}
+}
+
+// mxEmailVerifier considers an address deliverable when its domain has an
+// MX record, or failing that an address record to fall back to.
+type mxEmailVerifier struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+}
+
+func (v *mxEmailVerifier) Deliverable(ctx context.Context, email string) (bool, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false, nil
+	}
+	domain := email[at+1:]
+
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	mx, err := v.resolver.LookupMX(ctx, domain)
+	if len(mx) > 0 {
+		// A single "." host is a null MX: the domain accepts no mail.
+		return !(len(mx) == 1 && mx[0].Host == "."), nil
+	}
+	if err != nil && !isNotFound(err) {
+		return false, err
+	}
+	addrs, err := v.resolver.LookupHost(ctx, domain)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(addrs) > 0, nil
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// httpEmailVerifier asks a third-party service. It GETs url with the address
+// in the email query parameter and the key as a bearer token, and expects
+// {"deliverable": true|false} back.
+type httpEmailVerifier struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func (v *httpEmailVerifier) Deliverable(ctx context.Context, email string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url+"?email="+url.QueryEscape(email), nil)
+	if err != nil {
+		return false, err
+	}
+	if v.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+v.apiKey)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("email verification provider returned %s", resp.Status)
+	}
+
+	var result struct {
+		Deliverable *bool `json:"deliverable"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	if result.Deliverable == nil {
+		return false, errors.New("email verification provider sent no deliverable field")
+	}
+	return *result.Deliverable, nil
+}
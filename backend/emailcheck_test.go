@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+// fakeEmailVerifier reports the addresses in deliverable as deliverable
+// and every other address as not, or fails with err when it is set.
+type fakeEmailVerifier struct {
+	deliverable map[string]bool
+	err         error
+}
+
+func (v *fakeEmailVerifier) Deliverable(ctx context.Context, email string) (bool, error) {
+	if v.err != nil {
+		return false, v.err
+	}
+	return v.deliverable[email], nil
+}
+
+var _ = ginkgo.Describe("Email Deliverability", func() {
+	ginkgo.Context("Signup", func() {
+		var verifier *fakeEmailVerifier
+
+		ginkgo.BeforeEach(func() {
+			verifier = &fakeEmailVerifier{deliverable: map[string]bool{"good@example.com": true}}
+		})
+
+		signup := func(username, email string) *httptest.ResponseRecorder {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.Validator = e.Validator
+			server.POST("/users", createUserHandler(cfg, db, testEmailSender, verifier))
+
+			payload := `{"username":"` + username + `","email":"` + email + `","password":"password123"}`
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(payload))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec
+		}
+
+		ginkgo.It("Should accept a deliverable address", func() {
+			rec := signup("gooduser", "good@example.com")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusCreated))
+		})
+
+		ginkgo.It("Should reject an undeliverable address", func() {
+			rec := signup("baduser", "bad@example.com")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusBadRequest))
+
+			var body map[string]interface{}
+			gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(gomega.Succeed())
+			gomega.Expect(body["error"]).Should(gomega.Equal("email_undeliverable"))
+
+			var count int
+			gomega.Expect(db.QueryRow("SELECT COUNT(*) FROM users WHERE username = 'baduser'").Scan(&count)).Should(gomega.Succeed())
+			gomega.Expect(count).Should(gomega.BeZero())
+		})
+
+		ginkgo.It("Should let the signup through when the provider fails", func() {
+			verifier.err = errors.New("provider down")
+			rec := signup("baduser", "bad@example.com")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusCreated))
+		})
+
+		ginkgo.It("Should reject a batch with an undeliverable address before creating anyone", func() {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.Validator = e.Validator
+			server.POST("/users/batch", createUsersHandler(cfg, db, testEmailSender, verifier))
+
+			payload := `[{"username":"gooduser","email":"good@example.com","password":"password123"},` +
+				`{"username":"baduser","email":"bad@example.com","password":"password123"}]`
+			req := httptest.NewRequest(http.MethodPost, "/users/batch", strings.NewReader(payload))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusBadRequest))
+
+			var body map[string]interface{}
+			gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(gomega.Succeed())
+			gomega.Expect(body["error"]).Should(gomega.Equal("email_undeliverable"))
+			gomega.Expect(body["index"]).Should(gomega.BeNumerically("==", 1))
+
+			var count int
+			gomega.Expect(db.QueryRow("SELECT COUNT(*) FROM users WHERE username IN ('gooduser', 'baduser')").Scan(&count)).Should(gomega.Succeed())
+			gomega.Expect(count).Should(gomega.BeZero())
+		})
+	})
+
+	ginkgo.Context("httpEmailVerifier", func() {
+		ginkgo.It("Should ask the provider about the address", func() {
+			provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gomega.Expect(r.Header.Get("Authorization")).Should(gomega.Equal("Bearer secret"))
+				deliverable := r.URL.Query().Get("email") == "good@example.com"
+				json.NewEncoder(w).Encode(map[string]bool{"deliverable": deliverable})
+			}))
+			defer provider.Close()
+
+			verifierCfg := *cfg
+			verifierCfg.EmailVerification.Provider = "http"
+			verifierCfg.EmailVerification.URL = provider.URL
+			verifierCfg.EmailVerification.APIKey = "secret"
+			verifier, err := newEmailVerifier(&verifierCfg)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			ok, err := verifier.Deliverable(context.Background(), "good@example.com")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(ok).Should(gomega.BeTrue())
+
+			ok, err = verifier.Deliverable(context.Background(), "bad@example.com")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(ok).Should(gomega.BeFalse())
+		})
+	})
+})
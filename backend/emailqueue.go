@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/labstack/gommon/log"
+)
+
+// errEmailQueueFull is returned by EmailQueue.Send when every worker is busy
+// and the buffer is full. The message is dropped.
+var errEmailQueueFull = errors.New("email queue is full")
+
+type emailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// EmailQueue is an EmailSender that hands messages to a fixed number of
+// workers through a buffered channel, so a burst of signups can't start an
+// unbounded number of SMTP sends. Send only reports whether the message was
+// queued; delivery failures are logged by the workers.
+type EmailQueue struct {
+	sender EmailSender
+	queue  chan emailMessage
+	wg     sync.WaitGroup
+}
+
+// newEmailQueue starts workers goroutines delivering through sender, with
+// room for size messages waiting.
+func newEmailQueue(sender EmailSender, workers, size int) *EmailQueue {
+	q := &EmailQueue{sender: sender, queue: make(chan emailMessage, size)}
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *EmailQueue) work() {
+	defer q.wg.Done()
+	for msg := range q.queue {
+		if err := q.sender.Send(msg.To, msg.Subject, msg.Body); err != nil {
+			log.Warnf("Error sending email to %s: %v", msg.To, err)
+		}
+	}
+}
+
+func (q *EmailQueue) Send(to, subject, body string) error {
+	select {
+	case q.queue <- emailMessage{To: to, Subject: subject, Body: body}:
+		return nil
+	default:
+		log.Warnf("Dropping email to %s: %v", to, errEmailQueueFull)
+		return errEmailQueueFull
+	}
+}
+
+// Close stops accepting messages and waits for the queued ones to be sent.
+// Send must not be called after Close.
+func (q *EmailQueue) Close() {
+	close(q.queue)
+	q.wg.Wait()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+// blockingEmailSender holds every send until release is closed.
+type blockingEmailSender struct {
+	fakeEmailSender
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingEmailSender) Send(to, subject, body string) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.fakeEmailSender.Send(to, subject, body)
+}
+
+var _ = ginkgo.Describe("Email Queue", func() {
+	ginkgo.It("Should drop messages once the workers are busy and the buffer is full", func() {
+		sender := &blockingEmailSender{started: make(chan struct{}, 10), release: make(chan struct{})}
+		queue := newEmailQueue(sender, 2, 3)
+
+		// Two messages occupy the workers, three more fill the buffer.
+		for i := 0; i < 2; i++ {
+			gomega.Expect(queue.Send("busy@example.com", "Subject", "Body")).Should(gomega.Succeed())
+		}
+		gomega.Eventually(sender.started).Should(gomega.Receive())
+		gomega.Eventually(sender.started).Should(gomega.Receive())
+		for i := 0; i < 3; i++ {
+			gomega.Expect(queue.Send("queued@example.com", "Subject", "Body")).Should(gomega.Succeed())
+		}
+
+		gomega.Expect(queue.Send("dropped@example.com", "Subject", "Body")).Should(gomega.Equal(errEmailQueueFull))
+
+		close(sender.release)
+		queue.Close()
+
+		sent := sender.Sent()
+		gomega.Expect(sent).Should(gomega.HaveLen(5))
+		for _, msg := range sent {
+			gomega.Expect(msg.To).ShouldNot(gomega.Equal("dropped@example.com"))
+		}
+	})
+
+	ginkgo.It("Should deliver everything queued before Close", func() {
+		sender := &fakeEmailSender{}
+		queue := newEmailQueue(sender, 3, 20)
+		for i := 0; i < 20; i++ {
+			gomega.Expect(queue.Send("user@example.com", "Subject", "Body")).Should(gomega.Succeed())
+		}
+		queue.Close()
+		gomega.Expect(sender.Sent()).Should(gomega.HaveLen(20))
+	})
+})
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// APIError is an error returned by handlers and middleware. httpErrorHandler
+// renders it as {"error": Code, "message": Message, "request_id": ...} plus
+// any extra fields. Code is stable and meant for clients to match on.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  map[string]interface{}
+}
+
+func newAPIError(status int, code string, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func (e *APIError) Error() string {
+	return e.Code
+}
+
+// errDatabaseTimeout is returned instead of a 500 when a query ran past
+// Config.Database.QueryTimeout.
+var errDatabaseTimeout = newAPIError(http.StatusServiceUnavailable, "database_timeout", "The database took too long to respond")
+
+// databaseError returns errDatabaseTimeout if err is a query timeout and a
+// 500 with code and message otherwise.
+func databaseError(err error, code string, message string) *APIError {
+	if isQueryTimeout(err) {
+		return errDatabaseTimeout
+	}
+	return newAPIError(http.StatusInternalServerError, code, message)
+}
+
+// deletedEmailConflictError is returned by createUser when the email belongs
+// to a soft-deleted user. Its message matches the plain conflict so callers
+// that don't offer restoring treat it the same way.
+type deletedEmailConflictError struct {
+	UserID int
+	UUID   string
+}
+
+func (e *deletedEmailConflictError) Error() string {
+	return "username_or_email_exists"
+}
+
+// duplicateUserError is returned when a write would reuse another user's
+// username or email. Field is "username" or "email", or empty if unknown.
+// Like deletedEmailConflictError, its message is the plain conflict code.
+type duplicateUserError struct {
+	Field string
+}
+
+func (e *duplicateUserError) Error() string {
+	return "username_or_email_exists"
+}
+
+// duplicateField reports which field of a new or updated user collided with
+// an existing user, given both usernames.
+func duplicateField(existingUsername, username string) string {
+	if strings.EqualFold(existingUsername, username) {
+		return "username"
+	}
+	return "email"
+}
+
+// usernameOrEmailExistsError renders a username_or_email_exists error as a
+// 400, naming the field that collided when it is known.
+func usernameOrEmailExistsError(err error) *APIError {
+	apiErr := newAPIError(http.StatusBadRequest, "username_or_email_exists", "Username or email already exists")
+	var dup *duplicateUserError
+	if errors.As(err, &dup) && dup.Field != "" {
+		apiErr = apiErr.With("field", dup.Field)
+	}
+	return apiErr
+}
+
+// fieldTakenError renders a username_or_email_exists error from an update as
+// a 409 naming the field, e.g. {"error": "email_taken", "field": "email"}.
+// Collisions whose field is unknown fall back to usernameOrEmailExistsError.
+func fieldTakenError(err error) *APIError {
+	var dup *duplicateUserError
+	if !errors.As(err, &dup) || dup.Field == "" {
+		return usernameOrEmailExistsError(err)
+	}
+	return newAPIError(http.StatusConflict, dup.Field+"_taken", "That "+dup.Field+" is already taken").With("field", dup.Field)
+}
+
+// With returns a copy of e with an extra field added to the response body.
+func (e *APIError) With(key string, value interface{}) *APIError {
+	fields := make(map[string]interface{}, len(e.Fields)+1)
+	for k, v := range e.Fields {
+		fields[k] = v
+	}
+	fields[key] = value
+
+	withField := *e
+	withField.Fields = fields
+	return &withField
+}
+
+// httpErrorHandler renders errors returned from handlers. Echo's own errors,
+// such as 404 for unknown routes, get the same envelope with a code derived
+// from the status; anything else is a 500.
+func httpErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		if he, isHTTPError := err.(*echo.HTTPError); isHTTPError {
+			message := http.StatusText(he.Code)
+			if m, isString := he.Message.(string); isString {
+				message = m
+			}
+			apiErr = newAPIError(he.Code, strings.ReplaceAll(strings.ToLower(http.StatusText(he.Code)), " ", "_"), message)
+		} else {
+			// Handlers log their own failures; this is for errors nobody
+			// turned into an APIError.
+			log.Errorf("request %s: %s %s: %v", requestID(c), c.Request().Method, c.Path(), err)
+			apiErr = newAPIError(http.StatusInternalServerError, "internal_error", "Internal server error")
+		}
+	}
+
+	body := map[string]interface{}{"error": apiErr.Code, "message": apiErr.Message}
+	for k, v := range apiErr.Fields {
+		body[k] = v
+	}
+	if id := requestID(c); id != "" {
+		body["request_id"] = id
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(apiErr.Status)
+	} else {
+		err = c.JSON(apiErr.Status, body)
+	}
+	if err != nil {
+		log.Errorf("Error writing error response: %v", err)
+	}
+}
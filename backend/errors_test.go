@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Error Responses", func() {
+	var server *echo.Echo
+
+	ginkgo.BeforeEach(func() {
+		server = echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.GET("/api-error", func(c echo.Context) error {
+			return newAPIError(http.StatusBadRequest, "username_or_email_exists", "Username or email already exists").With("index", 2)
+		})
+		server.GET("/plain-error", func(c echo.Context) error {
+			return errors.New("something broke")
+		})
+	})
+
+	get := func(target string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(echo.HeaderXRequestID, "req-7")
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		var body map[string]interface{}
+		gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(gomega.Succeed())
+		return rec.Code, body
+	}
+
+	ginkgo.It("Should render an APIError with its code, message and fields", func() {
+		status, body := get("/api-error")
+		gomega.Expect(status).Should(gomega.Equal(http.StatusBadRequest))
+		gomega.Expect(body).Should(gomega.Equal(map[string]interface{}{
+			"error":      "username_or_email_exists",
+			"message":    "Username or email already exists",
+			"index":      float64(2),
+			"request_id": "req-7",
+		}))
+	})
+
+	ginkgo.It("Should render Echo's errors in the same envelope", func() {
+		status, body := get("/no-such-route")
+		gomega.Expect(status).Should(gomega.Equal(http.StatusNotFound))
+		gomega.Expect(body["error"]).Should(gomega.Equal("not_found"))
+		gomega.Expect(body["request_id"]).Should(gomega.Equal("req-7"))
+	})
+
+	ginkgo.It("Should hide unexpected errors behind a 500", func() {
+		status, body := get("/plain-error")
+		gomega.Expect(status).Should(gomega.Equal(http.StatusInternalServerError))
+		gomega.Expect(body["error"]).Should(gomega.Equal("internal_error"))
+		gomega.Expect(body["message"]).ShouldNot(gomega.ContainSubstring("something broke"))
+	})
+
+	ginkgo.It("Should not modify the original error when adding fields", func() {
+		base := newAPIError(http.StatusBadRequest, "validation_failed", "Validation failed")
+		base.With("details", "x")
+		gomega.Expect(base.Fields).Should(gomega.BeNil())
+	})
+})
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("ETags", func() {
+	ginkgo.Context("listETag", func() {
+		ginkgo.It("Should stay the same until the list changes", func() {
+			testUser := User{Username: "etaguser", Email: "etaguser@example.com", Password: "password123"}
+			err := createUser(context.Background(), db, testEmailSender, &testUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			first, err := listETag(db, UserFilter{}, "page=1")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			second, err := listETag(db, UserFilter{}, "page=1")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(etagMatches(first, second)).Should(gomega.BeTrue())
+
+			testUser.Bio = "changed"
+			err = updateUser(context.Background(), db, testUser.ID, &testUser, nil)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			third, err := listETag(db, UserFilter{}, "page=1")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(etagMatches(first, third)).Should(gomega.BeFalse())
+		})
+
+		ginkgo.It("Should differ between pages", func() {
+			first, err := listETag(db, UserFilter{}, "page=1")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			second, err := listETag(db, UserFilter{}, "page=2")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(first).ShouldNot(gomega.Equal(second))
+		})
+	})
+
+	ginkgo.Context("GET /users/:id", func() {
+		get := func(id int, ifNoneMatch string) *httptest.ResponseRecorder {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.GET("/users/:id", getUserHandler(db))
+
+			req := httptest.NewRequest(http.MethodGet, "/users/"+strconv.Itoa(id), nil)
+			if ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec
+		}
+
+		ginkgo.It("Should return 304 while the user is unchanged", func() {
+			testUser := User{Username: "etagprofile", Email: "etagprofile@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+
+			rec := get(testUser.ID, "")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			etag := rec.Header().Get("ETag")
+			gomega.Expect(etag).ShouldNot(gomega.BeEmpty())
+
+			rec = get(testUser.ID, etag)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusNotModified))
+			gomega.Expect(rec.Body.Len()).Should(gomega.BeZero())
+
+			testUser.Bio = "changed"
+			gomega.Expect(updateUser(context.Background(), db, testUser.ID, &testUser, nil)).Should(gomega.Succeed())
+
+			rec = get(testUser.ID, etag)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(rec.Header().Get("ETag")).ShouldNot(gomega.Equal(etag))
+		})
+	})
+
+	ginkgo.Context("If-Match", func() {
+		var testUser User
+
+		ginkgo.BeforeEach(func() {
+			testUser = User{Username: "ifmatchuser", Email: "ifmatchuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+		})
+
+		update := func(method, payload, ifMatch string) *httptest.ResponseRecorder {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.Validator = e.Validator
+			server.PUT("/users/:id", updateUserHandler(db))
+			server.PATCH("/users/:id", patchUserHandler(db))
+
+			req := httptest.NewRequest(method, "/users/"+strconv.Itoa(testUser.ID), strings.NewReader(payload))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if ifMatch != "" {
+				req.Header.Set("If-Match", ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec
+		}
+
+		currentETag := func() string {
+			user, err := loadUser(context.Background(), db, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			return userETag(user)
+		}
+
+		ginkgo.It("Should reject a stale PUT after another update", func() {
+			etag := currentETag()
+
+			rec := update(http.MethodPatch, `{"bio":"first admin"}`, etag)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(rec.Header().Get("ETag")).Should(gomega.Equal(currentETag()))
+
+			rec = update(http.MethodPut, `{"username":"ifmatchuser","email":"ifmatchuser@example.com","bio":"second admin"}`, etag)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusPreconditionFailed))
+
+			user, err := loadUser(context.Background(), db, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(user.Bio).Should(gomega.Equal("first admin"))
+		})
+
+		ginkgo.It("Should apply an update with the current ETag", func() {
+			rec := update(http.MethodPut, `{"username":"ifmatchuser","email":"ifmatchuser@example.com","bio":"fresh"}`, currentETag())
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(rec.Header().Get("ETag")).Should(gomega.Equal(currentETag()))
+
+			rec = update(http.MethodPatch, `{"bio":"no precondition"}`, "")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("Should not accept a weak ETag", func() {
+			rec := update(http.MethodPatch, `{"bio":"weak"}`, "W/"+currentETag())
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusPreconditionFailed))
+		})
+	})
+
+	ginkgo.Context("etagMatches", func() {
+		ginkgo.It("Should match weakly and within a list", func() {
+			gomega.Expect(etagMatches(`"abc"`, `W/"abc"`)).Should(gomega.BeTrue())
+			gomega.Expect(etagMatches(`W/"xyz", W/"abc"`, `W/"abc"`)).Should(gomega.BeTrue())
+			gomega.Expect(etagMatches(`*`, `W/"abc"`)).Should(gomega.BeTrue())
+			gomega.Expect(etagMatches(`W/"xyz"`, `W/"abc"`)).Should(gomega.BeFalse())
+			gomega.Expect(etagMatches("", `W/"abc"`)).Should(gomega.BeFalse())
+		})
+	})
+})
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// exportPageSize is how many users the CSV export reads per query.
+const exportPageSize = 500
+
+// exportSort orders exports by id so the cursor between pages is just the
+// last id.
+var exportSort = UserSort{Column: "id"}
+
+// userSnapshot pages through users inside one read-only REPEATABLE READ
+// transaction. Every page sees the database as it was when the snapshot
+// began, so users created or deleted during a long export don't shift later
+// pages or show up partway through.
+type userSnapshot struct {
+	tx     *sql.Tx
+	filter UserFilter
+	after  *userCursor
+}
+
+func beginUserSnapshot(ctx context.Context, db *sql.DB, filter UserFilter) (*userSnapshot, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return &userSnapshot{tx: tx, filter: filter}, nil
+}
+
+// next returns up to pageSize users following the previous page. It returns
+// no users once the snapshot is exhausted.
+func (s *userSnapshot) next(ctx context.Context, pageSize int) ([]User, error) {
+	queryBuilder := usersQuery(s.filter, exportSort).Limit(uint64(pageSize))
+	if s.after != nil {
+		queryBuilder = queryBuilder.Where(s.after.predicate(exportSort))
+	}
+	users, err := queryUsers(ctx, s.tx, queryBuilder)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) > 0 {
+		s.after = &userCursor{ID: users[len(users)-1].ID}
+	}
+	return users, nil
+}
+
+// close ends the snapshot. The transaction only read, so it is rolled back.
+func (s *userSnapshot) close() error {
+	return s.tx.Rollback()
+}
+
+var userCSVHeader = []string{"id", "tenant_id", "username", "email", "profile_picture_url", "bio", "timezone", "created_at", "updated_at"}
+
+// writeUsersCSV writes every user in snapshot to w as CSV, pageSize users at
+// a time. flush, if set, is called after each page so the response streams.
+func writeUsersCSV(ctx context.Context, w io.Writer, snapshot *userSnapshot, pageSize int, flush func()) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(userCSVHeader); err != nil {
+		return err
+	}
+	for {
+		users, err := snapshot.next(ctx, pageSize)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			break
+		}
+		for _, u := range users {
+			record := []string{
+				fmt.Sprint(publicUserID(u.ID, u.UUID)),
+				strconv.Itoa(u.TenantID),
+				u.Username,
+				u.Email,
+				u.ProfilePictureURL,
+				u.Bio,
+				u.Timezone,
+				u.CreatedAt.UTC().Format(time.RFC3339),
+				u.UpdatedAt.UTC().Format(time.RFC3339),
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+		if flush != nil {
+			flush()
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
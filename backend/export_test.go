@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("User Export", func() {
+	var seeded []User
+
+	ginkgo.BeforeEach(func() {
+		seeded = nil
+		for i := 1; i <= 3; i++ {
+			user := User{Username: fmt.Sprintf("exportuser%d", i), Email: fmt.Sprintf("exportuser%d@example.com", i), Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			seeded = append(seeded, user)
+		}
+	})
+
+	usernames := func(users []User) []string {
+		names := make([]string, len(users))
+		for i, u := range users {
+			names[i] = u.Username
+		}
+		return names
+	}
+
+	ginkgo.It("Should not see rows changed after the snapshot began", func() {
+		snapshot, err := beginUserSnapshot(context.Background(), db, UserFilter{})
+		gomega.Expect(err).Should(gomega.BeNil())
+		defer snapshot.close()
+
+		first, err := snapshot.next(context.Background(), 2)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(usernames(first)).Should(gomega.Equal([]string{"exportuser1", "exportuser2"}))
+
+		// Changes made outside the snapshot between pages.
+		late := User{Username: "lateuser", Email: "lateuser@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &late)).Should(gomega.Succeed())
+		gomega.Expect(deleteUser(context.Background(), db, seeded[2].ID)).Should(gomega.Succeed())
+
+		rest, err := snapshot.next(context.Background(), 2)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(usernames(rest)).Should(gomega.Equal([]string{"exportuser3"}))
+
+		done, err := snapshot.next(context.Background(), 2)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(done).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("Should write every user as CSV across pages", func() {
+		snapshot, err := beginUserSnapshot(context.Background(), db, UserFilter{})
+		gomega.Expect(err).Should(gomega.BeNil())
+		defer snapshot.close()
+
+		var buf bytes.Buffer
+		flushes := 0
+		gomega.Expect(writeUsersCSV(context.Background(), &buf, snapshot, 2, func() { flushes++ })).Should(gomega.Succeed())
+		gomega.Expect(flushes).Should(gomega.Equal(2))
+
+		records, err := csv.NewReader(&buf).ReadAll()
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(records).Should(gomega.HaveLen(4))
+		gomega.Expect(records[0]).Should(gomega.Equal(userCSVHeader))
+		gomega.Expect(records[1][2]).Should(gomega.Equal("exportuser1"))
+		gomega.Expect(records[3][3]).Should(gomega.Equal("exportuser3@example.com"))
+	})
+})
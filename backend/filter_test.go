@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("User Filters", func() {
+	ginkgo.Context("parseFilterExpression", func() {
+		ginkgo.It("Should combine multiple conditions", func() {
+			filter, err := parseFilterExpression("verified:true,tenant_id:3")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(*filter.Verified).Should(gomega.BeTrue())
+			gomega.Expect(*filter.TenantID).Should(gomega.Equal(3))
+
+			sql, args, err := statementBuilder.Select("id").From("users").Where(filter.predicate()).ToSql()
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(sql).Should(gomega.Equal("SELECT id FROM users WHERE (deleted_at IS NULL AND tenant_id = $1 AND email_verified = $2)"))
+			gomega.Expect(args).Should(gomega.Equal([]interface{}{3, true}))
+		})
+
+		ginkgo.It("Should filter by a known role", func() {
+			admin := User{Username: "filteradmin", Email: "filteradmin@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &admin)).Should(gomega.Succeed())
+			_, err := db.Exec("UPDATE users SET role = $1, email_verified = TRUE WHERE id = $2", roleAdmin, admin.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			member := User{Username: "filtermember", Email: "filtermember@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &member)).Should(gomega.Succeed())
+			_, err = db.Exec("UPDATE users SET email_verified = TRUE WHERE id = $1", member.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			filter, err := parseFilterExpression("verified:true,role:admin")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(filter.Role).Should(gomega.Equal(roleAdmin))
+
+			users, err := getUsers(context.Background(), db, 1, 10, filter, defaultUserSort)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(users).Should(gomega.HaveLen(1))
+			gomega.Expect(users[0].ID).Should(gomega.Equal(admin.ID))
+
+			_, err = parseFilterExpression("role:superuser")
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("Should reject unknown keys", func() {
+			_, err := parseFilterExpression("password:secret")
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("Should reject an injection attempt", func() {
+			_, err := parseFilterExpression("verified:true) OR 1=1 --")
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+
+			_, err = parseFilterExpression("deleted_at IS NOT NULL OR id:1")
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("Should return only matching users", func() {
+			verifiedUser := User{Username: "verified", Email: "verified@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &verifiedUser)).Should(gomega.Succeed())
+			_, err := db.Exec("UPDATE users SET email_verified = TRUE WHERE id = $1", verifiedUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			unverifiedUser := User{Username: "unverified", Email: "unverified@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &unverifiedUser)).Should(gomega.Succeed())
+
+			filter, err := parseFilterExpression("verified:true,tenant_id:0")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			users, err := getUsers(context.Background(), db, 1, 10, filter, defaultUserSort)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(users).Should(gomega.HaveLen(1))
+			gomega.Expect(users[0].ID).Should(gomega.Equal(verifiedUser.ID))
+		})
+	})
+
+	ginkgo.Context("Search", func() {
+		ginkgo.BeforeEach(func() {
+			for _, u := range []User{
+				{Username: "JaneDoe", Email: "jane@example.com", Password: "password123"},
+				{Username: "john_smith", Email: "john@example.org", Password: "password123"},
+				{Username: "johnXsmith", Email: "other@example.org", Password: "password123"},
+			} {
+				user := u
+				gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			}
+		})
+
+		usernames := func(filter UserFilter) []string {
+			users, err := getUsers(context.Background(), db, 1, 10, filter, UserSort{Column: "username"})
+			gomega.Expect(err).Should(gomega.BeNil())
+			var names []string
+			for _, u := range users {
+				names = append(names, u.Username)
+			}
+			return names
+		}
+
+		ginkgo.It("Should match username or email case-insensitively", func() {
+			gomega.Expect(usernames(UserFilter{Search: "jane"})).Should(gomega.Equal([]string{"JaneDoe"}))
+			gomega.Expect(usernames(UserFilter{Search: "EXAMPLE.ORG"})).Should(gomega.Equal([]string{"john_smith", "johnXsmith"}))
+		})
+
+		ginkgo.It("Should match only the exact case when case-sensitive", func() {
+			gomega.Expect(usernames(UserFilter{Search: "jane", CaseSensitive: true})).Should(gomega.BeEmpty())
+			gomega.Expect(usernames(UserFilter{Search: "Jane", CaseSensitive: true})).Should(gomega.Equal([]string{"JaneDoe"}))
+			gomega.Expect(usernames(UserFilter{Search: "EXAMPLE.ORG", CaseSensitive: true})).Should(gomega.BeEmpty())
+		})
+
+		ginkgo.It("Should rank exact matches ahead of prefix and substring matches", func() {
+			for _, u := range []User{
+				{Username: "xjohn", Email: "xjohn@example.net", Password: "password123"},
+				{Username: "john", Email: "exact@example.net", Password: "password123"},
+			} {
+				user := u
+				gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			}
+
+			// By username descending alone, the substring match xjohn would
+			// come first and the exact match john last.
+			users, err := getUsers(context.Background(), db, 1, 10, UserFilter{Search: "JOHN"}, UserSort{Column: "username", Descending: true})
+			gomega.Expect(err).Should(gomega.BeNil())
+			var names []string
+			for _, u := range users {
+				names = append(names, u.Username)
+			}
+			gomega.Expect(names).Should(gomega.Equal([]string{"john", "johnXsmith", "john_smith", "xjohn"}))
+		})
+
+		ginkgo.It("Should treat wildcards in the term literally", func() {
+			gomega.Expect(usernames(UserFilter{Search: "john_"})).Should(gomega.Equal([]string{"john_smith"}))
+			gomega.Expect(usernames(UserFilter{Search: "%"})).Should(gomega.BeEmpty())
+		})
+
+		ginkgo.It("Should filter by exact email", func() {
+			gomega.Expect(usernames(UserFilter{Email: "john@example.org"})).Should(gomega.Equal([]string{"john_smith"}))
+			gomega.Expect(usernames(UserFilter{Email: "john@example"})).Should(gomega.BeEmpty())
+		})
+
+		ginkgo.It("Should exclude soft-deleted users", func() {
+			_, err := db.Exec("UPDATE users SET deleted_at = NOW() WHERE username = 'JaneDoe'")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(usernames(UserFilter{Search: "jane"})).Should(gomega.BeEmpty())
+		})
+	})
+
+	ginkgo.Context("GET /users/count", func() {
+		count := func(query string) (int, CountResponse) {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.GET("/users/count", countUsersHandler(cfg, db))
+
+			req := httptest.NewRequest(http.MethodGet, "/users/count?"+query, nil)
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+
+			var body CountResponse
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			return rec.Code, body
+		}
+
+		ginkgo.BeforeEach(func() {
+			for _, name := range []string{"countalice", "countalbert", "countbob"} {
+				user := User{Username: name, Email: name + "@example.com", Password: "password123"}
+				gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			}
+			_, err := db.Exec("UPDATE users SET email_verified = TRUE WHERE username IN ('countalice', 'countbob')")
+			gomega.Expect(err).Should(gomega.BeNil())
+		})
+
+		ginkgo.It("Should count users matching both q and verified", func() {
+			code, body := count("q=countal")
+			gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(body.Count).Should(gomega.Equal(2))
+
+			_, body = count("q=countal&verified=true")
+			gomega.Expect(body.Count).Should(gomega.Equal(1))
+
+			_, body = count("q=count&verified=false")
+			gomega.Expect(body.Count).Should(gomega.Equal(1))
+		})
+
+		ginkgo.It("Should reject a verified value that isn't a boolean", func() {
+			code, _ := count("verified=maybe")
+			gomega.Expect(code).Should(gomega.Equal(http.StatusBadRequest))
+		})
+	})
+})
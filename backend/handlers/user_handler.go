@@ -8,7 +8,6 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/Masterminds/squirrel"
 	"github.com/labstack/echo/v4"
 	_ "github.com/lib/pq"
 )
@@ -16,122 +15,32 @@ import (
 var ErrNoRowsAffected = errors.New("no rows affected")
 
 type User struct {
-	ID        int       `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       int    `json:"id"`
+	TenantID int    `json:"tenant_id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	// Role is filled in by the database default; it can't be set on
+	// create.
+	Role      string     `json:"role"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type UserHandler struct {
-	DB *sql.DB
+	Repo UserRepository
 }
 
-func getUsers(db *sql.DB) ([]User, error) {
-	queryBuilder := squirrel.Select("id", "username", "email", "created_at", "updated_at").From("users")
-	sql, args, err := queryBuilder.ToSql()
-	if err != nil {
-		return nil, err
-	}
-
-	rows, err := db.Query(sql, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []User
-	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.CreatedAt, &u.UpdatedAt); err != nil {
-			return nil, err
-		}
-		users = append(users, u)
-	}
-	return users, nil
-}
-
-func getUserByID(db *sql.DB, id int) (User, error) {
-	var user User
-	queryBuilder := squirrel.Select("id", "username", "email", "created_at", "updated_at").From("users").Where(squirrel.Eq{"id": id})
-	sql, args, err := queryBuilder.ToSql()
-	if err != nil {
-		return user, err
-	}
-
-	err = db.QueryRow(sql, args...).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.UpdatedAt)
-	if err != nil {
-		return user, err
-	}
-	return user, nil
-}
-
-func createUser(db *sql.DB, user *User) error {
-	var existingUser User
-	err := db.QueryRow("SELECT id FROM users WHERE username = $1 OR email = $2", user.Username, user.Email).Scan(&existingUser.ID)
-	if err != nil && err != sql.ErrNoRows {
-		return err
-	}
-	if existingUser.ID != 0 {
-		return errors.New("username_or_email_exists")
-	}
-
-	queryBuilder := squirrel.Insert("users").Columns("username", "email").Values(user.Username, user.Email).Suffix("RETURNING id, created_at, updated_at")
-	sql, args, err := queryBuilder.ToSql()
-	if err != nil {
-		return err
-	}
-
-	err = db.QueryRow(sql, args...).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func updateUser(db *sql.DB, id int, user *User) error {
-	var existingUser User
-	err := db.QueryRow("SELECT id FROM users WHERE (username = $1 OR email = $2) AND id != $3", user.Username, user.Email, id).Scan(&existingUser.ID)
-	if err != nil && err != sql.ErrNoRows {
-		return err
-	}
-	if existingUser.ID != 0 {
-		return errors.New("username_or_email_exists")
-	}
-
-	queryBuilder := squirrel.Update("users").Set("username", user.Username).Set("email", user.Email).Where(squirrel.Eq{"id": id}).Suffix("RETURNING updated_at")
-	sql, args, err := queryBuilder.ToSql()
-	if err != nil {
-		return err
-	}
-
-	err = db.QueryRow(sql, args...).Scan(&user.UpdatedAt)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func deleteUser(db *sql.DB, id int) error {
-	queryBuilder := squirrel.Delete("users").Where(squirrel.Eq{"id": id})
-	sql, args, err := queryBuilder.ToSql()
-	if err != nil {
-		return err
-	}
-
-	_, err = db.Exec(sql, args...)
-	if err != nil {
-		return err
-	}
-	return nil
+func NewUserHandler(db *sql.DB) *UserHandler {
+	return NewUserHandlerWithRepository(NewSQLUserRepository(db))
 }
 
-func NewUserHandler(db *sql.DB) *UserHandler {
-	return &UserHandler{DB: db}
+func NewUserHandlerWithRepository(repo UserRepository) *UserHandler {
+	return &UserHandler{Repo: repo}
 }
 
 func (h *UserHandler) GetUsers(c echo.Context) error {
-	users, err := getUsers(h.DB)
+	users, err := h.Repo.List()
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Failed to retrieve users"})
 	}
@@ -144,7 +53,7 @@ func (h *UserHandler) GetUserByID(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "Invalid user ID"})
 	}
 
-	user, err := getUserByID(h.DB, id)
+	user, err := h.Repo.GetByID(id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.JSON(http.StatusNotFound, map[string]interface{}{"error": "User not found"})
@@ -166,7 +75,7 @@ func (h *UserHandler) CreateUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "validation_failed", "details": err.Error()})
 	}
 
-	err := createUser(h.DB, &user)
+	err := h.Repo.Create(&user)
 	if err != nil {
 		if err.Error() == "username_or_email_exists" {
 			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "username_or_email_exists"})
@@ -195,7 +104,7 @@ func (h *UserHandler) UpdateUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "validation_failed", "details": err.Error()})
 	}
 
-	err = updateUser(h.DB, id, &user)
+	err = h.Repo.Update(id, &user)
 	if err != nil {
 		if errors.Is(err, ErrNoRowsAffected) {
 			log.Printf("No user found with ID %d to update", id)
@@ -217,9 +126,9 @@ func (h *UserHandler) DeleteUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "Invalid user ID"})
 	}
 
-	err = deleteUser(h.DB, id)
+	err = h.Repo.Delete(id)
 	if err != nil {
-		if err.Error() == "user not found" {
+		if errors.Is(err, ErrNoRowsAffected) {
 			log.Printf("No user found with ID %d to delete", id)
 			return c.JSON(http.StatusNotFound, map[string]interface{}{"error": "User not found"})
 		}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -80,6 +81,22 @@ ginkgo.Describe("User Handler", func() {
 			gomega.Expect(createdUser.Email).To(gomega.Equal("testuser@example.com"))
 		})
 
+		ginkgo.It("Should return fields filled in by the database", func() {
+			reqBody := `{"username":"defaultsuser","email":"defaultsuser@example.com","role":"admin"}`
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(reqBody))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.POST("/users", userHandler.CreateUser).ServeHTTP(rec, req)
+			gomega.Expect(rec.Code).To(gomega.Equal(http.StatusCreated))
+
+			// role is never inserted, so it comes from the column default.
+			var createdUser handlers.User
+			json.Unmarshal(rec.Body.Bytes(), &createdUser)
+			gomega.Expect(createdUser.ID).ToNot(gomega.BeZero())
+			gomega.Expect(createdUser.Role).To(gomega.Equal("user"))
+			gomega.Expect(createdUser.CreatedAt.IsZero()).To(gomega.BeFalse())
+		})
+
 		ginkgo.It("Should return an error for invalid user data", func() {
 			// define a user with invalid data
 			testUser := models.User{Username: "", Email: "invalid_email"}
@@ -308,6 +325,47 @@ ginkgo.Describe("User Handler", func() {
 			json.Unmarshal(rec.Body.Bytes(), &response)
 			gomega.Expect(response["error"]).To(gomega.Not(gomega.BeNil()))
 		})
+
+		ginkgo.It("Should return a 404 error when updating a non-existent user ID with a valid payload", func() {
+			// create a test request with a valid body and a non-existent user ID
+			reqBody, _ := json.Marshal(models.User{Username: "nobody", Email: "nobody@example.com"})
+			req := httptest.NewRequest(http.MethodPut, "/users/999", strings.NewReader(string(reqBody)))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.PUT("/users/:id", userHandler.UpdateUser).ServeHTTP(rec, req)
+
+			// assertions
+			gomega.Expect(rec.Code).To(gomega.Equal(http.StatusNotFound))
+
+			// get the error message from the response body
+			var response map[string]interface{}
+			json.Unmarshal(rec.Body.Bytes(), &response)
+			gomega.Expect(response["error"]).To(gomega.Equal("user_not_found"))
+		})
+
+		ginkgo.It("Should return a 404 error when updating a soft-deleted user", func() {
+			// create a test user and soft delete it
+			testUser := models.User{Username: "testuser", Email: "testuser@example.com"}
+			db.Create(&testUser)
+			var userID int
+			db.Model(&testUser).Select("id").Scan(&userID)
+			db.Exec("UPDATE users SET deleted_at = NOW() WHERE id = ?", userID)
+
+			// create a test request
+			reqBody, _ := json.Marshal(models.User{Username: "updateduser", Email: "updateduser@example.com"})
+			req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/users/%d", userID), strings.NewReader(string(reqBody)))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.PUT("/users/:id", userHandler.UpdateUser).ServeHTTP(rec, req)
+
+			// assertions
+			gomega.Expect(rec.Code).To(gomega.Equal(http.StatusNotFound))
+
+			// the deleted row keeps its old values
+			var deletedUser models.User
+			db.First(&deletedUser, userID)
+			gomega.Expect(deletedUser.Username).To(gomega.Equal("testuser"))
+		})
 	})
 
 	ginkgo.Context("DeleteUser", func() {
@@ -328,10 +386,23 @@ ginkgo.Describe("User Handler", func() {
 			// assertions
 			gomega.Expect(rec.Code).To(gomega.Equal(http.StatusNoContent))
 
-			// Verify that the user is deleted from the database
+			// Verify that the user is soft deleted: the row is kept with deleted_at set
 			var deletedUser models.User
 			db.First(&deletedUser, userID)
-			gomega.Expect(deletedUser.ID).To(gomega.BeZero())
+			gomega.Expect(deletedUser.ID).To(gomega.BeEquivalentTo(userID))
+			gomega.Expect(deletedUser.DeletedAt).To(gomega.Not(gomega.BeNil()))
+
+			// and is no longer returned by the API
+			req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/users/%d", userID), nil)
+			rec = httptest.NewRecorder()
+			e.GET("/users/:id", userHandler.GetUserByID).ServeHTTP(rec, req)
+			gomega.Expect(rec.Code).To(gomega.Equal(http.StatusNotFound))
+
+			// and deleting it again reports it as not found
+			req = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/users/%d", userID), nil)
+			rec = httptest.NewRecorder()
+			e.DELETE("/users/:id", userHandler.DeleteUser).ServeHTTP(rec, req)
+			gomega.Expect(rec.Code).To(gomega.Equal(http.StatusNotFound))
 		})
 
 		ginkgo.It("Should return an error for an invalid user ID", func() {
@@ -387,8 +458,89 @@ ginkgo.Describe("User Handler", func() {
 			gomega.Expect(len(usersResponse)).To(gomega.Equal(2))
 		})
 	})
+
+	ginkgo.Context("with a fake repository", func() {
+		ginkgo.It("Should serve users without a database", func() {
+			repo := &fakeUserRepository{users: map[int]handlers.User{1: {ID: 1, Username: "fakeuser", Email: "fakeuser@example.com"}}}
+			fakeHandler := handlers.NewUserHandlerWithRepository(repo)
+
+			req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+			rec := httptest.NewRecorder()
+			e.GET("/users/:id", fakeHandler.GetUserByID).ServeHTTP(rec, req)
+
+			gomega.Expect(rec.Code).To(gomega.Equal(http.StatusOK))
+			var userResponse handlers.User
+			json.Unmarshal(rec.Body.Bytes(), &userResponse)
+			gomega.Expect(userResponse.Username).To(gomega.Equal("fakeuser"))
+		})
+
+		ginkgo.It("Should return 404 when the repository deletes nothing", func() {
+			fakeHandler := handlers.NewUserHandlerWithRepository(&fakeUserRepository{users: map[int]handlers.User{}})
+
+			req := httptest.NewRequest(http.MethodDelete, "/users/999", nil)
+			rec := httptest.NewRecorder()
+			e.DELETE("/users/:id", fakeHandler.DeleteUser).ServeHTTP(rec, req)
+
+			gomega.Expect(rec.Code).To(gomega.Equal(http.StatusNotFound))
+		})
+	})
 })
 
+// fakeUserRepository keeps users in memory for handler tests that don't need
+// a database.
+type fakeUserRepository struct {
+	users map[int]handlers.User
+}
+
+func (r *fakeUserRepository) GetByID(id int) (handlers.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return user, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (r *fakeUserRepository) List() ([]handlers.User, error) {
+	var users []handlers.User
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (r *fakeUserRepository) Create(user *handlers.User) error {
+	user.ID = len(r.users) + 1
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *fakeUserRepository) Update(id int, user *handlers.User) error {
+	if _, ok := r.users[id]; !ok {
+		return handlers.ErrNoRowsAffected
+	}
+	user.ID = id
+	r.users[id] = *user
+	return nil
+}
+
+func (r *fakeUserRepository) Delete(id int) error {
+	if _, ok := r.users[id]; !ok {
+		return handlers.ErrNoRowsAffected
+	}
+	delete(r.users, id)
+	return nil
+}
+
+func (r *fakeUserRepository) CountByFilter(filter handlers.UserFilter) (int, error) {
+	count := 0
+	for _, user := range r.users {
+		if (filter.Username == "" || user.Username == filter.Username) && (filter.Email == "" || user.Email == filter.Email) {
+			count++
+		}
+	}
+	return count, nil
+}
+
 func TestUserHandler(t *testing.T) {
 	ginkgo.RunSpecs(t, "User Handler Suite")
 }
\ No newline at end of file
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// UserRepository is the storage UserHandler works against. The SQL
+// implementation is SQLUserRepository; tests can substitute a fake.
+type UserRepository interface {
+	GetByID(id int) (User, error)
+	List() ([]User, error)
+	Create(user *User) error
+	Update(id int, user *User) error
+	Delete(id int) error
+	CountByFilter(filter UserFilter) (int, error)
+}
+
+// UserFilter narrows CountByFilter to users whose username or email match
+// exactly. Empty fields are ignored.
+type UserFilter struct {
+	Username string
+	Email    string
+}
+
+// userColumns are the users columns a User is read from, in the order
+// scanUser expects them.
+var userColumns = []string{"id", "tenant_id", "username", "email", "role", "created_at", "updated_at", "deleted_at"}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner, user *User) error {
+	return row.Scan(&user.ID, &user.TenantID, &user.Username, &user.Email, &user.Role, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt)
+}
+
+type SQLUserRepository struct {
+	DB *sql.DB
+}
+
+func NewSQLUserRepository(db *sql.DB) *SQLUserRepository {
+	return &SQLUserRepository{DB: db}
+}
+
+func (r *SQLUserRepository) List() ([]User, error) {
+	queryBuilder := squirrel.Select(userColumns...).From("users").Where(squirrel.Eq{"deleted_at": nil})
+	sql, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.DB.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := scanUser(rows, &u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func (r *SQLUserRepository) GetByID(id int) (User, error) {
+	var user User
+	queryBuilder := squirrel.Select(userColumns...).From("users").Where(squirrel.Eq{"id": id, "deleted_at": nil})
+	sql, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return user, err
+	}
+
+	err = scanUser(r.DB.QueryRow(sql, args...), &user)
+	if err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+func (r *SQLUserRepository) Create(user *User) error {
+	var existingUser User
+	err := r.DB.QueryRow("SELECT id FROM users WHERE username = $1 OR email = $2", user.Username, user.Email).Scan(&existingUser.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if existingUser.ID != 0 {
+		return errors.New("username_or_email_exists")
+	}
+
+	// Everything the database fills in, such as role and the timestamps,
+	// is read back so the response matches the stored row.
+	queryBuilder := squirrel.Insert("users").Columns("username", "email").Values(user.Username, user.Email).Suffix("RETURNING " + strings.Join(userColumns, ", "))
+	sql, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return err
+	}
+
+	err = scanUser(r.DB.QueryRow(sql, args...), user)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *SQLUserRepository) Update(id int, user *User) error {
+	var existingUser User
+	err := r.DB.QueryRow("SELECT id FROM users WHERE (username = $1 OR email = $2) AND id != $3 AND deleted_at IS NULL", user.Username, user.Email, id).Scan(&existingUser.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if existingUser.ID != 0 {
+		return errors.New("username_or_email_exists")
+	}
+
+	queryBuilder := squirrel.Update("users").Set("username", user.Username).Set("email", user.Email).Set("updated_at", squirrel.Expr("NOW()")).Where(squirrel.Eq{"id": id, "deleted_at": nil}).Suffix("RETURNING updated_at")
+	query, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return err
+	}
+
+	err = r.DB.QueryRow(query, args...).Scan(&user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return ErrNoRowsAffected
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *SQLUserRepository) Delete(id int) error {
+	queryBuilder := squirrel.Update("users").Set("deleted_at", squirrel.Expr("NOW()")).Where(squirrel.Eq{"id": id, "deleted_at": nil})
+	sql, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return err
+	}
+
+	result, err := r.DB.Exec(sql, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRowsAffected
+	}
+	return nil
+}
+
+func (r *SQLUserRepository) CountByFilter(filter UserFilter) (int, error) {
+	where := squirrel.Eq{"deleted_at": nil}
+	if filter.Username != "" {
+		where["username"] = filter.Username
+	}
+	if filter.Email != "" {
+		where["email"] = filter.Email
+	}
+	query, args, err := squirrel.Select("COUNT(*)").From("users").Where(where).ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = r.DB.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+var (
+	// errAccountLocked is returned by authenticateUser while the user is
+	// locked out after too many failed logins.
+	errAccountLocked      = errors.New("account_locked")
+	errInvalidUnlockToken = errors.New("invalid_unlock_token")
+)
+
+type UnlockRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// recordFailedLogin counts a wrong password for userID. The
+// Config.App.MaxFailedLogins-th consecutive failure locks the account for
+// Config.App.LockDuration and resets the count, and recordFailedLogin
+// reports that it did.
+func recordFailedLogin(db *sql.DB, cfg *Config, userID int) (bool, error) {
+	lockedUntil := time.Now().Add(cfg.App.LockDuration.Duration)
+	var locked bool
+	err := db.QueryRow(`UPDATE users SET
+			failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+			locked_until = CASE WHEN failed_logins + 1 >= $2 THEN $3 ELSE locked_until END
+		WHERE id = $1
+		RETURNING locked_until = $3`, userID, cfg.App.MaxFailedLogins, lockedUntil).Scan(&locked)
+	if err != nil {
+		return false, err
+	}
+	return locked, nil
+}
+
+// recordLogin notes a successful login for userID, forgetting earlier
+// failures.
+func recordLogin(db *sql.DB, userID int) error {
+	_, err := db.Exec("UPDATE users SET failed_logins = 0, last_login_at = NOW() WHERE id = $1", userID)
+	return err
+}
+
+// createUnlockToken stores a token that lifts the current lock on userID.
+// It expires with the lock, after which it would be of no use.
+func createUnlockToken(db *sql.DB, cfg *Config, userID int) (string, error) {
+	token, tokenHash, err := newResetToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(cfg.App.LockDuration.Duration)
+	_, err = db.Exec("INSERT INTO unlock_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)", userID, tokenHash, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// pruneUnlockTokens deletes unlock tokens whose lock has run out.
+func pruneUnlockTokens(db *sql.DB) (int64, error) {
+	result, err := db.Exec("DELETE FROM unlock_tokens WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// sendLockNotification emails the user that their account was locked, with
+// a link to Config.App.UnlockURL carrying an unlock token. The frontend asks
+// for confirmation there before calling POST /unlock.
+func sendLockNotification(db *sql.DB, cfg *Config, sender EmailSender, userID int, email string) error {
+	token, err := createUnlockToken(db, cfg, userID)
+	if err != nil {
+		return err
+	}
+	link := cfg.App.UnlockURL + "?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Your account was locked for %s after %d failed login attempts.\n\nIf that was you, you can unlock it now: %s\n\nIf it wasn't, consider resetting your password.",
+		cfg.App.LockDuration.Duration, cfg.App.MaxFailedLogins, link)
+	return sender.Send(email, "Your account was locked", body)
+}
+
+// unlockAccount consumes an unlock token and lifts the lock on the user it
+// was issued to.
+func unlockAccount(db *sql.DB, token string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow("UPDATE unlock_tokens SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() RETURNING user_id", hashResetToken(token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return errInvalidUnlockToken
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE users SET locked_until = NULL, failed_logins = 0 WHERE id = $1", userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// unlockHandler serves POST /unlock.
+func unlockHandler(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req UnlockRequest
+		if err := c.Bind(&req); err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+		}
+		if err := c.Validate(req); err != nil {
+			return validationError(req, err)
+		}
+
+		err := unlockAccount(db, req.Token)
+		if err == errInvalidUnlockToken {
+			return newAPIError(http.StatusBadRequest, "invalid_unlock_token", "Invalid or expired unlock token")
+		}
+		if err != nil {
+			log.Errorf("request %s: unlocking account: %v", requestID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_unlock_account", "Failed to unlock account")
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var unlockLinkPattern = regexp.MustCompile(`\?token=(\S+)`)
+
+var _ = ginkgo.Describe("Account Lockout", func() {
+	var (
+		testUser User
+		lockCfg  Config
+		sender   *fakeEmailSender
+	)
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "lockuser", Email: "lockuser@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+
+		lockCfg = *cfg
+		lockCfg.App.MaxFailedLogins = 3
+		lockCfg.App.LockDuration = Duration{15 * time.Minute}
+		lockCfg.App.UnlockURL = "http://localhost:4200/unlock"
+		sender = &fakeEmailSender{}
+	})
+
+	failLogins := func(n int) {
+		for i := 0; i < n; i++ {
+			_, err := authenticateUser(db, &lockCfg, sender, 0, "lockuser", "wrong-password")
+			gomega.Expect(err).Should(gomega.Equal(errInvalidCredentials))
+		}
+	}
+
+	unlock := func(token string) int {
+		server := echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.Validator = e.Validator
+		server.POST("/unlock", unlockHandler(db))
+
+		req := httptest.NewRequest(http.MethodPost, "/unlock", strings.NewReader(`{"token":"`+token+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	ginkgo.It("Should lock the account after too many failed logins", func() {
+		failLogins(2)
+		_, err := authenticateUser(db, &lockCfg, sender, 0, "lockuser", "password123")
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		// The successful login reset the count.
+		failLogins(2)
+		_, err = authenticateUser(db, &lockCfg, sender, 0, "lockuser", "password123")
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		failLogins(3)
+		_, err = authenticateUser(db, &lockCfg, sender, 0, "lockuser", "password123")
+		gomega.Expect(err).Should(gomega.Equal(errAccountLocked))
+		gomega.Expect(sender.Sent()).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("Should email an unlock link on lock when NotifyOnLock is set", func() {
+		lockCfg.App.NotifyOnLock = true
+
+		failLogins(3)
+
+		sent := sender.Sent()
+		gomega.Expect(sent).Should(gomega.HaveLen(1))
+		gomega.Expect(sent[0].To).Should(gomega.Equal("lockuser@example.com"))
+		gomega.Expect(sent[0].Body).Should(gomega.ContainSubstring("http://localhost:4200/unlock?token="))
+	})
+
+	ginkgo.It("Should unlock the account once with the emailed token", func() {
+		lockCfg.App.NotifyOnLock = true
+		failLogins(3)
+		match := unlockLinkPattern.FindStringSubmatch(sender.Sent()[0].Body)
+		gomega.Expect(match).Should(gomega.HaveLen(2))
+		token, err := url.QueryUnescape(match[1])
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		gomega.Expect(unlock(token)).Should(gomega.Equal(http.StatusNoContent))
+
+		userID, err := authenticateUser(db, &lockCfg, sender, 0, "lockuser", "password123")
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(userID).Should(gomega.Equal(testUser.ID))
+
+		gomega.Expect(unlock(token)).Should(gomega.Equal(http.StatusBadRequest))
+	})
+})
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Masterminds/squirrel"
@@ -17,15 +24,14 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
-	"github.com/patrickmn/go-cache"
+	_ "github.com/lzake/gowebsite-backend/docs"
+	"github.com/prometheus/client_golang/prometheus"
 	echoSwagger "github.com/swaggo/echo-swagger"
-	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/time/rate"
 )
 
 var (
 	statementBuilder = squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
-	userCache        = cache.New(5*time.Minute, 10*time.Minute) // Initializing cache
+	userCache        = newUserCacheStore(5*time.Minute, 10*time.Minute) // Initializing cache
 )
 
 type Config struct {
@@ -36,24 +42,259 @@ type Config struct {
 		DBName   string `json:"dbname"`
 		Port     int    `json:"port"`
 		SSLMode  string `json:"sslmode"`
+		// QueryTimeout is the most seconds a single user query may run
+		// before the request fails with 503 database_timeout.
+		QueryTimeout int `json:"query_timeout"`
+		// TimeZone is set as the session time zone on every connection.
+		// Timestamps are stored as timestamptz, so this only affects how
+		// they are read back; it defaults to UTC.
+		TimeZone string `json:"timezone"`
+		// MaxOpenConns caps the connections the pool opens. The default of
+		// 25 leaves most of Postgres' default max_connections (100) for
+		// other clients and a second instance during deploys.
+		// MaxIdleConns is how many of those are kept open between requests
+		// (default 10) and must not exceed MaxOpenConns. ConnMaxLifetime
+		// recycles connections after 30 minutes by default so failovers and
+		// server-side settings changes are picked up.
+		MaxOpenConns    int      `json:"max_open_conns"`
+		MaxIdleConns    int      `json:"max_idle_conns"`
+		ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+		// AutoMigrate applies pending migrations every time the server
+		// starts. Without it they are only applied by the -migrate flag.
+		AutoMigrate bool `json:"auto_migrate"`
 	} `json:"database"`
+	Server struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	} `json:"server"`
+	SMTP struct {
+		Host     string `json:"host"`
+		Port     int    `json:"port"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		From     string `json:"from"`
+		// Workers is how many emails are sent at once and QueueSize how
+		// many more may wait. Messages beyond that are dropped and logged.
+		Workers   int `json:"workers"`
+		QueueSize int `json:"queue_size"`
+	} `json:"smtp"`
+	// EmailVerification checks at signup that mail to the new address can
+	// be delivered. Provider "mx" looks up the domain's mail servers and
+	// "http" asks the service at URL, authenticating with APIKey. Empty
+	// turns the check off.
+	EmailVerification struct {
+		Provider string   `json:"provider"`
+		URL      string   `json:"url"`
+		APIKey   string   `json:"api_key"`
+		Timeout  Duration `json:"timeout"`
+	} `json:"email_verification"`
+	// Storage is where uploaded files such as avatars are kept. Driver
+	// "local" writes under LocalDir and serves it at /uploads; BaseURL is
+	// the public URL of /uploads. Driver "s3" uses the S3 settings; see
+	// newS3BlobStore.
+	Storage struct {
+		Driver            string `json:"driver"`
+		LocalDir          string `json:"local_dir"`
+		BaseURL           string `json:"base_url"`
+		MaxAvatarBytes    int    `json:"max_avatar_bytes"`
+		S3Bucket          string `json:"s3_bucket"`
+		S3Region          string `json:"s3_region"`
+		S3Endpoint        string `json:"s3_endpoint"`
+		S3BaseURL         string `json:"s3_base_url"`
+		S3AccessKeyID     string `json:"s3_access_key_id"`
+		S3SecretAccessKey string `json:"s3_secret_access_key"`
+		S3SessionToken    string `json:"s3_session_token"`
+	} `json:"storage"`
 	App struct {
 		TimeZone  string `json:"timezone"`
 		LogLevel  string `json:"log_level"`
 		RateLimit int    `json:"rate_limit"`
+		// AuditRetention is how long audit log rows are kept before the
+		// pruner removes them. AuditPruneInterval controls how often it runs.
+		AuditRetention      Duration `json:"audit_retention"`
+		AuditPruneInterval  Duration `json:"audit_prune_interval"`
+		AuditPruneBatchSize int      `json:"audit_prune_batch_size"`
+		// BioMaxLength is the maximum number of characters allowed in a bio.
+		BioMaxLength int `json:"bio_max_length"`
+		// ProfilePictureURLMaxLength is the longest profile picture URL
+		// accepted. The initial migration caps the column at 2048 characters.
+		ProfilePictureURLMaxLength int `json:"profile_picture_url_max_length"`
+		// DefaultProfilePictureURL is shown for users without a profile
+		// picture. Users can't set it as their own, so an empty stored URL
+		// always means "use the default". Empty disables both.
+		DefaultProfilePictureURL string `json:"default_profile_picture_url"`
+		// RateLimitExemptIPs lists IPs or CIDR ranges that bypass the rate
+		// limiter, e.g. hosts running bulk admin jobs. Admins bypass it from
+		// anywhere.
+		RateLimitExemptIPs []string `json:"rate_limit_exempt_ips"`
+		// RateLimitFailOpen starts the server without rate limiting, with a
+		// warning, when the limiter's store can't be initialized. By default
+		// the server refuses to start instead.
+		RateLimitFailOpen bool `json:"rate_limit_fail_open"`
+		// TrustedProxies lists the IPs or CIDR ranges of reverse proxies
+		// whose X-Forwarded-For and X-Real-IP headers are believed. Leave it
+		// empty when clients connect directly.
+		TrustedProxies []string `json:"trusted_proxies"`
+		// FrontendBaseURL is the externally visible scheme and host of the
+		// API, e.g. https://example.com, used for the Link header of paged
+		// lists. Empty derives it from the request, believing the proxy's
+		// X-Forwarded-Host and X-Forwarded-Proto only from TrustedProxies.
+		FrontendBaseURL string `json:"frontend_base_url"`
+		// PublicCacheMaxAge is how long browsers and CDNs may cache the
+		// anonymous GET routes in publicCacheRoutes. Every other response
+		// is sent with Cache-Control: no-store.
+		PublicCacheMaxAge Duration `json:"public_cache_max_age"`
+		// GzipLevel is the compression level of gzipped responses, 1
+		// (fastest) to 9 (smallest); 0 uses gzip's default. Responses
+		// shorter than GzipMinLength bytes, 1024 by default, are sent
+		// uncompressed since gzip would barely shrink them.
+		GzipLevel     int `json:"gzip_level"`
+		GzipMinLength int `json:"gzip_min_length"`
+		// NullOptionalFields sends unset optional fields (bio,
+		// profile_picture_url, pending_email) as null instead of "".
+		NullOptionalFields bool `json:"null_optional_fields"`
+		// MaxBulkSize caps the number of items accepted by batch endpoints.
+		MaxBulkSize int `json:"max_bulk_size"`
+		// MaxRecentUsers caps the limit accepted by GET /users/recent.
+		MaxRecentUsers int `json:"max_recent_users"`
+		// JwtSecret signs access tokens and TokenTTL is how long they stay
+		// valid. Keep TokenTTL short; clients renew with a refresh token.
+		JwtSecret string   `json:"jwt_secret"`
+		TokenTTL  Duration `json:"token_ttl"`
+		// RefreshTokenTTL is how long a refresh token can be exchanged at
+		// POST /token/refresh. Expired refresh, password reset, unlock and
+		// verification tokens and logged out access token IDs are deleted
+		// every RefreshTokenPruneInterval.
+		RefreshTokenTTL           Duration `json:"refresh_token_ttl"`
+		RefreshTokenPruneInterval Duration `json:"refresh_token_prune_interval"`
+		// VerificationTokenTTL is how long after signup an email
+		// verification token is kept.
+		VerificationTokenTTL Duration `json:"verification_token_ttl"`
+		// MaxResetTokens caps how many password reset tokens a user can have
+		// active at once; ResetTokenTTL is how long each one is valid.
+		MaxResetTokens int      `json:"max_reset_tokens"`
+		ResetTokenTTL  Duration `json:"reset_token_ttl"`
+		// Charset is declared on JSON responses that don't set one.
+		Charset string `json:"charset"`
+		// CORSOrigins lists the origins allowed to call the API from a
+		// browser.
+		CORSOrigins []string `json:"cors_origins"`
+		// Features toggles optional behaviour by name.
+		Features map[string]bool `json:"features"`
+		// MetricsEnabled exposes per-route request counts and latency
+		// histograms, plus user cache hit/miss counters, at /metrics.
+		// MetricsMaxRoutes caps how many distinct routes get their own
+		// series; the rest are reported as "other".
+		MetricsEnabled   bool `json:"metrics_enabled"`
+		MetricsMaxRoutes int  `json:"metrics_max_routes"`
+		// MetricsToken, if set, must be sent as a bearer token to read
+		// /metrics and other internal endpoints.
+		MetricsToken string `json:"metrics_token"`
+		// UsernameReleaseAfter is how long a deleted user's username stays
+		// reserved before it can be taken by a new signup.
+		UsernameReleaseAfter Duration `json:"username_release_after"`
+		// StrictQueryParams rejects requests with query parameters an
+		// endpoint doesn't know about.
+		StrictQueryParams bool `json:"strict_query_params"`
+		// CaseInsensitiveSearch makes ?q= match with ILIKE instead of LIKE.
+		// config.json and APP_CASE_INSENSITIVE_SEARCH both default it to
+		// true.
+		CaseInsensitiveSearch bool `json:"case_insensitive_search"`
+		// UnprocessableValidationErrors answers payloads that parse but fail
+		// validation with 422 instead of 400. Malformed JSON stays 400.
+		UnprocessableValidationErrors bool `json:"unprocessable_validation_errors"`
+		// ShutdownTimeout is how long in-flight requests get to finish after
+		// SIGINT or SIGTERM.
+		ShutdownTimeout Duration `json:"shutdown_timeout"`
+		// DefaultRole is the role given to users created through the API.
+		// It must be one of knownRoles.
+		DefaultRole string `json:"default_role"`
+		// UserCacheTTL is how long a user stays cached. Users read at least
+		// UserCacheHotReads times within one UserCacheTTL are cached for
+		// UserCacheHotTTL instead.
+		UserCacheTTL      Duration `json:"user_cache_ttl"`
+		UserCacheHotTTL   Duration `json:"user_cache_hot_ttl"`
+		UserCacheHotReads int      `json:"user_cache_hot_reads"`
+		// RestoreWindow is how long after a soft delete the user can still
+		// be restored. Keep it shorter than UsernameReleaseAfter.
+		RestoreWindow Duration `json:"restore_window"`
+		// MaxFailedLogins consecutive wrong passwords lock an account for
+		// LockDuration.
+		MaxFailedLogins int      `json:"max_failed_logins"`
+		LockDuration    Duration `json:"lock_duration"`
+		// NotifyOnLock emails a locked out user a link to UnlockURL with a
+		// token that lifts the lock early through POST /unlock.
+		NotifyOnLock bool   `json:"notify_on_lock"`
+		UnlockURL    string `json:"unlock_url"`
+		// ProfileCompletenessWeights weighs the optional profile parts
+		// counted by profile_completeness: "bio", "avatar" and
+		// "verified_email". A user with all of them scores 100.
+		ProfileCompletenessWeights map[string]int `json:"profile_completeness_weights"`
+		// PasswordHasher is the scheme new passwords are hashed with,
+		// "bcrypt" (the default) or "argon2id". Stored hashes of either
+		// scheme keep working. RehashPasswords re-hashes a user's password
+		// with the configured scheme when they log in with an older one.
+		PasswordHasher  string `json:"password_hasher"`
+		RehashPasswords bool   `json:"rehash_passwords"`
+		// IDType is the kind of user ID clients see: "int" (the default)
+		// for the integer primary key or "uuid" for a random UUID that
+		// doesn't reveal how many users signed up before.
+		IDType string `json:"id_type"`
+		// PasswordPolicy is checked for every new password. MinLength
+		// defaults to 8 and can only raise the payloads' own minimum.
+		PasswordPolicy PasswordPolicy `json:"password_policy"`
+		// RolePermissions maps each role to the permissions reported by
+		// GET /users/me/permissions. Roles left out get none.
+		RolePermissions map[string][]string `json:"role_permissions"`
 	} `json:"app"`
 }
 
+// Duration is a time.Duration that is written in config files as a string
+// such as "30m" or "720h".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
 type User struct {
-	ID                int        `json:"id"`
-	Username          string     `json:"username"`
-	Email             string     `json:"email"`
-	Password          string     `json:"password,omitempty"`
-	ProfilePictureURL string     `json:"profile_picture_url"`
-	Bio               string     `json:"bio"`
-	CreatedAt         time.Time  `json:"created_at"`
-	UpdatedAt         time.Time  `json:"updated_at"`
-	DeletedAt         *time.Time `json:"deleted_at,omitempty"`
+	ID int `json:"id" xml:"id"`
+	// UUID is sent as "id" instead of ID when uuidIDs is on; see
+	// User.MarshalJSON.
+	UUID              string `json:"-" xml:"-"`
+	TenantID          int    `json:"tenant_id" xml:"tenant_id"`
+	Username          string `json:"username" xml:"username" validate:"required,min=3,max=30"`
+	Email             string `json:"email" xml:"email" validate:"required,email"`
+	Password          string `json:"password,omitempty" xml:"-"`
+	ProfilePictureURL string `json:"profile_picture_url" xml:"profile_picture_url" validate:"omitempty,profile_picture_url,custom_profile_picture"`
+	Bio               string `json:"bio" xml:"bio" validate:"bio"`
+	Timezone          string `json:"timezone" xml:"timezone" validate:"omitempty,timezone"`
+	SignupSource      string `json:"-" xml:"-"`
+	Role              string `json:"-" xml:"-"`
+	EmailVerified     bool   `json:"-" xml:"-"`
+	// LastLoginAt is only read by GET /users/recent.
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" xml:"last_login_at,omitempty"`
+	// ProfileCompleteness is filled in by presentUser; see
+	// profileCompleteness.
+	ProfileCompleteness int        `json:"profile_completeness" xml:"profile_completeness"`
+	CreatedAt           time.Time  `json:"created_at" xml:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at" xml:"updated_at"`
+	DeletedAt           *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
 }
 
 func readConfig(filename string) (*Config, error) {
@@ -70,39 +311,282 @@ func readConfig(filename string) (*Config, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse configuration file: %w", err)
 		}
+		applyConfigDefaults(&config)
 
 		return &config, nil
 	}
 
-	config := &Config{
-		Database: struct {
-			Host     string `json:"host"`
-			User     string `json:"user"`
-			Password string `json:"password"`
-			DBName   string `json:"dbname"`
-			Port     int    `json:"port"`
-			SSLMode  string `json:"sslmode"`
-		}{
-			Host:     os.Getenv("DB_HOST"),
-			User:     os.Getenv("DB_USER"),
-			Password: os.Getenv("DB_PASSWORD"),
-			DBName:   os.Getenv("DB_NAME"),
-			Port:     getEnvAsInt("DB_PORT", 5432),
-			SSLMode:  os.Getenv("DB_SSLMODE"),
-		},
-		App: struct {
-			TimeZone  string `json:"timezone"`
-			LogLevel  string `json:"log_level"`
-			RateLimit int    `json:"rate_limit"`
-		}{
-			TimeZone:  os.Getenv("APP_TIMEZONE"),
-			LogLevel:  os.Getenv("APP_LOG_LEVEL"),
-			RateLimit: getEnvAsInt("APP_RATE_LIMIT", 100),
-		},
+	config := &Config{}
+	config.Database.Host = os.Getenv("DB_HOST")
+	config.Database.User = os.Getenv("DB_USER")
+	config.Database.Password = os.Getenv("DB_PASSWORD")
+	config.Database.DBName = os.Getenv("DB_NAME")
+	config.Database.Port = getEnvAsInt("DB_PORT", 5432)
+	config.Database.SSLMode = os.Getenv("DB_SSLMODE")
+	config.Database.TimeZone = os.Getenv("DB_TIMEZONE")
+	config.Database.QueryTimeout = getEnvAsInt("DB_QUERY_TIMEOUT", 0)
+	config.Database.MaxOpenConns = getEnvAsInt("DB_MAX_OPEN_CONNS", 0)
+	config.Database.MaxIdleConns = getEnvAsInt("DB_MAX_IDLE_CONNS", 0)
+	config.Database.ConnMaxLifetime = getEnvAsDuration("DB_CONN_MAX_LIFETIME", 0)
+	config.Database.AutoMigrate = getEnvAsBool("DB_AUTO_MIGRATE", false)
+	config.Server.Host = os.Getenv("APP_HOST")
+	config.Server.Port = getEnvAsInt("APP_PORT", 0)
+	config.SMTP.Host = os.Getenv("SMTP_HOST")
+	config.SMTP.Port = getEnvAsInt("SMTP_PORT", 587)
+	config.SMTP.Username = os.Getenv("SMTP_USERNAME")
+	config.SMTP.Password = os.Getenv("SMTP_PASSWORD")
+	config.SMTP.From = os.Getenv("SMTP_FROM")
+	config.SMTP.Workers = getEnvAsInt("SMTP_WORKERS", 0)
+	config.SMTP.QueueSize = getEnvAsInt("SMTP_QUEUE_SIZE", 0)
+	config.EmailVerification.Provider = os.Getenv("EMAIL_VERIFICATION_PROVIDER")
+	config.EmailVerification.URL = os.Getenv("EMAIL_VERIFICATION_URL")
+	config.EmailVerification.APIKey = os.Getenv("EMAIL_VERIFICATION_API_KEY")
+	config.EmailVerification.Timeout = getEnvAsDuration("EMAIL_VERIFICATION_TIMEOUT", 0)
+	config.Storage.Driver = os.Getenv("STORAGE_DRIVER")
+	config.Storage.LocalDir = os.Getenv("STORAGE_LOCAL_DIR")
+	config.Storage.BaseURL = os.Getenv("STORAGE_BASE_URL")
+	config.Storage.MaxAvatarBytes = getEnvAsInt("STORAGE_MAX_AVATAR_BYTES", 0)
+	config.Storage.S3Bucket = os.Getenv("STORAGE_S3_BUCKET")
+	config.Storage.S3Region = os.Getenv("STORAGE_S3_REGION")
+	config.Storage.S3Endpoint = os.Getenv("STORAGE_S3_ENDPOINT")
+	config.Storage.S3BaseURL = os.Getenv("STORAGE_S3_BASE_URL")
+	config.Storage.S3AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	config.Storage.S3SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	config.Storage.S3SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	config.App.TimeZone = os.Getenv("APP_TIMEZONE")
+	config.App.LogLevel = os.Getenv("APP_LOG_LEVEL")
+	config.App.RateLimit = getEnvAsInt("APP_RATE_LIMIT", 100)
+	config.App.AuditRetention = getEnvAsDuration("APP_AUDIT_RETENTION", 0)
+	config.App.AuditPruneInterval = getEnvAsDuration("APP_AUDIT_PRUNE_INTERVAL", 0)
+	config.App.AuditPruneBatchSize = getEnvAsInt("APP_AUDIT_PRUNE_BATCH_SIZE", 0)
+	config.App.BioMaxLength = getEnvAsInt("APP_BIO_MAX_LENGTH", 0)
+	config.App.ProfilePictureURLMaxLength = getEnvAsInt("APP_PROFILE_PICTURE_URL_MAX_LENGTH", 0)
+	config.App.DefaultProfilePictureURL = os.Getenv("APP_DEFAULT_PROFILE_PICTURE_URL")
+	config.App.RateLimitExemptIPs = getEnvAsList("APP_RATE_LIMIT_EXEMPT_IPS")
+	config.App.RateLimitFailOpen = getEnvAsBool("APP_RATE_LIMIT_FAIL_OPEN", false)
+	config.App.TrustedProxies = getEnvAsList("APP_TRUSTED_PROXIES")
+	config.App.FrontendBaseURL = os.Getenv("APP_FRONTEND_BASE_URL")
+	config.App.PublicCacheMaxAge = getEnvAsDuration("APP_PUBLIC_CACHE_MAX_AGE", 0)
+	config.App.GzipLevel = getEnvAsInt("APP_GZIP_LEVEL", 0)
+	config.App.GzipMinLength = getEnvAsInt("APP_GZIP_MIN_LENGTH", 0)
+	config.App.NullOptionalFields = getEnvAsBool("APP_NULL_OPTIONAL_FIELDS", false)
+	config.App.MaxBulkSize = getEnvAsInt("APP_MAX_BULK_SIZE", 0)
+	config.App.MaxRecentUsers = getEnvAsInt("APP_MAX_RECENT_USERS", 0)
+	config.App.JwtSecret = os.Getenv("APP_JWT_SECRET")
+	config.App.TokenTTL = getEnvAsDuration("APP_TOKEN_TTL", 0)
+	config.App.RefreshTokenTTL = getEnvAsDuration("APP_REFRESH_TOKEN_TTL", 0)
+	config.App.RefreshTokenPruneInterval = getEnvAsDuration("APP_REFRESH_TOKEN_PRUNE_INTERVAL", 0)
+	config.App.VerificationTokenTTL = getEnvAsDuration("APP_VERIFICATION_TOKEN_TTL", 0)
+	config.App.MaxResetTokens = getEnvAsInt("APP_MAX_RESET_TOKENS", 0)
+	config.App.ResetTokenTTL = getEnvAsDuration("APP_RESET_TOKEN_TTL", 0)
+	config.App.Charset = os.Getenv("APP_CHARSET")
+	config.App.CORSOrigins = getEnvAsList("APP_CORS_ORIGINS")
+	config.App.MetricsEnabled = getEnvAsBool("APP_METRICS_ENABLED", false)
+	config.App.MetricsMaxRoutes = getEnvAsInt("APP_METRICS_MAX_ROUTES", 0)
+	config.App.MetricsToken = os.Getenv("APP_METRICS_TOKEN")
+	config.App.UsernameReleaseAfter = getEnvAsDuration("APP_USERNAME_RELEASE_AFTER", 0)
+	config.App.ShutdownTimeout = getEnvAsDuration("APP_SHUTDOWN_TIMEOUT", 0)
+	config.App.StrictQueryParams = getEnvAsBool("APP_STRICT_QUERY_PARAMS", false)
+	config.App.CaseInsensitiveSearch = getEnvAsBool("APP_CASE_INSENSITIVE_SEARCH", true)
+	config.App.UnprocessableValidationErrors = getEnvAsBool("APP_UNPROCESSABLE_VALIDATION_ERRORS", false)
+	config.App.DefaultRole = os.Getenv("APP_DEFAULT_ROLE")
+	config.App.RestoreWindow = getEnvAsDuration("APP_RESTORE_WINDOW", 0)
+	config.App.MaxFailedLogins = getEnvAsInt("APP_MAX_FAILED_LOGINS", 0)
+	config.App.LockDuration = getEnvAsDuration("APP_LOCK_DURATION", 0)
+	config.App.NotifyOnLock = getEnvAsBool("APP_NOTIFY_ON_LOCK", false)
+	config.App.UnlockURL = os.Getenv("APP_UNLOCK_URL")
+	config.App.UserCacheTTL = getEnvAsDuration("APP_USER_CACHE_TTL", 0)
+	config.App.UserCacheHotTTL = getEnvAsDuration("APP_USER_CACHE_HOT_TTL", 0)
+	config.App.UserCacheHotReads = getEnvAsInt("APP_USER_CACHE_HOT_READS", 0)
+	config.App.PasswordHasher = os.Getenv("APP_PASSWORD_HASHER")
+	config.App.RehashPasswords = getEnvAsBool("APP_REHASH_PASSWORDS", false)
+	config.App.IDType = os.Getenv("APP_ID_TYPE")
+	config.App.PasswordPolicy.MinLength = getEnvAsInt("APP_PASSWORD_MIN_LENGTH", 0)
+	config.App.PasswordPolicy.RequireMixedCase = getEnvAsBool("APP_PASSWORD_REQUIRE_MIXED_CASE", false)
+	config.App.PasswordPolicy.RequireDigit = getEnvAsBool("APP_PASSWORD_REQUIRE_DIGIT", false)
+	config.App.PasswordPolicy.RequireSymbol = getEnvAsBool("APP_PASSWORD_REQUIRE_SYMBOL", false)
+	config.App.PasswordPolicy.Denylist = getEnvAsList("APP_PASSWORD_DENYLIST")
+	config.App.Features = map[string]bool{}
+	for _, feature := range getEnvAsList("APP_FEATURES") {
+		config.App.Features[feature] = true
 	}
+	applyConfigDefaults(config)
 	return config, nil
 }
 
+// applyConfigDefaults fills in settings that were left unset.
+func applyConfigDefaults(config *Config) {
+	if config.Database.TimeZone == "" {
+		config.Database.TimeZone = "UTC"
+	}
+	if config.Database.QueryTimeout == 0 {
+		config.Database.QueryTimeout = 5
+	}
+	if config.Database.MaxOpenConns == 0 {
+		config.Database.MaxOpenConns = 25
+	}
+	if config.Database.MaxIdleConns == 0 {
+		config.Database.MaxIdleConns = 10
+	}
+	if config.Database.ConnMaxLifetime.Duration == 0 {
+		config.Database.ConnMaxLifetime.Duration = 30 * time.Minute
+	}
+	if config.Server.Port == 0 {
+		config.Server.Port = 8080
+	}
+	if config.App.AuditRetention.Duration == 0 {
+		config.App.AuditRetention.Duration = 90 * 24 * time.Hour
+	}
+	if config.App.AuditPruneInterval.Duration == 0 {
+		config.App.AuditPruneInterval.Duration = time.Hour
+	}
+	if config.App.AuditPruneBatchSize == 0 {
+		config.App.AuditPruneBatchSize = 1000
+	}
+	if config.App.BioMaxLength == 0 {
+		config.App.BioMaxLength = 500
+	}
+	if config.App.ProfilePictureURLMaxLength == 0 {
+		config.App.ProfilePictureURLMaxLength = 2048
+	}
+	if config.App.MaxBulkSize == 0 {
+		config.App.MaxBulkSize = 100
+	}
+	if config.App.MaxRecentUsers == 0 {
+		config.App.MaxRecentUsers = 100
+	}
+	if config.App.TokenTTL.Duration == 0 {
+		config.App.TokenTTL.Duration = 15 * time.Minute
+	}
+	if config.App.RefreshTokenTTL.Duration == 0 {
+		config.App.RefreshTokenTTL.Duration = 30 * 24 * time.Hour
+	}
+	if config.App.RefreshTokenPruneInterval.Duration == 0 {
+		config.App.RefreshTokenPruneInterval.Duration = time.Hour
+	}
+	if config.App.PublicCacheMaxAge.Duration == 0 {
+		config.App.PublicCacheMaxAge.Duration = 30 * time.Second
+	}
+	if config.App.GzipLevel == 0 {
+		config.App.GzipLevel = gzip.DefaultCompression
+	}
+	if config.App.GzipMinLength == 0 {
+		config.App.GzipMinLength = 1024
+	}
+	if config.App.PasswordHasher == "" {
+		config.App.PasswordHasher = "bcrypt"
+	}
+	if config.App.IDType == "" {
+		config.App.IDType = idTypeInt
+	}
+	if config.App.PasswordPolicy.MinLength == 0 {
+		config.App.PasswordPolicy.MinLength = 8
+	}
+	if config.App.VerificationTokenTTL.Duration == 0 {
+		config.App.VerificationTokenTTL.Duration = 7 * 24 * time.Hour
+	}
+	if config.App.MaxResetTokens == 0 {
+		config.App.MaxResetTokens = 3
+	}
+	if config.App.ResetTokenTTL.Duration == 0 {
+		config.App.ResetTokenTTL.Duration = 30 * time.Minute
+	}
+	if config.App.Charset == "" {
+		config.App.Charset = "utf-8"
+	}
+	if config.App.UsernameReleaseAfter.Duration == 0 {
+		config.App.UsernameReleaseAfter.Duration = 30 * 24 * time.Hour
+	}
+	if config.Storage.Driver == "" {
+		config.Storage.Driver = "local"
+	}
+	if config.Storage.LocalDir == "" {
+		config.Storage.LocalDir = "uploads"
+	}
+	if config.Storage.BaseURL == "" {
+		config.Storage.BaseURL = "http://localhost:8080/uploads"
+	}
+	if config.Storage.MaxAvatarBytes == 0 {
+		config.Storage.MaxAvatarBytes = 2 << 20
+	}
+	if config.SMTP.Workers == 0 {
+		config.SMTP.Workers = 4
+	}
+	if config.SMTP.QueueSize == 0 {
+		config.SMTP.QueueSize = 100
+	}
+	if config.EmailVerification.Timeout.Duration == 0 {
+		config.EmailVerification.Timeout.Duration = 3 * time.Second
+	}
+	if config.App.ShutdownTimeout.Duration == 0 {
+		config.App.ShutdownTimeout.Duration = 10 * time.Second
+	}
+	if config.App.MetricsMaxRoutes == 0 {
+		config.App.MetricsMaxRoutes = 50
+	}
+	if config.App.UserCacheTTL.Duration == 0 {
+		config.App.UserCacheTTL.Duration = 5 * time.Minute
+	}
+	if config.App.UserCacheHotTTL.Duration == 0 {
+		config.App.UserCacheHotTTL.Duration = 30 * time.Minute
+	}
+	if config.App.UserCacheHotReads == 0 {
+		config.App.UserCacheHotReads = 10
+	}
+	if config.App.RestoreWindow.Duration == 0 {
+		config.App.RestoreWindow.Duration = 7 * 24 * time.Hour
+	}
+	if config.App.MaxFailedLogins == 0 {
+		config.App.MaxFailedLogins = 5
+	}
+	if config.App.LockDuration.Duration == 0 {
+		config.App.LockDuration.Duration = 15 * time.Minute
+	}
+	if config.App.UnlockURL == "" {
+		config.App.UnlockURL = "http://localhost:4200/unlock"
+	}
+	if config.App.ProfileCompletenessWeights == nil {
+		config.App.ProfileCompletenessWeights = defaultCompletenessWeights
+	}
+	if config.App.RolePermissions == nil {
+		config.App.RolePermissions = defaultRolePermissions
+	}
+	if config.App.DefaultRole == "" {
+		config.App.DefaultRole = roleUser
+	}
+	if len(config.App.CORSOrigins) == 0 {
+		config.App.CORSOrigins = []string{"http://localhost:4200"}
+	}
+}
+
+func getEnvAsDuration(name string, defaultVal time.Duration) Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return Duration{defaultVal}
+	}
+	return Duration{value}
+}
+
+// getEnvAsList reads a comma-separated environment variable, ignoring empty
+// entries.
+func getEnvAsList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getEnvAsBool(name string, defaultVal bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return defaultVal
+	}
+	return value
+}
+
 func getEnvAsInt(name string, defaultVal int) int {
 	valueStr := os.Getenv(name)
 	if valueStr == "" {
@@ -115,20 +599,51 @@ func getEnvAsInt(name string, defaultVal int) int {
 	return value
 }
 
-func getUsers(db *sql.DB, page int, pageSize int) ([]User, error) {
+// getUsers returns one page of users. When searching, exact matches come
+// first, then prefix matches, then the rest, each group in sort order.
+// Cursor pages (getUsersAfter) keep plain sort order because the cursor only
+// records the sort column.
+func getUsers(ctx context.Context, db *sql.DB, page int, pageSize int, filter UserFilter, sort UserSort) ([]User, error) {
 	offset := (page - 1) * pageSize
+	queryBuilder := selectUsers(filter)
+	if filter.Search != "" {
+		queryBuilder = queryBuilder.OrderByClause(filter.relevance())
+	}
+	queryBuilder = queryBuilder.OrderBy(sort.orderBy()...)
+	return queryUsers(ctx, db, queryBuilder.Limit(uint64(pageSize)).Offset(uint64(offset)))
+}
+
+// getUsersAfter returns the page of users that follows after in sort order.
+func getUsersAfter(ctx context.Context, db *sql.DB, after userCursor, pageSize int, filter UserFilter, sort UserSort) ([]User, error) {
+	return queryUsers(ctx, db, usersQuery(filter, sort).Where(after.predicate(sort)).Limit(uint64(pageSize)))
+}
 
-	queryBuilder := squirrel.Select("id", "username", "email", "profile_picture_url", "bio", "created_at", "updated_at").
+func usersQuery(filter UserFilter, sort UserSort) squirrel.SelectBuilder {
+	return selectUsers(filter).OrderBy(sort.orderBy()...)
+}
+
+// selectUsers selects the users matching filter, unordered.
+func selectUsers(filter UserFilter) squirrel.SelectBuilder {
+	return statementBuilder.Select("id", "uuid", "tenant_id", "username", "email", "profile_picture_url", "bio", "timezone", "email_verified", "created_at", "updated_at").
 		From("users").
-		Where(squirrel.Eq{"deleted_at": nil}).
-		Limit(uint64(pageSize)).
-		Offset(uint64(offset))
+		Where(filter.predicate())
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryUsers(ctx context.Context, db queryer, queryBuilder squirrel.SelectBuilder) ([]User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
 	sql, args, err := queryBuilder.ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := db.Query(sql, args...)
+	rows, err := db.QueryContext(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -137,7 +652,7 @@ func getUsers(db *sql.DB, page int, pageSize int) ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.ProfilePictureURL, &u.Bio, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.UUID, &u.TenantID, &u.Username, &u.Email, &u.ProfilePictureURL, &u.Bio, &u.Timezone, &u.EmailVerified, &u.CreatedAt, &u.UpdatedAt); err != nil {
 			return nil, err
 		}
 		users = append(users, u)
@@ -145,51 +660,110 @@ func getUsers(db *sql.DB, page int, pageSize int) ([]User, error) {
 	return users, nil
 }
 
-func getUserByID(db *sql.DB, id int) (User, error) {
+// countUsers returns the number of users matching filter, using the same
+// conditions as getUsers.
+func countUsers(ctx context.Context, db *sql.DB, filter UserFilter) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	sql, args, err := statementBuilder.Select("COUNT(*)").From("users").Where(filter.predicate()).ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int
+	err = db.QueryRowContext(ctx, sql, args...).Scan(&total)
+	return total, err
+}
+
+func getUserByID(ctx context.Context, db *sql.DB, id int) (User, error) {
+	version := userCacheVersions.current(id)
+	becameHot := userCacheTTL.recordRead(id)
 	if cachedUser, found := userCache.Get(strconv.Itoa(id)); found {
+		userCacheStats.hits.Add(1)
+		if becameHot {
+			// Store the entry again so it picks up the longer TTL.
+			cacheUser(id, version, cachedUser.(User))
+		}
 		return cachedUser.(User), nil
 	}
-
-	var user User
-	queryBuilder := squirrel.Select("id", "username", "email", "profile_picture_url", "bio", "created_at", "updated_at").From("users").Where(squirrel.Eq{"id": id, "deleted_at": nil})
-	sql, args, err := queryBuilder.ToSql()
+	userCacheStats.misses.Add(1)
+	user, err := loadUser(ctx, db, id)
 	if err != nil {
 		return user, err
 	}
 
-	err = db.QueryRow(sql, args...).Scan(&user.ID, &user.Username, &user.Email, &user.ProfilePictureURL, &user.Bio, &user.CreatedAt, &user.UpdatedAt)
+	cacheUser(id, version, user)
+
+	return user, nil
+}
+
+// loadUser reads an active user from the database, bypassing the cache.
+func loadUser(ctx context.Context, db *sql.DB, id int) (User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	var user User
+	queryBuilder := statementBuilder.Select("id", "uuid", "tenant_id", "username", "email", "profile_picture_url", "bio", "timezone", "email_verified", "created_at", "updated_at").From("users").Where(squirrel.Eq{"id": id, "deleted_at": nil})
+	sql, args, err := queryBuilder.ToSql()
 	if err != nil {
 		return user, err
 	}
 
-	userCache.Set(strconv.Itoa(id), user, cache.DefaultExpiration)
-
-	return user, nil
+	err = db.QueryRowContext(ctx, sql, args...).Scan(&user.ID, &user.UUID, &user.TenantID, &user.Username, &user.Email, &user.ProfilePictureURL, &user.Bio, &user.Timezone, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
+	return user, err
 }
 
-func createUser(db *sql.DB, user *User) error {
+// createUser inserts user and sends the verification email through sender.
+// A failed send is logged but doesn't undo the signup.
+func createUser(ctx context.Context, db *sql.DB, sender EmailSender, user *User) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	user.Email = normalizeEmail(user.Email)
+
 	var existingUser User
-	err := db.QueryRow("SELECT id FROM users WHERE username = $1 OR email = $2", user.Username, user.Email).Scan(&existingUser.ID)
+	var deletedEmail bool
+	// Usernames and emails are only unique within a tenant, so the same
+	// address may be registered once per tenant. Both compare ignoring case.
+	// Active users sort first so a deleted-email conflict is only reported
+	// when nothing else collides.
+	err := db.QueryRowContext(ctx, `SELECT id, uuid, username, deleted_at IS NOT NULL AND LOWER(email) = $3 FROM users
+		WHERE tenant_id = $1 AND (LOWER(username) = LOWER($2) OR LOWER(email) = $3)
+		ORDER BY deleted_at IS NOT NULL, LOWER(email) = $3 DESC
+		LIMIT 1`, user.TenantID, user.Username, user.Email).Scan(&existingUser.ID, &existingUser.UUID, &existingUser.Username, &deletedEmail)
 	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
+	if deletedEmail {
+		return &deletedEmailConflictError{UserID: existingUser.ID, UUID: existingUser.UUID}
+	}
 	if existingUser.ID != 0 {
-		return errors.New("username_or_email_exists")
+		return &duplicateUserError{Field: duplicateField(existingUser.Username, user.Username)}
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := passwordHasher.Hash(user.Password)
 	if err != nil {
 		return err
 	}
-	user.Password = string(hashedPassword)
+	user.Password = hashedPassword
 
-	verificationToken := "dummy_verification_token"
+	verificationToken, err := randomToken()
+	if err != nil {
+		return err
+	}
+	if user.SignupSource == "" {
+		user.SignupSource = signupSourceAPI
+	}
+	if user.Role == "" {
+		user.Role = roleUser
+	}
 
 	queryBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar).
 		Insert("users").
-		Columns("username", "email", "password", "profile_picture_url", "bio", "verification_token").
-		Values(user.Username, user.Email, user.Password, user.ProfilePictureURL, user.Bio, verificationToken).
-		Suffix("RETURNING id, created_at, updated_at")
+		Columns("tenant_id", "username", "email", "password", "profile_picture_url", "bio", "timezone", "verification_token", "signup_source", "role").
+		Values(user.TenantID, user.Username, user.Email, user.Password, user.ProfilePictureURL, user.Bio, user.Timezone, verificationToken, user.SignupSource, user.Role).
+		Suffix("RETURNING id, uuid, created_at, updated_at")
 
 	sql, args, err := queryBuilder.ToSql()
 	if err != nil {
@@ -197,26 +771,246 @@ func createUser(db *sql.DB, user *User) error {
 		return err
 	}
 
-	err = db.QueryRow(sql, args...).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	// The check above only gives a friendlier answer in the common case; a
+	// concurrent signup can still pass it, so the unique indexes decide.
+	err = db.QueryRowContext(ctx, sql, args...).Scan(&user.ID, &user.UUID, &user.CreatedAt, &user.UpdatedAt)
+	if dup := duplicateUserFromDB(err); dup != nil {
+		return dup
+	}
 	if err != nil {
 		fmt.Printf("Error executing createUser: %s, args: %v, error: %v", sql, args, err)
 		return err
 	}
 
-	fmt.Printf("Sending verification email to %s with token %s", user.Email, verificationToken)
+	err = sender.Send(user.Email, "Verify your email", fmt.Sprintf("Use this token to verify your email address: %s", verificationToken))
+	if err != nil {
+		log.Warnf("Error sending verification email to %s: %v", user.Email, err)
+	}
 	fmt.Printf("User created: %s", user.Username)
 
 	return nil
 }
 
-func updateUser(db *sql.DB, id int, user *User) error {
+// createUserHandler serves POST /users. A collision with a soft-deleted
+// user's email is reported to admins as 409 email_exists_deleted with the
+// path to restore that user; everyone else gets the usual conflict so the
+// endpoint doesn't reveal deleted accounts.
+func createUserHandler(config *Config, db *sql.DB, sender EmailSender, verifier EmailVerifier) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var user User
+		if err := c.Bind(&user); err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+		}
+		normalizeUser(&user)
+		if err := validateNewUser(c, user); err != nil {
+			return validationError(user, err)
+		}
+		if failed := config.App.PasswordPolicy.Check(user.Password); failed != nil {
+			return weakPasswordError("password", failed)
+		}
+		if apiErr := checkDeliverable(c, verifier, user.Email); apiErr != nil {
+			return apiErr
+		}
+		user.SignupSource = signupSource(c)
+		user.Role = config.App.DefaultRole
+		err := createUser(c.Request().Context(), db, sender, &user)
+		if err != nil {
+			var deleted *deletedEmailConflictError
+			if errors.As(err, &deleted) && isAdminRequest(config, db, c) {
+				return newAPIError(http.StatusConflict, "email_exists_deleted", "A deleted user has this email").
+					With("restore_path", fmt.Sprintf("/users/%v/restore", publicUserID(deleted.UserID, deleted.UUID)))
+			}
+			if err.Error() == "username_or_email_exists" {
+				return usernameOrEmailExistsError(err)
+			}
+			log.Errorf("request %s: creating user: %v", requestID(c), err)
+			return databaseError(err, "failed_to_create_user", "Failed to create user")
+		}
+		return respond(c, http.StatusCreated, presentUser(c, user))
+	}
+}
+
+// createUsersHandler serves POST /users/batch. Every user is checked the
+// way createUserHandler checks one before any of them is created.
+func createUsersHandler(config *Config, db *sql.DB, sender EmailSender, verifier EmailVerifier) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		users, err := decodeUserBatch(c.Request().Body, config.App.MaxBulkSize)
+		if err != nil {
+			if err == errBatchTooLarge {
+				return newAPIError(http.StatusRequestEntityTooLarge, "batch_too_large", "Too many items in batch").With("max_items", config.App.MaxBulkSize)
+			}
+			return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+		}
+		for i := range users {
+			normalizeUser(&users[i])
+		}
+		for i, user := range users {
+			if err := validateNewUser(c, user); err != nil {
+				return validationError(user, err).With("index", i)
+			}
+			if failed := config.App.PasswordPolicy.Check(user.Password); failed != nil {
+				return weakPasswordError("password", failed).With("index", i)
+			}
+			if apiErr := checkDeliverable(c, verifier, user.Email); apiErr != nil {
+				return apiErr.With("index", i)
+			}
+		}
+		// Users are created one at a time; if one fails, the ones before it
+		// have already been created and the index tells the caller where to
+		// resume.
+		for i := range users {
+			users[i].SignupSource = signupSourceImport
+			users[i].Role = config.App.DefaultRole
+			if err := createUser(c.Request().Context(), db, sender, &users[i]); err != nil {
+				if err.Error() == "username_or_email_exists" {
+					return usernameOrEmailExistsError(err).With("index", i)
+				}
+				log.Errorf("request %s: creating user %d of batch: %v", requestID(c), i, err)
+				return databaseError(err, "failed_to_create_user", "Failed to create user").With("index", i)
+			}
+		}
+		return respond(c, http.StatusCreated, presentUsers(c, users))
+	}
+}
+
+// getUserHandler serves GET /users/:id. The response carries the user's
+// ETag, and a request whose If-None-Match still matches gets 304 Not
+// Modified without a body.
+func getUserHandler(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "Invalid user ID", "Invalid user ID")
+		}
+		user, err := getUserByID(c.Request().Context(), db, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return newAPIError(http.StatusNotFound, "User not found", "User not found")
+			}
+			return databaseError(err, "Failed to retrieve user", "Failed to retrieve user")
+		}
+
+		etag := userETag(user)
+		c.Response().Header().Set("ETag", etag)
+		if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		return respond(c, http.StatusOK, presentUser(c, user))
+	}
+}
+
+// updateUserHandler serves PUT /users/:id.
+func updateUserHandler(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
+		}
+		return replaceUser(c, db, id)
+	}
+}
+
+// deleteUserHandler serves DELETE /users/:id.
+func deleteUserHandler(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "Invalid user ID", "Invalid user ID")
+		}
+		err = deleteUser(c.Request().Context(), db, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return newAPIError(http.StatusNotFound, "User not found", "User not found")
+			}
+			return databaseError(err, "Failed to delete user", "Failed to delete user")
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// replaceUser applies the PUT body of c to user id and writes the response.
+func replaceUser(c echo.Context, db *sql.DB, id int) error {
+	var user User
+	if err := c.Bind(&user); err != nil {
+		return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+	}
+	normalizeUser(&user)
+	if err := c.Validate(user); err != nil {
+		return validationError(user, err)
+	}
+	unmodifiedSince, err := checkIfMatch(c, db, id)
+	if err != nil {
+		return err
+	}
+	err = updateUser(c.Request().Context(), db, id, &user, unmodifiedSince)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+		}
+		if err == errUserModified {
+			return errPreconditionFailed
+		}
+		if err.Error() == "username_or_email_exists" {
+			return usernameOrEmailExistsError(err)
+		}
+		log.Errorf("request %s: updating user %d: %v", requestID(c), id, err)
+		return databaseError(err, "failed_to_update_user", "Failed to update user")
+	}
+	user.ID = id
+	c.Response().Header().Set("ETag", userETag(user))
+	return respond(c, http.StatusOK, presentUser(c, user))
+}
+
+// CountResponse is the body of GET /users/count.
+type CountResponse struct {
+	Count int `json:"count"`
+}
+
+// countUsersHandler serves GET /users/count, the number of users GET /users
+// would list for the same filter, q and email, without reading them.
+// ?verified=true|false narrows it to verified or unverified users.
+func countUsersHandler(config *Config, db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filter, err := parseFilterExpression(c.QueryParam("filter"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_filter", "Invalid filter expression").With("details", err.Error())
+		}
+		if value := c.QueryParam("verified"); value != "" {
+			verified, err := strconv.ParseBool(value)
+			if err != nil {
+				return newAPIError(http.StatusBadRequest, "invalid_verified", "verified must be true or false")
+			}
+			filter.Verified = &verified
+		}
+		filter.Search = c.QueryParam("q")
+		filter.CaseSensitive = !config.App.CaseInsensitiveSearch
+		filter.Email = c.QueryParam("email")
+
+		count, err := countUsers(c.Request().Context(), db, filter)
+		if err != nil {
+			return databaseError(err, "failed_to_count_users", "Failed to count users")
+		}
+		return c.JSON(http.StatusOK, CountResponse{Count: count})
+	}
+}
+
+// updateUser replaces the editable fields of user id, returning
+// sql.ErrNoRows if the user doesn't exist or is deleted. When
+// unmodifiedSince is set the update only applies if the stored updated_at
+// still equals it, and errUserModified is returned otherwise.
+func updateUser(ctx context.Context, db *sql.DB, id int, user *User, unmodifiedSince *time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	user.Email = normalizeEmail(user.Email)
+
 	var existingUser User
-	err := db.QueryRow("SELECT id FROM users WHERE (username = $1 OR email = $2) AND id != $3", user.Username, user.Email, id).Scan(&existingUser.ID)
+	err := db.QueryRowContext(ctx, "SELECT id, username FROM users WHERE (LOWER(username) = LOWER($1) OR LOWER(email) = $2) AND id != $3 AND tenant_id = (SELECT tenant_id FROM users WHERE id = $3 AND deleted_at IS NULL)", user.Username, user.Email, id).Scan(&existingUser.ID, &existingUser.Username)
 	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
 	if existingUser.ID != 0 {
-		return errors.New("username_or_email_exists")
+		return &duplicateUserError{Field: duplicateField(existingUser.Username, user.Username)}
 	}
 
 	queryBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar).
@@ -225,31 +1019,49 @@ func updateUser(db *sql.DB, id int, user *User) error {
 		Set("email", user.Email).
 		Set("profile_picture_url", user.ProfilePictureURL).
 		Set("bio", user.Bio).
-		Where(squirrel.Eq{"id": id}).
-		Suffix("RETURNING updated_at")
+		Set("timezone", user.Timezone).
+		Set("updated_at", squirrel.Expr("NOW()")).
+		Where(squirrel.Eq{"id": id, "deleted_at": nil}).
+		Suffix("RETURNING uuid, tenant_id, email_verified, updated_at")
+	if unmodifiedSince != nil {
+		queryBuilder = queryBuilder.Where(squirrel.Eq{"updated_at": *unmodifiedSince})
+	}
 
-	sql, args, err := queryBuilder.ToSql()
+	query, args, err := queryBuilder.ToSql()
 	if err != nil {
-		fmt.Printf("Error building SQL for updateUser: %s, error: %v", sql, err)
+		fmt.Printf("Error building SQL for updateUser: %s, error: %v", query, err)
 		return err
 	}
 
-	err = db.QueryRow(sql, args...).Scan(&user.UpdatedAt)
+	err = db.QueryRowContext(ctx, query, args...).Scan(&user.UUID, &user.TenantID, &user.EmailVerified, &user.UpdatedAt)
+	if dup := duplicateUserFromDB(err); dup != nil {
+		return dup
+	}
+	if err == sql.ErrNoRows && unmodifiedSince != nil {
+		return errUserModified
+	}
 	if err != nil {
-		fmt.Printf("Error executing updateUser: %s, args: %v, error: %v", sql, args, err)
+		fmt.Printf("Error executing updateUser: %s, args: %v, error: %v", query, args, err)
 		return err
 	}
 
+	invalidateUser(id)
 	fmt.Printf("User updated: %s", user.Username)
 
 	return nil
 }
 
-func deleteUser(db *sql.DB, id int) error {
+// deleteUser soft deletes user id and revokes the user's access tokens, so
+// a token issued before the deletion can't be used to edit the deleted row.
+func deleteUser(ctx context.Context, db *sql.DB, id int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
 	deletedAt := time.Now()
 	queryBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar).
 		Update("users").
 		Set("deleted_at", deletedAt).
+		Set("tokens_revoked_at", squirrel.Expr("NOW()")).
 		Where(squirrel.Eq{"id": id})
 	sql, args, err := queryBuilder.ToSql()
 	if err != nil {
@@ -257,7 +1069,7 @@ func deleteUser(db *sql.DB, id int) error {
 		return err
 	}
 
-	result, err := db.Exec(sql, args...)
+	result, err := db.ExecContext(ctx, sql, args...)
 	if err != nil {
 		fmt.Printf("Error executing deleteUser: %s, args: %v, error: %v", sql, args, err)
 		return err
@@ -273,6 +1085,7 @@ func deleteUser(db *sql.DB, id int) error {
 		return errors.New("user not found")
 	}
 
+	invalidateUser(id)
 	fmt.Printf("User soft deleted: %d", id)
 
 	return nil
@@ -295,176 +1108,908 @@ func dbConnect(cfg *Config) (*sql.DB, error) {
 		cfg.Database.Port,
 		cfg.Database.SSLMode,
 	)
-	db, err := sql.Open("postgres", psqlInfo)
+	db, err := openDB(psqlInfo, cfg.Database.TimeZone)
 	if err != nil {
 		return nil, err
 	}
+	if err := configurePool(db, cfg); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return db, db.Ping()
 }
 
+// knownQueryParams lists the query parameters each endpoint understands, for
+// Config.App.StrictQueryParams.
+var knownQueryParams = map[string][]string{
+	"GET /users":                         {"page", "pageSize", "limit", "after", "filter", "q", "email", "sort", "order", "timeFormat", "envelope"},
+	"GET /users/:id":                     {"timeFormat"},
+	"GET /users/count":                   {"filter", "q", "email", "verified"},
+	"GET /users/recent":                  {"limit"},
+	"GET /users/:id/verification-status": {},
+	"POST /login":                        {},
+	"POST /token/refresh":                {},
+	"POST /logout":                       {},
+	"POST /unlock":                       {},
+	"POST /password-reset/request":       {},
+	"POST /password-reset/confirm":       {},
+	"GET /password-reset/validate":       {"token"},
+	"POST /users":                        {"timeFormat"},
+	"POST /users/batch":                  {"timeFormat"},
+	"POST /users/:id/avatar":             {},
+	"GET /stats/sources":                 {},
+	"POST /admin/users/:id/logout":       {},
+	"POST /admin/test-email":             {},
+	"POST /users/:id/restore":            {"timeFormat"},
+	"DELETE /admin/users/:id":            {},
+	"POST /users/:id/anonymize":          {},
+	"GET /users/me/permissions":          {},
+	"GET /me":                            {},
+	"PUT /me":                            {},
+	"POST /me/change-password":           {},
+	"GET /users/export":                  {"filter", "q", "email"},
+	"PUT /users/:id":                     {"timeFormat"},
+	"PATCH /users/:id":                   {"timeFormat"},
+	"DELETE /users/:id":                  {},
+}
+
+// publicCacheRoutes are the routes whose anonymous responses are the same
+// for everyone and may be cached for Config.App.PublicCacheMaxAge.
+var publicCacheRoutes = map[string]bool{
+	"GET /users/:id": true,
+	"GET /swagger/*": true,
+}
+
+// uncompressedPaths are the routes whose responses are never gzipped. They
+// are polled often by scrapers and probes that gain little from it.
+var uncompressedPaths = map[string]bool{
+	"/metrics": true,
+}
+
+// negotiatedRoutes are the routes that answer in JSON or XML depending on
+// the Accept header; every other route always sends JSON.
+var negotiatedRoutes = map[string]bool{
+	"GET /users":              true,
+	"GET /users/:id":          true,
+	"GET /users/recent":       true,
+	"POST /users":             true,
+	"POST /users/batch":       true,
+	"PUT /users/:id":          true,
+	"PATCH /users/:id":        true,
+	"POST /users/:id/restore": true,
+	"GET /me":                 true,
+	"PUT /me":                 true,
+}
+
+// @title User Management API
+// @version 1.0
+// @description Users, authentication and administration for the website.
+// @host localhost:8080
+// @BasePath /
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "apply pending database migrations and exit")
+	seedCount := flag.Int("seed", 0, "insert `N` fake users into a dev or test database and exit")
+	flag.Parse()
+
 	config, err := readConfig("config.json")
 	if err != nil {
 		log.Fatalf("Error reading config: %v", err)
 	}
 
+	if config.App.JwtSecret == "" {
+		log.Fatalf("Error reading config: jwt_secret must be set")
+	}
+	if !isKnownRole(config.App.DefaultRole) {
+		log.Fatalf("Error reading config: default_role %q is not one of %v", config.App.DefaultRole, knownRoles)
+	}
+
+	address, err := listenAddress(config)
+	if err != nil {
+		log.Fatalf("Error reading config: %v", err)
+	}
+
 	location, err := time.LoadLocation(config.App.TimeZone)
 	if err != nil {
 		log.Fatalf("Error loading timezone: %v", err)
 	}
 	time.Local = location
 
+	queryTimeout = time.Duration(config.Database.QueryTimeout) * time.Second
+	defaultProfilePictureURL = config.App.DefaultProfilePictureURL
+	completenessWeights = config.App.ProfileCompletenessWeights
+	nullOptionalFields = config.App.NullOptionalFields
+	passwordHasher, err = newPasswordHasher(config.App.PasswordHasher)
+	if err != nil {
+		log.Fatalf("Error reading config: %v", err)
+	}
+	if err := checkIDType(config.App.IDType); err != nil {
+		log.Fatalf("Error reading config: %v", err)
+	}
+	uuidIDs = config.App.IDType == idTypeUUID
+	if config.App.UnprocessableValidationErrors {
+		validationErrorStatus = http.StatusUnprocessableEntity
+	}
+	userCacheTTL = newCacheTTLPolicy(config.App.UserCacheTTL.Duration, config.App.UserCacheHotTTL.Duration, config.App.UserCacheHotReads)
+
 	db, err := dbConnect(config)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
+	if *migrateOnly || config.Database.AutoMigrate {
+		applied, err := migrate(context.Background(), db, embeddedMigrations())
+		if err != nil {
+			log.Fatalf("Error migrating database: %v", err)
+		}
+		log.Infof("Applied %d database migrations", applied)
+		if *migrateOnly {
+			db.Close()
+			return
+		}
+	}
+
+	if err := checkSchema(db); err != nil {
+		log.Fatalf("Database not ready: %v", err)
+	}
+
+	if *seedCount > 0 {
+		created, skipped, err := seedUsers(context.Background(), db, config, LogEmailSender{}, *seedCount)
+		if err != nil {
+			log.Fatalf("Error seeding database: %v", err)
+		}
+		log.Infof("Seeded %d users (%d already existed); their password is %s", created, skipped, seedPassword)
+		db.Close()
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go runAuditPruner(ctx, db, config)
+	go runUsernameReleaser(ctx, db, config)
+	go runTokenPruner(ctx, db, config)
+
+	// The test email endpoint sends directly so it can report failures;
+	// everything else goes through the queue.
+	directEmailSender := newEmailSender(config)
+	emailQueue := newEmailQueue(directEmailSender, config.SMTP.Workers, config.SMTP.QueueSize)
+	emailSender := EmailSender(emailQueue)
+
 	e := echo.New()
+	ipExtractor, err := newIPExtractor(config.App.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Error configuring trusted proxies: %v", err)
+	}
+	e.IPExtractor = ipExtractor
+	trustedProxies, err := parseIPAllowlist(config.App.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Error configuring trusted proxies: %v", err)
+	}
+	// Routes are served under /api/v1; the unprefixed paths remain as
+	// deprecated aliases until the remaining clients have moved.
+	e.Pre(apiVersionPrefix("/api/v1", []string{"/swagger/", "/metrics", "/uploads/"}))
+	settings := newRuntimeSettings(config, e.Logger)
+
+	e.Use(middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		TargetHeader: echo.HeaderXRequestID,
+	}))
+	e.Use(requestLogger(e.Logger, os.Stdout))
+
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"http://localhost:4200"},
-		AllowMethods: []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete},
+		AllowOriginFunc: settings.allowOrigin,
+		AllowMethods:    []string{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete},
 	}))
 
-	e.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(rate.Limit(config.App.RateLimit))))
+	gzipper, err := compressResponses(config.App.GzipLevel, config.App.GzipMinLength, uncompressedPaths)
+	if err != nil {
+		log.Fatalf("Error reading config: %v", err)
+	}
+	e.Use(gzipper)
 
-	switch config.App.LogLevel {
-	case "DEBUG":
-		e.Logger.SetLevel(log.DEBUG)
-	case "INFO":
-		e.Logger.SetLevel(log.INFO)
-	case "WARN":
-		e.Logger.SetLevel(log.WARN)
-	case "ERROR":
-		e.Logger.SetLevel(log.ERROR)
-	default:
-		e.Logger.SetLevel(log.INFO)
+	if config.App.MetricsEnabled {
+		metrics := newRequestMetrics(prometheus.DefBuckets, config.App.MetricsMaxRoutes)
+		e.Use(metrics.middleware())
+		e.GET("/metrics", metrics.handler, RequireInternalToken(config.App.MetricsToken))
 	}
 
-	e.Validator = &CustomValidator{validator: validator.New()}
+	e.Use(contentTypeCharset(config.App.Charset))
+	e.Use(cacheControl(config.App.PublicCacheMaxAge.Duration, publicCacheRoutes))
+	e.Use(negotiateMediaType(negotiatedRoutes))
+
+	rateLimiter, err := rateLimitMiddleware(config, func() (middleware.RateLimiterStore, error) {
+		store, err := openRateLimitStore(config.App.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		settings.rateLimit = store
+		return store, nil
+	})
+	if err != nil {
+		log.Fatalf("Error configuring rate limiter: %v", err)
+	}
+	e.Use(rateLimiter)
+	go watchConfigReload(ctx, "config.json", settings)
+
+	v, err := newValidator(config)
+	if err != nil {
+		log.Fatalf("Error configuring validator: %v", err)
+	}
+	e.Validator = &CustomValidator{validator: v}
+	e.HTTPErrorHandler = httpErrorHandler
+	e.Use(strictQueryParams(config.App.StrictQueryParams, knownQueryParams))
+	e.Use(resolveUserIDParam(db))
 
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
+	blobStore, err := newBlobStore(config)
+	if err != nil {
+		log.Fatalf("Error configuring storage: %v", err)
+	}
+	emailVerifier, err := newEmailVerifier(config)
+	if err != nil {
+		log.Fatalf("Error configuring email verification: %v", err)
+	}
+	if config.Storage.Driver == "local" {
+		e.Static("/uploads", config.Storage.LocalDir)
+	}
+
+	// @Summary List users
+	// @Description Admin only. Pages through users either by page number (page, pageSize) or by cursor (after, limit). Prefer cursors for large lists: each page is a keyset query, so it stays fast deep into the list and doesn't skip or repeat users when others are created or deleted between requests. Pass the previous page's nextCursor as after, or for sort=id simply the last ID seen. With id_type uuid, sort=id and plain IDs in after are refused.
+	// @Tags admin
+	// @Produce json,xml
+	// @Security BearerAuth
+	// @Param page query int false "Page number, for offset pagination"
+	// @Param pageSize query int false "Page size"
+	// @Param limit query int false "Same as pageSize"
+	// @Param after query string false "nextCursor of the previous page, or a user ID when sorting by id and id_type is int"
+	// @Param filter query string false "Filter expression"
+	// @Param q query string false "Search username and email"
+	// @Param email query string false "Exact email"
+	// @Param sort query string false "Sort column"
+	// @Param order query string false "asc or desc"
+	// @Param timeFormat query string false "unix for Unix timestamps instead of RFC 3339"
+	// @Param envelope query bool false "false for a bare array instead of a UserPage"
+	// @Success 200 {object} UserPage
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users [get]
 	e.GET("/users", func(c echo.Context) error {
-		page, err := strconv.Atoi(c.QueryParam("page"))
-		if err != nil || page < 1 {
-			page = 1
+		pagination, err := parsePagination(c.QueryParams())
+		if err != nil {
+			return err
 		}
-		pageSize, err := strconv.Atoi(c.QueryParam("pageSize"))
-		if err != nil || pageSize < 1 {
-			pageSize = 10
+		page, pageSize := pagination.Page, pagination.PageSize
+		filter, err := parseFilterExpression(c.QueryParam("filter"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_filter", "Invalid filter expression").With("details", err.Error())
+		}
+		filter.Search = c.QueryParam("q")
+		filter.CaseSensitive = !config.App.CaseInsensitiveSearch
+		filter.Email = c.QueryParam("email")
+		sort, err := parseUserSort(c.QueryParam("sort"), c.QueryParam("order"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_sort", "Invalid sort").With("details", err.Error())
 		}
 
-		users, err := getUsers(db, page, pageSize)
+		etag, err := listETag(db, filter, c.Request().URL.RawQuery)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Failed to retrieve users"})
+			return newAPIError(http.StatusInternalServerError, "Failed to retrieve users", "Failed to retrieve users")
+		}
+		c.Response().Header().Set("ETag", etag)
+		if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+			return c.NoContent(http.StatusNotModified)
 		}
-		return c.JSON(http.StatusOK, users)
-	})
 
-	e.GET("/users/:id", func(c echo.Context) error {
-		id, err := strconv.Atoi(c.Param("id"))
+		var users []User
+		if pagination.After != nil {
+			if pagination.After.bare && sort.Column != "id" {
+				return errBareCursorSort
+			}
+			users, err = getUsersAfter(c.Request().Context(), db, *pagination.After, pageSize, filter, sort)
+			page = 0
+		} else {
+			users, err = getUsers(c.Request().Context(), db, page, pageSize, filter, sort)
+		}
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "Invalid user ID"})
+			return databaseError(err, "Failed to retrieve users", "Failed to retrieve users")
+		}
+		if c.QueryParam("envelope") == "false" {
+			return respond(c, http.StatusOK, presentUsers(c, users))
 		}
-		user, err := getUserByID(db, id)
+		total, err := countUsers(c.Request().Context(), db, filter)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				return c.JSON(http.StatusNotFound, map[string]interface{}{"error": "User not found"})
+			return databaseError(err, "Failed to retrieve users", "Failed to retrieve users")
+		}
+		userPage := newUserPage(presentUsers(c, users), page, pageSize, total)
+		if len(users) == pageSize {
+			userPage.NextCursor = encodeUserCursor(sort, users[len(users)-1])
+		}
+		// RequestURI still has the /api/v1 prefix the router stripped.
+		path := c.Request().URL.Path
+		if requestURI, err := url.ParseRequestURI(c.Request().RequestURI); err == nil {
+			path = requestURI.Path
+		}
+		links := paginationLinks(linkBaseURL(c, config.App.FrontendBaseURL, trustedProxies), path, c.QueryParams(), userPage)
+		if links != "" {
+			c.Response().Header().Set("Link", links)
+		}
+		return respond(c, http.StatusOK, userPage)
+	}, RequireAuth(config, db), RequireRole(db, roleAdmin))
+
+	// @Summary Count users
+	// @Description Admin only. Counts the users matching the same filters as GET /users without returning them.
+	// @Tags admin
+	// @Produce json
+	// @Security BearerAuth
+	// @Param filter query string false "Filter expression"
+	// @Param q query string false "Search username and email"
+	// @Param email query string false "Exact email"
+	// @Param verified query bool false "Only verified or unverified users"
+	// @Success 200 {object} CountResponse
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/count [get]
+	e.GET("/users/count", countUsersHandler(config, db), RequireAuth(config, db), RequireRole(db, roleAdmin))
+
+	// @Summary Recently active users
+	// @Description Admin only. Lists the users who logged in most recently, newest first. Users who never logged in and deleted users are left out.
+	// @Tags admin
+	// @Produce json,xml
+	// @Security BearerAuth
+	// @Param limit query int false "Number of users, at most MaxRecentUsers (default 20)"
+	// @Success 200 {array} User
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/recent [get]
+	e.GET("/users/recent", recentUsersHandler(config, db), RequireAuth(config, db), RequireRole(db, roleAdmin))
+
+	// @Summary Get a user
+	// @Description Returns a user by ID with an ETag. A request whose If-None-Match still matches gets 304 without a body.
+	// @Tags users
+	// @Produce json,xml
+	// @Param id path string true "User ID: an integer, or a UUID when id_type is uuid"
+	// @Param timeFormat query string false "unix for Unix timestamps instead of RFC 3339"
+	// @Param If-None-Match header string false "ETag of a cached copy"
+	// @Success 200 {object} User
+	// @Success 304
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/{id} [get]
+	e.GET("/users/:id", getUserHandler(db))
+
+	// @Summary Export users as CSV
+	// @Description Admin only. Streams every matching user as CSV. All pages are read from one database snapshot, so users created or deleted during the export don't shift the results.
+	// @Tags admin
+	// @Produce text/csv
+	// @Security BearerAuth
+	// @Param filter query string false "Filter expression"
+	// @Param q query string false "Search username and email"
+	// @Param email query string false "Exact email"
+	// @Success 200 {string} string
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/export [get]
+	e.GET("/users/export", func(c echo.Context) error {
+		filter, err := parseFilterExpression(c.QueryParam("filter"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_filter", "Invalid filter expression").With("details", err.Error())
+		}
+		filter.Search = c.QueryParam("q")
+		filter.CaseSensitive = !config.App.CaseInsensitiveSearch
+		filter.Email = c.QueryParam("email")
+
+		snapshot, err := beginUserSnapshot(c.Request().Context(), db, filter)
+		if err != nil {
+			log.Errorf("request %s: starting export: %v", requestID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_export_users", "Failed to export users")
+		}
+		defer snapshot.close()
+
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="users.csv"`)
+		c.Response().WriteHeader(http.StatusOK)
+		// The status has been sent by now, so a failure part way through can
+		// only be logged; the client sees a truncated file.
+		if err := writeUsersCSV(c.Request().Context(), c.Response(), snapshot, exportPageSize, c.Response().Flush); err != nil {
+			log.Errorf("request %s: writing export: %v", requestID(c), err)
+		}
+		return nil
+	}, RequireAuth(config, db), RequireRole(db, roleAdmin))
+
+	// @Summary Get the authenticated user's permissions
+	// @Description Returns the user's role and the permissions it grants, for showing or hiding UI elements.
+	// @Tags users
+	// @Produce json
+	// @Security BearerAuth
+	// @Success 200 {object} UserPermissions
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/me/permissions [get]
+	e.GET("/users/me/permissions", permissionsHandler(config, db), RequireAuth(config, db))
+
+	// @Summary Get the authenticated user
+	// @Description Returns the profile of the user the token was issued to, so the frontend doesn't need to know its own ID.
+	// @Tags users
+	// @Produce json,xml
+	// @Security BearerAuth
+	// @Success 200 {object} User
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /me [get]
+	e.GET("/me", meHandler(db), RequireAuth(config, db))
+
+	// @Summary Update the authenticated user
+	// @Description Same as PUT /users/{id} for the user the token was issued to.
+	// @Tags users
+	// @Accept json
+	// @Produce json,xml
+	// @Security BearerAuth
+	// @Param user body User true "User"
+	// @Success 200 {object} User
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 409 {object} map[string]interface{}
+	// @Failure 412 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /me [put]
+	e.PUT("/me", updateMeHandler(db), RequireAuth(config, db))
+
+	// @Summary Change the authenticated user's password
+	// @Description Sets a new password after checking the current one. Refresh tokens issued before are revoked, so other sessions have to log in again.
+	// @Tags auth
+	// @Accept json
+	// @Produce json
+	// @Security BearerAuth
+	// @Param request body ChangePasswordRequest true "Current and new password"
+	// @Success 200 {object} map[string]interface{}
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 422 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /me/change-password [post]
+	e.POST("/me/change-password", changePasswordHandler(config, db), RequireAuth(config, db))
+
+	// @Summary Get email verification status
+	// @Description Reports whether the user's email is verified and any pending email change. Users can only read their own status; admins can read anyone's.
+	// @Tags users
+	// @Produce json
+	// @Security BearerAuth
+	// @Param id path string true "User ID: an integer, or a UUID when id_type is uuid"
+	// @Success 200 {object} VerificationStatus
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/{id}/verification-status [get]
+	e.GET("/users/:id/verification-status", verificationStatusHandler(db), RequireAuth(config, db), RequireSelfOrRole(db, roleAdmin))
+
+	// @Summary Log in
+	// @Description Exchange a username or email and password for an access token
+	// @Tags auth
+	// @Accept json
+	// @Produce json
+	// @Param credentials body LoginRequest true "Credentials"
+	// @Success 200 {object} TokenResponse
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 423 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /login [post]
+	e.POST("/login", func(c echo.Context) error {
+		var req LoginRequest
+		if err := c.Bind(&req); err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+		}
+		if err := c.Validate(req); err != nil {
+			return validationError(req, err)
+		}
+		userID, err := authenticateUser(db, config, emailSender, req.TenantID, req.Login, req.Password)
+		if err != nil {
+			if err == errInvalidCredentials {
+				return newAPIError(http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
+			}
+			if err == errAccountLocked {
+				return newAPIError(http.StatusLocked, "account_locked", "Account is locked after too many failed logins; try again later")
 			}
-			return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Failed to retrieve user"})
+			return newAPIError(http.StatusInternalServerError, "failed_to_log_in", "Failed to log in")
+		}
+		role, err := getUserRole(db, userID)
+		if err != nil {
+			log.Errorf("request %s: reading role: %v", requestID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_log_in", "Failed to log in")
 		}
-		return c.JSON(http.StatusOK, user)
+		token, err := issueToken(config, userID, role)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, "failed_to_log_in", "Failed to log in")
+		}
+		refreshToken, err := createRefreshToken(db, config, userID)
+		if err != nil {
+			log.Errorf("request %s: creating refresh token: %v", requestID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_log_in", "Failed to log in")
+		}
+		return c.JSON(http.StatusOK, TokenResponse{AccessToken: token, TokenType: "Bearer", ExpiresIn: int(config.App.TokenTTL.Seconds()), RefreshToken: refreshToken})
+	})
+
+	// @Summary Log out
+	// @Description Revokes the access token used for the request, and the refresh token chain if a refresh token is sent
+	// @Tags auth
+	// @Accept json
+	// @Security BearerAuth
+	// @Param request body LogoutRequest false "Refresh token"
+	// @Success 204
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /logout [post]
+	e.POST("/logout", logoutHandler(db), RequireAuth(config, db))
+
+	// @Summary Unlock a locked account
+	// @Description Lifts a lock from too many failed logins using the token from the lock notification email
+	// @Tags auth
+	// @Accept json
+	// @Param request body UnlockRequest true "Unlock token"
+	// @Success 204
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /unlock [post]
+	e.POST("/unlock", unlockHandler(db))
+
+	// @Summary Refresh an access token
+	// @Description Exchange a refresh token for a new access token and a new refresh token. The old refresh token stops working; presenting it again revokes the whole chain.
+	// @Tags auth
+	// @Accept json
+	// @Produce json
+	// @Param request body RefreshRequest true "Refresh token"
+	// @Success 200 {object} TokenResponse
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /token/refresh [post]
+	e.POST("/token/refresh", refreshTokenHandler(config, db))
+
+	// @Summary Request a password reset
+	// @Description Email a password reset token. Always succeeds so account existence isn't revealed.
+	// @Tags auth
+	// @Accept json
+	// @Produce json
+	// @Param request body PasswordResetRequest true "Email"
+	// @Success 200 {object} map[string]interface{}
+	// @Failure 400 {object} map[string]interface{}
+	// @Router /password-reset/request [post]
+	e.POST("/password-reset/request", func(c echo.Context) error {
+		var req PasswordResetRequest
+		if err := c.Bind(&req); err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+		}
+		if err := c.Validate(req); err != nil {
+			return validationError(req, err)
+		}
+		if err := requestPasswordReset(db, config, emailSender, req.TenantID, req.Email); err != nil {
+			log.Errorf("Error requesting password reset: %v", err)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"status": "reset_requested"})
+	})
+
+	// @Summary Check a password reset token
+	// @Description Reports whether a reset token is valid and unexpired without using it up
+	// @Tags auth
+	// @Produce json
+	// @Param token query string true "Reset token"
+	// @Success 200 {object} map[string]interface{}
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /password-reset/validate [get]
+	e.GET("/password-reset/validate", validateResetTokenHandler(db))
+
+	// @Summary Confirm a password reset
+	// @Description Set a new password using a token from the reset email. All of the user's reset tokens are used up and their sessions are logged out.
+	// @Tags auth
+	// @Accept json
+	// @Produce json
+	// @Param confirmation body PasswordResetConfirmation true "Token and new password"
+	// @Success 200 {object} map[string]interface{}
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /password-reset/confirm [post]
+	e.POST("/password-reset/confirm", func(c echo.Context) error {
+		var req PasswordResetConfirmation
+		if err := c.Bind(&req); err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+		}
+		if err := c.Validate(req); err != nil {
+			return validationError(req, err)
+		}
+		if failed := config.App.PasswordPolicy.Check(req.NewPassword); failed != nil {
+			return weakPasswordError("new_password", failed)
+		}
+		if err := resetPassword(db, req.Token, req.NewPassword); err != nil {
+			if err == errInvalidResetToken {
+				return newAPIError(http.StatusBadRequest, "invalid_reset_token", "Invalid or expired reset token")
+			}
+			return newAPIError(http.StatusInternalServerError, "failed_to_reset_password", "Failed to reset password")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"status": "password_reset"})
 	})
 
 	// @Summary Create a new user
 	// @Description Create a new user with the provided details
 	// @Tags users
 	// @Accept json
-	// @Produce json
+	// @Produce json,xml
 	// @Param user body User true "User"
 	// @Success 201 {object} User
 	// @Failure 400 {object} map[string]interface{}
+	// @Failure 409 {object} map[string]interface{}
 	// @Failure 500 {object} map[string]interface{}
 	// @Router /users [post]
-	e.POST("/users", func(c echo.Context) error {
-		var user User
-		if err := c.Bind(&user); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid_request_payload"})
-		}
-		if err := c.Validate(user); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "validation_failed", "details": err.Error()})
+	e.POST("/users", createUserHandler(config, db, emailSender, emailVerifier))
+
+	// @Summary Create users in bulk
+	// @Description Admin only. Create up to MaxBulkSize users from a JSON array
+	// @Tags users
+	// @Accept json
+	// @Produce json,xml
+	// @Security BearerAuth
+	// @Param users body []User true "Users"
+	// @Success 201 {array} User
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 413 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/batch [post]
+	e.POST("/users/batch", createUsersHandler(config, db, emailSender, emailVerifier), RequireAuth(config, db), RequireRole(db, roleAdmin))
+
+	// @Summary Count users by signup source
+	// @Description Admin only. Counts active users per signup source.
+	// @Tags stats
+	// @Produce json
+	// @Security BearerAuth
+	// @Success 200 {object} map[string]int
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /stats/sources [get]
+	e.GET("/stats/sources", func(c echo.Context) error {
+		counts, err := countUsersBySource(db)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, "failed_to_count_users", "Failed to count users")
 		}
-		err := createUser(db, &user)
+		return c.JSON(http.StatusOK, counts)
+	}, RequireAuth(config, db), RequireRole(db, roleAdmin))
+
+	// @Summary Force a user to log out
+	// @Description Admin only. Revokes every access token issued to the user so far.
+	// @Tags admin
+	// @Produce json
+	// @Security BearerAuth
+	// @Param id path string true "User ID: an integer, or a UUID when id_type is uuid"
+	// @Success 204
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /admin/users/{id}/logout [post]
+	e.POST("/admin/users/:id/logout", func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			if err.Error() == "username_or_email_exists" {
-				return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "username_or_email_exists"})
+			return newAPIError(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
+		}
+		if err := revokeTokens(db, id); err != nil {
+			if err == sql.ErrNoRows {
+				return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
 			}
-			return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "failed_to_create_user"})
+			log.Errorf("request %s: revoking tokens for user %d: %v", requestID(c), id, err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_log_out_user", "Failed to log out user")
 		}
-		return c.JSON(http.StatusCreated, user)
-	})
+		if err := writeAuditLog(db, "user.force_logout", id, authenticatedUserID(c)); err != nil {
+			log.Warnf("request %s: writing audit log for force logout of user %d: %v", requestID(c), id, err)
+		}
+		return c.NoContent(http.StatusNoContent)
+	}, RequireAuth(config, db), RequireRole(db, roleAdmin))
+
+	// @Summary Send a test email
+	// @Description Admin only. Sends a sample message through the configured mailer so SMTP settings can be checked after a deployment.
+	// @Tags admin
+	// @Accept json
+	// @Produce json
+	// @Security BearerAuth
+	// @Param request body TestEmailRequest true "Recipient"
+	// @Success 204
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 502 {object} map[string]interface{}
+	// @Router /admin/test-email [post]
+	e.POST("/admin/test-email", testEmailHandler(directEmailSender), RequireAuth(config, db), RequireRole(db, roleAdmin))
 
 	// @Summary Update an existing user
-	// @Description Update an existing user by their ID
+	// @Description Update an existing user by their ID. Send the ETag from GET /users/{id} as If-Match to have the update refused with 412 if someone else changed the user in the meantime.
 	// @Tags users
 	// @Accept json
-	// @Produce json
-	// @Param id path int true "User ID"
+	// @Produce json,xml
+	// @Security BearerAuth
+	// @Param id path string true "User ID: an integer, or a UUID when id_type is uuid"
 	// @Param user body User true "User"
 	// @Success 200 {object} User
 	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
 	// @Failure 404 {object} map[string]interface{}
 	// @Failure 500 {object} map[string]interface{}
+	// @Failure 412 {object} map[string]interface{}
 	// @Router /users/{id} [put]
-	e.PUT("/users/:id", func(c echo.Context) error {
+	e.PUT("/users/:id", updateUserHandler(db), RequireAuth(config, db), RequireSelf())
+
+	// @Summary Partially update a user
+	// @Description Update only the fields present in the request body. If-Match works as for PUT.
+	// @Tags users
+	// @Accept json
+	// @Produce json,xml
+	// @Security BearerAuth
+	// @Param id path string true "User ID: an integer, or a UUID when id_type is uuid"
+	// @Param user body UserPatch true "Fields to change"
+	// @Success 200 {object} User
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 409 {object} map[string]interface{}
+	// @Failure 412 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/{id} [patch]
+	e.PATCH("/users/:id", patchUserHandler(db), RequireAuth(config, db), RequireSelf())
+
+	// @Summary Delete a user
+	// @Description Delete a user by their ID. Users can delete themselves; admins can delete anyone.
+	// @Tags users
+	// @Security BearerAuth
+	// @Param id path string true "User ID: an integer, or a UUID when id_type is uuid"
+	// @Success 204 {object} nil
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/{id} [delete]
+	e.DELETE("/users/:id", deleteUserHandler(db), RequireAuth(config, db), RequireSelfOrRole(db, roleAdmin))
+
+	// @Summary Upload a profile picture
+	// @Description Stores a JPEG or PNG sent as the multipart field "file" and makes it the user's profile picture
+	// @Tags users
+	// @Accept multipart/form-data
+	// @Produce json
+	// @Security BearerAuth
+	// @Param id path string true "User ID: an integer, or a UUID when id_type is uuid"
+	// @Param file formData file true "JPEG or PNG image"
+	// @Success 200 {object} AvatarResponse
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/{id}/avatar [post]
+	e.POST("/users/:id/avatar", avatarHandler(config, db, blobStore), RequireAuth(config, db), RequireSelf())
+
+	// @Summary Restore a deleted user
+	// @Description Admin only. Undoes a soft delete made within the configured restore window.
+	// @Tags admin
+	// @Produce json,xml
+	// @Security BearerAuth
+	// @Param id path string true "User ID: an integer, or a UUID when id_type is uuid"
+	// @Success 200 {object} User
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 409 {object} map[string]interface{}
+	// @Failure 410 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/{id}/restore [post]
+	e.POST("/users/:id/restore", func(c echo.Context) error {
 		id, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid_user_id"})
+			return newAPIError(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
 		}
-		var user User
-		if err := c.Bind(&user); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid_request_payload"})
+		err = restoreUser(db, id, config.App.RestoreWindow.Duration)
+		switch {
+		case err == sql.ErrNoRows:
+			return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+		case err == errUserNotDeleted:
+			return newAPIError(http.StatusConflict, "user_not_deleted", "User is not deleted")
+		case err == errUserAnonymized:
+			return newAPIError(http.StatusGone, "user_anonymized", "User has been anonymized and can't be restored")
+		case err == errRestoreWindowExpired:
+			return newAPIError(http.StatusGone, "restore_window_expired", "User was deleted too long ago to be restored").With("restore_window", config.App.RestoreWindow.String())
+		case err != nil:
+			log.Errorf("request %s: restoring user %d: %v", requestID(c), id, err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_restore_user", "Failed to restore user")
 		}
-		if err := c.Validate(user); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "validation_failed", "details": err.Error()})
+		if err := writeAuditLog(db, "user.restore", id, authenticatedUserID(c)); err != nil {
+			log.Warnf("request %s: writing audit log for restore of user %d: %v", requestID(c), id, err)
 		}
-		err = updateUser(db, id, &user)
+
+		user, err := getUserByID(c.Request().Context(), db, id)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				return c.JSON(http.StatusNotFound, map[string]interface{}{"error": "user_not_found"})
-			}
-			if err.Error() == "username_or_email_exists" {
-				return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "username_or_email_exists"})
-			}
-			return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "failed_to_update_user"})
+			log.Errorf("request %s: loading restored user %d: %v", requestID(c), id, err)
+			return databaseError(err, "failed_to_retrieve_user", "Failed to retrieve user")
 		}
-		return c.JSON(http.StatusOK, user)
-	})
+		return respond(c, http.StatusOK, presentUser(c, user))
+	}, RequireAuth(config, db), RequireRole(db, roleAdmin))
 
-	// @Summary Delete a user
-	// @Description Delete a user by their ID
-	// @Tags users
-	// @Param id path int true "User ID"
-	// @Success 204 {object} nil
+	// @Summary Purge a deleted user
+	// @Description Admin only. Permanently removes a soft-deleted user and their tokens, for data erasure requests. Active users must be deleted first.
+	// @Tags admin
+	// @Security BearerAuth
+	// @Param id path string true "User ID: an integer, or a UUID when id_type is uuid"
+	// @Success 204
 	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
 	// @Failure 404 {object} map[string]interface{}
+	// @Failure 409 {object} map[string]interface{}
 	// @Failure 500 {object} map[string]interface{}
-	// @Router /users/{id} [delete]
-	e.DELETE("/users/:id", func(c echo.Context) error {
+	// @Router /admin/users/{id} [delete]
+	e.DELETE("/admin/users/:id", func(c echo.Context) error {
 		id, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "Invalid user ID"})
+			return newAPIError(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
 		}
-		err = deleteUser(db, id)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return c.JSON(http.StatusNotFound, map[string]interface{}{"error": "User not found"})
-			}
-			return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Failed to delete user"})
+		err = purgeUser(db, blobStore, id)
+		switch {
+		case err == sql.ErrNoRows:
+			return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+		case err == errUserNotDeleted:
+			return newAPIError(http.StatusConflict, "user_not_deleted", "Only deleted users can be purged")
+		case err != nil:
+			log.Errorf("request %s: purging user %d: %v", requestID(c), id, err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_purge_user", "Failed to purge user")
+		}
+		log.Infof("request %s: user %d purged by user %d", requestID(c), id, authenticatedUserID(c))
+		if err := writeAuditLog(db, "user.purge", id, authenticatedUserID(c)); err != nil {
+			log.Warnf("request %s: writing audit log for purge of user %d: %v", requestID(c), id, err)
 		}
 		return c.NoContent(http.StatusNoContent)
-	})
+	}, RequireAuth(config, db), RequireRole(db, roleAdmin))
 
-	e.GET("/swagger/*", echoSwagger.WrapHandler)
-	e.Logger.Fatal(e.Start(":8080"))
+	// @Summary Anonymize a user
+	// @Description Admin only. Scrubs a user's personal data but keeps the row and ID, as an alternative to purging. The user is deleted and can no longer be restored.
+	// @Tags admin
+	// @Security BearerAuth
+	// @Param id path string true "User ID: an integer, or a UUID when id_type is uuid"
+	// @Success 204
+	// @Failure 400 {object} map[string]interface{}
+	// @Failure 401 {object} map[string]interface{}
+	// @Failure 403 {object} map[string]interface{}
+	// @Failure 404 {object} map[string]interface{}
+	// @Failure 409 {object} map[string]interface{}
+	// @Failure 500 {object} map[string]interface{}
+	// @Router /users/{id}/anonymize [post]
+	e.POST("/users/:id/anonymize", anonymizeHandler(db, blobStore), RequireAuth(config, db), RequireRole(db, roleAdmin))
+
+	if err := serve(ctx, e, address, config.App.ShutdownTimeout.Duration); err != nil {
+		log.Errorf("Server error: %v", err)
+	}
+	emailQueue.Close()
+	if err := db.Close(); err != nil {
+		log.Errorf("Error closing database: %v", err)
+	}
+	log.Infof("Server stopped")
 }
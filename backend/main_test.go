@@ -2,14 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,7 +23,6 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
-	"github.com/patrickmn/go-cache"
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"golang.org/x/time/rate"
 )
@@ -37,7 +39,7 @@ var _ = ginkgo.BeforeSuite(func() {
 		log.Fatal("Error loading .env.test file:", err)
 	}
 
-	userCache = cache.New(5*time.Minute, 10*time.Minute)
+	userCache = newUserCacheStore(5*time.Minute, 10*time.Minute)
 
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
 		os.Getenv("DB_HOST"),
@@ -47,7 +49,7 @@ var _ = ginkgo.BeforeSuite(func() {
 		getEnvAsInt("DB_PORT", 5432),
 		os.Getenv("DB_SSLMODE"),
 	)
-	db, err = sql.Open("postgres", dsn)
+	db, err = openDB(dsn, "UTC")
 	if err != nil {
 		panic("Failed to connect to the test database!")
 	}
@@ -62,6 +64,7 @@ var _ = ginkgo.BeforeSuite(func() {
 	}
 
 	e = echo.New()
+	e.HTTPErrorHandler = httpErrorHandler
 	e.Validator = &CustomValidator{validator: validator.New()}
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"http://localhost:4200"},
@@ -98,7 +101,7 @@ var _ = ginkgo.Describe("Main Application", func() {
 			c := e.NewContext(req, rec)
 			c.SetPath("/users")
 
-			err := createUser(db, &testUser)
+			err := createUser(context.Background(), db, testEmailSender, &testUser)
 			gomega.Expect(err).Should(gomega.BeNil())
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusCreated))
 		})
@@ -114,14 +117,14 @@ var _ = ginkgo.Describe("Main Application", func() {
 			c := e.NewContext(req, rec)
 			c.SetPath("/users")
 
-			err := createUser(db, &testUser)
+			err := createUser(context.Background(), db, testEmailSender, &testUser)
 			gomega.Expect(err).Should(gomega.Not(gomega.BeNil()))
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusBadRequest))
 		})
 
 		ginkgo.It("Should return an error for duplicate username", func() {
 			existingUser := User{Username: "duplicateuser", Email: "duplicateuser@example.com", Password: "password123"}
-			err := createUser(db, &existingUser)
+			err := createUser(context.Background(), db, testEmailSender, &existingUser)
 			gomega.Expect(err).Should(gomega.BeNil())
 
 			testUser := User{Username: "duplicateuser", Email: "another@example.com", Password: "password123"}
@@ -134,10 +137,123 @@ var _ = ginkgo.Describe("Main Application", func() {
 			c := e.NewContext(req, rec)
 			c.SetPath("/users")
 
-			err = createUser(db, &testUser)
+			err = createUser(context.Background(), db, testEmailSender, &testUser)
 			gomega.Expect(err).Should(gomega.Not(gomega.BeNil()))
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusBadRequest))
 		})
+
+		ginkgo.It("Should allow the same email in different tenants", func() {
+			firstUser := User{TenantID: 1, Username: "tenantuser", Email: "shared@example.com", Password: "password123"}
+			err := createUser(context.Background(), db, testEmailSender, &firstUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			secondUser := User{TenantID: 2, Username: "tenantuser", Email: "shared@example.com", Password: "password123"}
+			err = createUser(context.Background(), db, testEmailSender, &secondUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(secondUser.ID).ShouldNot(gomega.Equal(firstUser.ID))
+		})
+
+		ginkgo.It("Should reject a duplicate email within the same tenant", func() {
+			firstUser := User{TenantID: 1, Username: "tenantuser1", Email: "shared@example.com", Password: "password123"}
+			err := createUser(context.Background(), db, testEmailSender, &firstUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			secondUser := User{TenantID: 1, Username: "tenantuser2", Email: "shared@example.com", Password: "password123"}
+			err = createUser(context.Background(), db, testEmailSender, &secondUser)
+			gomega.Expect(err).Should(gomega.MatchError("username_or_email_exists"))
+		})
+
+		ginkgo.It("Should let only one of two concurrent signups with the same email through", func() {
+			errs := make([]error, 2)
+			var wg sync.WaitGroup
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					user := User{Username: fmt.Sprintf("raceuser%d", i), Email: "race@example.com", Password: "password123"}
+					errs[i] = createUser(context.Background(), db, testEmailSender, &user)
+				}(i)
+			}
+			wg.Wait()
+
+			var succeeded int
+			for _, err := range errs {
+				if err == nil {
+					succeeded++
+				} else {
+					gomega.Expect(err).Should(gomega.MatchError("username_or_email_exists"))
+				}
+			}
+			gomega.Expect(succeeded).Should(gomega.Equal(1))
+		})
+
+		ginkgo.It("Should treat emails and usernames differing only in case as duplicates", func() {
+			existing := User{Username: "CaseUser", Email: "Case.User@Example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &existing)).Should(gomega.Succeed())
+			gomega.Expect(existing.Email).Should(gomega.Equal("case.user@example.com"))
+
+			var stored string
+			gomega.Expect(db.QueryRow("SELECT email FROM users WHERE id = $1", existing.ID).Scan(&stored)).Should(gomega.Succeed())
+			gomega.Expect(stored).Should(gomega.Equal("case.user@example.com"))
+
+			var dup *duplicateUserError
+			err := createUser(context.Background(), db, testEmailSender, &User{Username: "otheruser", Email: "CASE.USER@example.COM", Password: "password123"})
+			gomega.Expect(errors.As(err, &dup)).Should(gomega.BeTrue())
+			gomega.Expect(dup.Field).Should(gomega.Equal("email"))
+
+			err = createUser(context.Background(), db, testEmailSender, &User{Username: "caseuser", Email: "fresh@example.com", Password: "password123"})
+			gomega.Expect(errors.As(err, &dup)).Should(gomega.BeTrue())
+			gomega.Expect(dup.Field).Should(gomega.Equal("username"))
+		})
+
+		ginkgo.It("Should reject a mixed-case duplicate on update and patch", func() {
+			existing := User{Username: "caseowner", Email: "owner@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &existing)).Should(gomega.Succeed())
+			other := User{Username: "caseother", Email: "other@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &other)).Should(gomega.Succeed())
+
+			update := User{Username: "caseother", Email: "Owner@Example.com"}
+			gomega.Expect(updateUser(context.Background(), db, other.ID, &update, nil)).Should(gomega.MatchError("username_or_email_exists"))
+
+			email := "OWNER@example.com"
+			_, err := patchUser(db, other.ID, UserPatch{Email: &email}, nil)
+			gomega.Expect(err).Should(gomega.MatchError("username_or_email_exists"))
+		})
+
+		ginkgo.It("Should report which field collided", func() {
+			existing := User{Username: "dupuser", Email: "dupuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &existing)).Should(gomega.Succeed())
+
+			var dup *duplicateUserError
+			err := createUser(context.Background(), db, testEmailSender, &User{Username: "dupuser", Email: "other@example.com", Password: "password123"})
+			gomega.Expect(errors.As(err, &dup)).Should(gomega.BeTrue())
+			gomega.Expect(dup.Field).Should(gomega.Equal("username"))
+
+			err = createUser(context.Background(), db, testEmailSender, &User{Username: "otheruser", Email: "dupuser@example.com", Password: "password123"})
+			gomega.Expect(errors.As(err, &dup)).Should(gomega.BeTrue())
+			gomega.Expect(dup.Field).Should(gomega.Equal("email"))
+
+			apiErr := usernameOrEmailExistsError(err)
+			gomega.Expect(apiErr.Status).Should(gomega.Equal(http.StatusBadRequest))
+			gomega.Expect(apiErr.Fields["field"]).Should(gomega.Equal("email"))
+		})
+
+		ginkgo.It("Should map a unique violation that skipped the pre-check to the field", func() {
+			existing := User{Username: "dupuser", Email: "dupuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &existing)).Should(gomega.Succeed())
+
+			_, err := db.Exec("INSERT INTO users (username, email, password) VALUES ('dupuser', 'fresh@example.com', 'x')")
+			dup := duplicateUserFromDB(err)
+			gomega.Expect(dup).ShouldNot(gomega.BeNil())
+			gomega.Expect(dup.Field).Should(gomega.Equal("username"))
+
+			_, err = db.Exec("INSERT INTO users (username, email, password) VALUES ('freshuser', 'dupuser@example.com', 'x')")
+			dup = duplicateUserFromDB(err)
+			gomega.Expect(dup).ShouldNot(gomega.BeNil())
+			gomega.Expect(dup.Field).Should(gomega.Equal("email"))
+
+			gomega.Expect(duplicateUserFromDB(errors.New("connection refused"))).Should(gomega.BeNil())
+		})
 	})
 
 	ginkgo.Context("GetUserByID", func() {
@@ -153,7 +269,7 @@ var _ = ginkgo.Describe("Main Application", func() {
 			c.SetParamNames("id")
 			c.SetParamValues(strconv.Itoa(testUser.ID))
 
-			user, err := getUserByID(db, testUser.ID)
+			user, err := getUserByID(context.Background(), db, testUser.ID)
 			gomega.Expect(err).Should(gomega.BeNil())
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
 			gomega.Expect(user.Username).Should(gomega.Equal(testUser.Username))
@@ -201,7 +317,7 @@ var _ = ginkgo.Describe("Main Application", func() {
 			c.SetParamNames("id")
 			c.SetParamValues(strconv.Itoa(testUser.ID))
 
-			err = updateUser(db, testUser.ID, &updatedUser)
+			err = updateUser(context.Background(), db, testUser.ID, &updatedUser, nil)
 			gomega.Expect(err).Should(gomega.BeNil())
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
 		})
@@ -234,7 +350,7 @@ var _ = ginkgo.Describe("Main Application", func() {
 			c.SetParamNames("id")
 			c.SetParamValues(strconv.Itoa(testUser.ID))
 
-			err = updateUser(db, testUser.ID, &updatedUser)
+			err = updateUser(context.Background(), db, testUser.ID, &updatedUser, nil)
 			gomega.Expect(err).Should(gomega.Not(gomega.BeNil()))
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusBadRequest))
 		})
@@ -260,7 +376,7 @@ var _ = ginkgo.Describe("Main Application", func() {
 			c.SetParamNames("id")
 			c.SetParamValues(strconv.Itoa(testUser1.ID))
 
-			err = updateUser(db, testUser1.ID, &updatedUser)
+			err = updateUser(context.Background(), db, testUser1.ID, &updatedUser, nil)
 			gomega.Expect(err).Should(gomega.Not(gomega.BeNil()))
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusBadRequest))
 		})
@@ -273,10 +389,26 @@ var _ = ginkgo.Describe("Main Application", func() {
 			c.SetParamNames("id")
 			c.SetParamValues("999")
 
-			err := updateUser(db, 999, &User{})
+			err := updateUser(context.Background(), db, 999, &User{}, nil)
 			gomega.Expect(err).Should(gomega.Not(gomega.BeNil()))
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusNotFound))
 		})
+
+		ginkgo.It("Should not serve a stale cached user after an update", func() {
+			testUser := User{Username: "testuser", Email: "testuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+
+			cached, err := getUserByID(context.Background(), db, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(cached.Username).Should(gomega.Equal("testuser"))
+
+			updatedUser := User{Username: "renamed", Email: "testuser@example.com"}
+			gomega.Expect(updateUser(context.Background(), db, testUser.ID, &updatedUser, nil)).Should(gomega.Succeed())
+
+			fetched, err := getUserByID(context.Background(), db, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(fetched.Username).Should(gomega.Equal("renamed"))
+		})
 	})
 
 	ginkgo.Context("DeleteUser", func() {
@@ -292,7 +424,7 @@ var _ = ginkgo.Describe("Main Application", func() {
 			c.SetParamNames("id")
 			c.SetParamValues(strconv.Itoa(testUser.ID))
 
-			err = deleteUser(db, testUser.ID)
+			err = deleteUser(context.Background(), db, testUser.ID)
 			gomega.Expect(err).Should(gomega.BeNil())
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusNoContent))
 		})
@@ -316,10 +448,23 @@ var _ = ginkgo.Describe("Main Application", func() {
 			c.SetParamNames("id")
 			c.SetParamValues("999")
 
-			err := deleteUser(db, 999)
+			err := deleteUser(context.Background(), db, 999)
 			gomega.Expect(err).Should(gomega.Not(gomega.BeNil()))
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusNotFound))
 		})
+
+		ginkgo.It("Should not serve a cached user after a delete", func() {
+			testUser := User{Username: "testuser", Email: "testuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+
+			_, err := getUserByID(context.Background(), db, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(deleteUser(context.Background(), db, testUser.ID)).Should(gomega.Succeed())
+
+			_, err = getUserByID(context.Background(), db, testUser.ID)
+			gomega.Expect(err).Should(gomega.Equal(sql.ErrNoRows))
+		})
 	})
 
 	ginkgo.Context("GetUsers", func() {
@@ -340,10 +485,64 @@ var _ = ginkgo.Describe("Main Application", func() {
 			page := 1
 			pageSize := 10
 
-			users, err := getUsers(db, page, pageSize)
+			users, err := getUsers(context.Background(), db, page, pageSize, UserFilter{}, defaultUserSort)
 			gomega.Expect(err).Should(gomega.BeNil())
 			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
 			gomega.Expect(len(users)).Should(gomega.Equal(2))
 		})
 	})
+
+	ginkgo.Context("Context cancellation", func() {
+		ginkgo.It("Should not run a query for a cancelled context", func() {
+			testUser := User{Username: "ctxuser", Email: "ctxuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+			invalidateUser(testUser.ID)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := getUserByID(ctx, db, testUser.ID)
+			gomega.Expect(errors.Is(err, context.Canceled)).Should(gomega.BeTrue())
+		})
+
+		ginkgo.It("Should stop waiting for a slow query when the context ends", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			slow := usersQuery(UserFilter{}, defaultUserSort).Where("(SELECT true FROM pg_sleep(5))")
+			_, err := queryUsers(ctx, db, slow)
+			// pq reports the cancelled statement as a server error rather
+			// than the context's own error, so only its presence is checked.
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+			gomega.Expect(time.Since(start)).Should(gomega.BeNumerically("<", 2*time.Second))
+		})
+	})
+
+	ginkgo.Context("Query timeout", func() {
+		var saved time.Duration
+
+		ginkgo.BeforeEach(func() {
+			saved = queryTimeout
+			queryTimeout = 100 * time.Millisecond
+		})
+
+		ginkgo.AfterEach(func() {
+			queryTimeout = saved
+		})
+
+		ginkgo.It("Should cut off a slow query and report it as a database timeout", func() {
+			slow := usersQuery(UserFilter{}, defaultUserSort).Where("(SELECT true FROM pg_sleep(5))")
+			_, err := queryUsers(context.Background(), db, slow)
+			gomega.Expect(isQueryTimeout(err)).Should(gomega.BeTrue())
+
+			apiErr := databaseError(err, "Failed to retrieve users", "Failed to retrieve users")
+			gomega.Expect(apiErr.Status).Should(gomega.Equal(http.StatusServiceUnavailable))
+			gomega.Expect(apiErr.Code).Should(gomega.Equal("database_timeout"))
+		})
+
+		ginkgo.It("Should keep other database errors as 500s", func() {
+			apiErr := databaseError(errors.New("connection refused"), "Failed to retrieve users", "Failed to retrieve users")
+			gomega.Expect(apiErr.Status).Should(gomega.Equal(http.StatusInternalServerError))
+		})
+	})
 })
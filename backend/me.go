@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+var errWrongPassword = errors.New("wrong_password")
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8"`
+}
+
+// meHandler serves GET /me, the profile of the authenticated user. Deleting
+// a user revokes their tokens, so 404 is only seen by a request that races
+// the deletion. It must run after RequireAuth.
+func meHandler(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := getUserByID(c.Request().Context(), db, authenticatedUserID(c))
+		if err == sql.ErrNoRows {
+			return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+		}
+		if err != nil {
+			return databaseError(err, "failed_to_retrieve_user", "Failed to retrieve user")
+		}
+		c.Response().Header().Set("ETag", userETag(user))
+		return respond(c, http.StatusOK, presentUser(c, user))
+	}
+}
+
+// updateMeHandler serves PUT /me, PUT /users/:id for the authenticated user.
+// It must run after RequireAuth.
+func updateMeHandler(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return replaceUser(c, db, authenticatedUserID(c))
+	}
+}
+
+// changePassword replaces the password of userID after checking
+// currentPassword, and revokes the user's refresh tokens so other sessions
+// have to log in again.
+func changePassword(db *sql.DB, userID int, currentPassword string, newPassword string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hashedPassword string
+	err = tx.QueryRow("SELECT password FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", userID).Scan(&hashedPassword)
+	if err != nil {
+		return err
+	}
+	ok, err := verifyPassword(hashedPassword, currentPassword)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errWrongPassword
+	}
+
+	newHash, err := passwordHasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET password = $1, updated_at = NOW() WHERE id = $2", newHash, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	invalidateUser(userID)
+	return nil
+}
+
+// changePasswordHandler serves POST /me/change-password. A new password that
+// breaks the password rules or Config.App.PasswordPolicy is answered with
+// 422, a wrong current password with 400. It must run after RequireAuth.
+func changePasswordHandler(config *Config, db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req ChangePasswordRequest
+		if err := c.Bind(&req); err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+		}
+		if err := c.Validate(req); err != nil {
+			apiErr := validationError(req, err)
+			var validationErrors validator.ValidationErrors
+			if errors.As(err, &validationErrors) {
+				for _, fe := range validationErrors {
+					if fe.StructField() == "NewPassword" {
+						apiErr.Status = http.StatusUnprocessableEntity
+					}
+				}
+			}
+			return apiErr
+		}
+		if failed := config.App.PasswordPolicy.Check(req.NewPassword); failed != nil {
+			apiErr := weakPasswordError("new_password", failed)
+			apiErr.Status = http.StatusUnprocessableEntity
+			return apiErr
+		}
+
+		userID := authenticatedUserID(c)
+		err := changePassword(db, userID, req.CurrentPassword, req.NewPassword)
+		switch {
+		case err == errWrongPassword:
+			return newAPIError(http.StatusBadRequest, "wrong_current_password", "Current password is incorrect")
+		case err == sql.ErrNoRows:
+			return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+		case err != nil:
+			log.Errorf("request %s: changing password of user %d: %v", requestID(c), userID, err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_change_password", "Failed to change password")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"status": "password_changed"})
+	}
+}
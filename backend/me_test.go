@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Me", func() {
+	var testUser User
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "meuser", Email: "meuser@example.com", Password: "password123", Bio: "About me"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+	})
+
+	request := func(method, path, token, payload string) *httptest.ResponseRecorder {
+		server := echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.Validator = e.Validator
+		server.GET("/me", meHandler(db), RequireAuth(cfg, db))
+		server.PUT("/me", updateMeHandler(db), RequireAuth(cfg, db))
+		server.POST("/me/change-password", changePasswordHandler(cfg, db), RequireAuth(cfg, db))
+
+		var body io.Reader
+		if payload != "" {
+			body = strings.NewReader(payload)
+		}
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tokenFor := func(id int) string {
+		token, err := issueToken(cfg, id, roleUser)
+		gomega.Expect(err).Should(gomega.BeNil())
+		return token
+	}
+
+	ginkgo.It("Should return the authenticated user without the password", func() {
+		rec := request(http.MethodGet, "/me", tokenFor(testUser.ID), "")
+		gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+
+		var body map[string]interface{}
+		gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(gomega.Succeed())
+		gomega.Expect(body["id"]).Should(gomega.BeNumerically("==", testUser.ID))
+		gomega.Expect(body["username"]).Should(gomega.Equal("meuser"))
+		gomega.Expect(body).ShouldNot(gomega.HaveKey("password"))
+	})
+
+	ginkgo.It("Should return 401 without a token", func() {
+		rec := request(http.MethodGet, "/me", "", "")
+		gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusUnauthorized))
+	})
+
+	ginkgo.It("Should refuse the token once the user is deleted", func() {
+		token := tokenFor(testUser.ID)
+		gomega.Expect(deleteUser(context.Background(), db, testUser.ID)).Should(gomega.Succeed())
+
+		rec := request(http.MethodGet, "/me", token, "")
+		gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusUnauthorized))
+		rec = request(http.MethodPut, "/me", token, `{"username":"renamed","email":"renamed@example.com"}`)
+		gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusUnauthorized))
+	})
+
+	ginkgo.It("Should return 404 on PUT when the user is deleted after authenticating", func() {
+		// The user is deleted through DELETE /users/:id between RequireAuth
+		// and the handler, as a concurrent request could.
+		deleteFirst := func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				del := echo.New()
+				del.DELETE("/users/:id", deleteUserHandler(db))
+				rec := httptest.NewRecorder()
+				del.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/users/%d", testUser.ID), nil))
+				gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusNoContent))
+				return next(c)
+			}
+		}
+		server := echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.Validator = e.Validator
+		server.PUT("/me", updateMeHandler(db), RequireAuth(cfg, db), deleteFirst)
+
+		req := httptest.NewRequest(http.MethodPut, "/me", strings.NewReader(`{"username":"renamed","email":"renamed@example.com","bio":"Rewritten"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+tokenFor(testUser.ID))
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusNotFound))
+
+		var username, bio string
+		gomega.Expect(db.QueryRow("SELECT username, bio FROM users WHERE id = $1", testUser.ID).Scan(&username, &bio)).Should(gomega.Succeed())
+		gomega.Expect(username).Should(gomega.Equal("meuser"))
+		gomega.Expect(bio).Should(gomega.Equal("About me"))
+	})
+
+	ginkgo.It("Should update the authenticated user on PUT", func() {
+		rec := request(http.MethodPut, "/me", tokenFor(testUser.ID), `{"username":"meuser","email":"meuser@example.com","bio":"Updated"}`)
+		gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+
+		user, err := loadUser(context.Background(), db, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(user.Bio).Should(gomega.Equal("Updated"))
+	})
+
+	ginkgo.Context("Change password", func() {
+		changePassword := func(token, payload string) *httptest.ResponseRecorder {
+			return request(http.MethodPost, "/me/change-password", token, payload)
+		}
+
+		ginkgo.It("Should reject a wrong current password", func() {
+			rec := changePassword(tokenFor(testUser.ID), `{"current_password":"wrong-password","new_password":"new-password123"}`)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusBadRequest))
+
+			_, err := authenticateUser(db, cfg, testEmailSender, 0, "meuser", "password123")
+			gomega.Expect(err).Should(gomega.BeNil())
+		})
+
+		ginkgo.It("Should reject a weak new password with 422", func() {
+			rec := changePassword(tokenFor(testUser.ID), `{"current_password":"password123","new_password":"short"}`)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusUnprocessableEntity))
+
+			_, err := authenticateUser(db, cfg, testEmailSender, 0, "meuser", "password123")
+			gomega.Expect(err).Should(gomega.BeNil())
+		})
+
+		ginkgo.It("Should change the password and log out other sessions", func() {
+			refreshToken, err := createRefreshToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			rec := changePassword(tokenFor(testUser.ID), `{"current_password":"password123","new_password":"new-password123"}`)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+
+			_, err = authenticateUser(db, cfg, testEmailSender, 0, "meuser", "password123")
+			gomega.Expect(err).Should(gomega.Equal(errInvalidCredentials))
+			_, err = authenticateUser(db, cfg, testEmailSender, 0, "meuser", "new-password123")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			_, _, err = rotateRefreshToken(db, cfg, refreshToken)
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+	})
+})
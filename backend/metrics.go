@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// otherRoute is the route label used once maxRoutes distinct routes have been
+// seen, so unexpected paths can't grow the number of series without bound.
+const otherRoute = "other"
+
+// userCacheStats counts getUserByID lookups that were served from the cache
+// and ones that had to go to the database.
+var userCacheStats cacheStats
+
+type cacheStats struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// hitRatio returns the share of lookups served from the cache, or 0 before
+// the first lookup.
+func (s *cacheStats) hitRatio() float64 {
+	hits, misses := s.hits.Load(), s.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// requestMetrics records request counts and latency histograms labelled by
+// method, route and status code, and serves them together with the user
+// cache counters from its own Prometheus registry.
+type requestMetrics struct {
+	maxRoutes int
+	requests  *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+	handler   echo.HandlerFunc
+
+	mu     sync.Mutex
+	routes map[string]bool
+}
+
+func newRequestMetrics(buckets []float64, maxRoutes int) *requestMetrics {
+	labels := []string{"method", "route", "status"}
+	m := &requestMetrics{
+		maxRoutes: maxRoutes,
+		routes:    map[string]bool{},
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Requests handled by method, route and status code.",
+		}, labels),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Request latency by method, route and status code.",
+			Buckets: buckets,
+		}, labels),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		m.requests,
+		m.durations,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "user_cache_hits_total",
+			Help: "getUserByID lookups served from the cache.",
+		}, func() float64 { return float64(userCacheStats.hits.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "user_cache_misses_total",
+			Help: "getUserByID lookups that queried the database.",
+		}, func() float64 { return float64(userCacheStats.misses.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "user_cache_hit_ratio",
+			Help: "Share of getUserByID lookups served from the cache.",
+		}, userCacheStats.hitRatio),
+	)
+	// Responses to /metrics are left uncompressed by the gzip middleware,
+	// and the scraper shouldn't have to negotiate it here either.
+	m.handler = echo.WrapHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{DisableCompression: true}))
+	return m
+}
+
+func (m *requestMetrics) observe(method string, route string, status int, seconds float64) {
+	m.mu.Lock()
+	if !m.routes[route] {
+		if len(m.routes) >= m.maxRoutes {
+			route = otherRoute
+		} else {
+			m.routes[route] = true
+		}
+	}
+	m.mu.Unlock()
+
+	labels := prometheus.Labels{"method": method, "route": route, "status": strconv.Itoa(status)}
+	m.requests.With(labels).Inc()
+	m.durations.With(labels).Observe(seconds)
+}
+
+// middleware times every request. Routes are labelled with their registered
+// path (e.g. /users/:id) rather than the raw URL.
+func (m *requestMetrics) middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil {
+				status = http.StatusInternalServerError
+				switch e := err.(type) {
+				case *echo.HTTPError:
+					status = e.Code
+				case *APIError:
+					status = e.Status
+				}
+			}
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			m.observe(c.Request().Method, route, status, time.Since(start).Seconds())
+			return err
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = ginkgo.Describe("Request Metrics", func() {
+	var (
+		server  *echo.Echo
+		metrics *requestMetrics
+	)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	setup := func(maxRoutes int) {
+		metrics = newRequestMetrics(prometheus.DefBuckets, maxRoutes)
+		server = echo.New()
+		server.Use(metrics.middleware())
+		server.GET("/metrics", metrics.handler)
+		server.GET("/users/:id", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+		server.GET("/users", func(c echo.Context) error {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid_filter"})
+		})
+	}
+
+	ginkgo.It("Should expose route-labelled histograms after traffic", func() {
+		setup(50)
+		get("/users/1")
+		get("/users/2")
+		get("/users")
+
+		body := get("/metrics").Body.String()
+		gomega.Expect(body).Should(gomega.ContainSubstring("# TYPE http_request_duration_seconds histogram"))
+		gomega.Expect(body).Should(gomega.ContainSubstring(`http_request_duration_seconds_bucket{method="GET",route="/users/:id",status="200",le="+Inf"} 2`))
+		gomega.Expect(body).Should(gomega.ContainSubstring(`http_request_duration_seconds_count{method="GET",route="/users/:id",status="200"} 2`))
+		gomega.Expect(body).Should(gomega.ContainSubstring(`http_request_duration_seconds_count{method="GET",route="/users",status="400"} 1`))
+		gomega.Expect(body).ShouldNot(gomega.ContainSubstring(`route="/users/1"`))
+	})
+
+	ginkgo.It("Should count requests by route and status code", func() {
+		setup(50)
+		get("/users/1")
+		get("/users/2")
+		get("/users")
+
+		body := get("/metrics").Body.String()
+		gomega.Expect(body).Should(gomega.ContainSubstring("# TYPE http_requests_total counter"))
+		gomega.Expect(body).Should(gomega.ContainSubstring(`http_requests_total{method="GET",route="/users/:id",status="200"} 2`))
+		gomega.Expect(body).Should(gomega.ContainSubstring(`http_requests_total{method="GET",route="/users",status="400"} 1`))
+	})
+
+	ginkgo.It("Should label APIErrors with their status code", func() {
+		setup(50)
+		server.HTTPErrorHandler = httpErrorHandler
+		server.GET("/missing", func(c echo.Context) error {
+			return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+		})
+		get("/missing")
+
+		body := get("/metrics").Body.String()
+		gomega.Expect(body).Should(gomega.ContainSubstring(`http_requests_total{method="GET",route="/missing",status="404"} 1`))
+	})
+
+	ginkgo.It("Should report user cache hits and misses", func() {
+		setup(50)
+		testUser := User{Username: "metricsuser", Email: "metricsuser@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+		invalidateUser(testUser.ID)
+
+		hits, misses := userCacheStats.hits.Load(), userCacheStats.misses.Load()
+		_, err := getUserByID(context.Background(), db, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, err = getUserByID(context.Background(), db, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(userCacheStats.hits.Load() - hits).Should(gomega.Equal(uint64(1)))
+		gomega.Expect(userCacheStats.misses.Load() - misses).Should(gomega.Equal(uint64(1)))
+
+		body := get("/metrics").Body.String()
+		gomega.Expect(body).Should(gomega.ContainSubstring("# TYPE user_cache_hits_total counter"))
+		gomega.Expect(body).Should(gomega.ContainSubstring("# TYPE user_cache_misses_total counter"))
+		gomega.Expect(body).Should(gomega.ContainSubstring("# TYPE user_cache_hit_ratio gauge"))
+	})
+
+	ginkgo.It("Should fold routes beyond the limit into other", func() {
+		setup(1)
+		get("/users/1")
+		get("/users")
+
+		body := get("/metrics").Body.String()
+		gomega.Expect(body).Should(gomega.ContainSubstring(`route="/users/:id"`))
+		gomega.Expect(body).Should(gomega.ContainSubstring(`http_request_duration_seconds_count{method="GET",route="other",status="400"} 1`))
+		gomega.Expect(body).ShouldNot(gomega.ContainSubstring(`route="/users",`))
+	})
+	ginkgo.Context("with a metrics token", func() {
+		scrape := func(authorization string) int {
+			metrics = newRequestMetrics(prometheus.DefBuckets, 50)
+			server = echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.GET("/metrics", metrics.handler, RequireInternalToken("scrape-secret"))
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if authorization != "" {
+				req.Header.Set(echo.HeaderAuthorization, authorization)
+			}
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		ginkgo.It("Should allow a scrape with the token", func() {
+			gomega.Expect(scrape("Bearer scrape-secret")).Should(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("Should reject a scrape without the token", func() {
+			gomega.Expect(scrape("")).Should(gomega.Equal(http.StatusUnauthorized))
+			gomega.Expect(scrape("Bearer wrong")).Should(gomega.Equal(http.StatusUnauthorized))
+		})
+	})
+})
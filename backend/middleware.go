@@ -0,0 +1,168 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
+)
+
+// requestLogFormat writes one JSON object per request for the log pipeline.
+const requestLogFormat = `{"time":"${time_rfc3339_nano}","id":"${id}","remote_ip":"${remote_ip}",` +
+	`"method":"${method}","path":"${path}","status":${status},"latency":${latency},` +
+	`"latency_human":"${latency_human}"}` + "\n"
+
+// contentTypeCharset appends charset to JSON Content-Type headers that don't
+// declare one. Echo's c.JSON sends a bare "application/json", which some
+// strict clients refuse to decode.
+func contentTypeCharset(charset string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			res := c.Response()
+			res.Before(func() {
+				contentType := res.Header().Get(echo.HeaderContentType)
+				if strings.HasPrefix(contentType, echo.MIMEApplicationJSON) && !strings.Contains(contentType, "charset=") {
+					res.Header().Set(echo.HeaderContentType, contentType+"; charset="+charset)
+				}
+			})
+			return next(c)
+		}
+	}
+}
+
+// cacheControl sets the Cache-Control header of every response. Successful
+// anonymous requests to the routes in public, keyed like "GET /users/:id",
+// may be cached by browsers and CDNs for maxAge. Everything else, including
+// authenticated requests, mutations and errors, gets no-store so tokens and
+// per-user data never end up in a shared cache. A header set by the handler
+// is left alone.
+func cacheControl(maxAge time.Duration, public map[string]bool) echo.MiddlewareFunc {
+	publicPolicy := "public, max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			res := c.Response()
+			cacheable := public[req.Method+" "+c.Path()] && req.Header.Get(echo.HeaderAuthorization) == ""
+			res.Before(func() {
+				if res.Header().Get("Cache-Control") != "" {
+					return
+				}
+				if cacheable && res.Status < http.StatusBadRequest {
+					res.Header().Set("Cache-Control", publicPolicy)
+				} else {
+					res.Header().Set("Cache-Control", "no-store")
+				}
+			})
+			return next(c)
+		}
+	}
+}
+
+// compressResponses gzips responses of at least minLength bytes at level
+// for clients that send Accept-Encoding: gzip. Routes whose path is in skip
+// are never compressed.
+func compressResponses(level int, minLength int, skip map[string]bool) (echo.MiddlewareFunc, error) {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, fmt.Errorf("gzip_level %d is out of range", level)
+	}
+	return middleware.GzipWithConfig(middleware.GzipConfig{
+		Skipper: func(c echo.Context) bool {
+			return skip[c.Path()]
+		},
+		Level:     level,
+		MinLength: minLength,
+	}), nil
+}
+
+// requestLogger logs each request to output as a JSON line. Logging follows
+// logger's level, so it stops when the level is raised above INFO, and
+// requests for the Swagger UI are never logged.
+func requestLogger(logger echo.Logger, output io.Writer) echo.MiddlewareFunc {
+	return middleware.LoggerWithConfig(middleware.LoggerConfig{
+		Skipper: func(c echo.Context) bool {
+			return logger.Level() > log.INFO || strings.HasPrefix(c.Path(), "/swagger/")
+		},
+		Format: requestLogFormat,
+		Output: output,
+	})
+}
+
+// apiVersionPrefix serves every route under prefix as well as at its
+// original path. Requests under prefix have it stripped before routing, so
+// routes are registered once. Requests to the old unprefixed paths still
+// work but get a Warning header pointing at the prefixed path and are logged
+// so the remaining callers can be found. Paths starting with one of
+// unversioned, such as /swagger/, are left alone. It must be added with
+// e.Pre so it runs before routing.
+func apiVersionPrefix(prefix string, unversioned []string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			path := req.URL.Path
+			if rest, found := strings.CutPrefix(path, prefix); found && (rest == "" || strings.HasPrefix(rest, "/")) {
+				if rest == "" {
+					rest = "/"
+				}
+				req.URL.Path = rest
+				req.URL.RawPath = ""
+				return next(c)
+			}
+			for _, p := range unversioned {
+				if strings.HasPrefix(path, p) {
+					return next(c)
+				}
+			}
+			c.Response().Header().Set("Warning", fmt.Sprintf(`299 - "Deprecated, use %s%s"`, prefix, path))
+			log.Infof("Deprecated unversioned route %s %s called from %s", req.Method, path, c.RealIP())
+			return next(c)
+		}
+	}
+}
+
+// strictQueryParams rejects requests carrying query parameters outside the
+// known set for their route, listing the unknown ones, so a typo such as
+// pagesize fails loudly instead of being ignored. known is keyed by method and
+// route path, e.g. "GET /users"; routes not in known aren't checked. When
+// enabled is false every request passes.
+func strictQueryParams(enabled bool, known map[string][]string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !enabled {
+				return next(c)
+			}
+			allowed, ok := known[c.Request().Method+" "+c.Path()]
+			if !ok {
+				return next(c)
+			}
+
+			var unknown []string
+			for param := range c.QueryParams() {
+				if !containsString(allowed, param) {
+					unknown = append(unknown, param)
+				}
+			}
+			if len(unknown) > 0 {
+				sort.Strings(unknown)
+				return newAPIError(http.StatusBadRequest, "unknown_query_parameters", "Unknown query parameters").With("params", unknown)
+			}
+			return next(c)
+		}
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
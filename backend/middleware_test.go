@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Middleware", func() {
+	ginkgo.Context("contentTypeCharset", func() {
+		ginkgo.It("Should add the charset to JSON responses", func() {
+			server := echo.New()
+			server.Use(contentTypeCharset(cfg.App.Charset))
+			server.GET("/users", func(c echo.Context) error {
+				return c.JSON(http.StatusOK, []User{})
+			})
+
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+
+			gomega.Expect(rec.Header().Get(echo.HeaderContentType)).Should(gomega.Equal("application/json; charset=utf-8"))
+		})
+
+		ginkgo.It("Should leave an explicit charset alone", func() {
+			server := echo.New()
+			server.Use(contentTypeCharset("utf-8"))
+			server.GET("/users", func(c echo.Context) error {
+				c.Response().Header().Set(echo.HeaderContentType, "application/json; charset=iso-8859-1")
+				return c.JSON(http.StatusOK, []User{})
+			})
+
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+
+			gomega.Expect(rec.Header().Get(echo.HeaderContentType)).Should(gomega.Equal("application/json; charset=iso-8859-1"))
+		})
+	})
+	ginkgo.Context("cacheControl", func() {
+		var server *echo.Echo
+
+		ginkgo.BeforeEach(func() {
+			server = echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.Use(cacheControl(30*time.Second, publicCacheRoutes))
+			server.GET("/users/:id", func(c echo.Context) error {
+				if c.Param("id") == "0" {
+					return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+				}
+				return c.JSON(http.StatusOK, User{})
+			})
+			server.GET("/me", func(c echo.Context) error {
+				return c.JSON(http.StatusOK, User{})
+			})
+			server.POST("/login", func(c echo.Context) error {
+				return c.JSON(http.StatusOK, map[string]string{"token": "secret"})
+			})
+			server.PUT("/users/:id", func(c echo.Context) error {
+				return c.JSON(http.StatusOK, User{})
+			})
+			server.GET("/swagger/*", func(c echo.Context) error {
+				c.Response().Header().Set("Cache-Control", "max-age=3600")
+				return c.NoContent(http.StatusOK)
+			})
+		})
+
+		cacheControlOf := func(method, path, token string) string {
+			req := httptest.NewRequest(method, path, nil)
+			if token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec.Header().Get("Cache-Control")
+		}
+
+		ginkgo.It("Should let anonymous public GETs be cached briefly", func() {
+			gomega.Expect(cacheControlOf(http.MethodGet, "/users/1", "")).Should(gomega.Equal("public, max-age=30"))
+		})
+
+		ginkgo.It("Should not cache authenticated requests to public routes", func() {
+			gomega.Expect(cacheControlOf(http.MethodGet, "/users/1", "token")).Should(gomega.Equal("no-store"))
+		})
+
+		ginkgo.It("Should not cache errors from public routes", func() {
+			gomega.Expect(cacheControlOf(http.MethodGet, "/users/0", "")).Should(gomega.Equal("no-store"))
+		})
+
+		ginkgo.It("Should not cache private GETs, auth endpoints or mutations", func() {
+			gomega.Expect(cacheControlOf(http.MethodGet, "/me", "token")).Should(gomega.Equal("no-store"))
+			gomega.Expect(cacheControlOf(http.MethodPost, "/login", "")).Should(gomega.Equal("no-store"))
+			gomega.Expect(cacheControlOf(http.MethodPut, "/users/1", "token")).Should(gomega.Equal("no-store"))
+		})
+
+		ginkgo.It("Should keep a header set by the handler", func() {
+			gomega.Expect(cacheControlOf(http.MethodGet, "/swagger/index.html", "")).Should(gomega.Equal("max-age=3600"))
+		})
+	})
+	ginkgo.Context("compressResponses", func() {
+		body := strings.Repeat(`{"username":"gzipuser","email":"gzipuser@example.com"},`, 100)
+
+		send := func(path string) *httptest.ResponseRecorder {
+			gzipper, err := compressResponses(gzip.BestSpeed, 1024, uncompressedPaths)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			server := echo.New()
+			server.Use(gzipper)
+			server.GET("/users", func(c echo.Context) error {
+				return c.String(http.StatusOK, body)
+			})
+			server.GET("/users/:id", func(c echo.Context) error {
+				return c.String(http.StatusOK, "short")
+			})
+			server.GET("/metrics", func(c echo.Context) error {
+				return c.String(http.StatusOK, body)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set(echo.HeaderAcceptEncoding, "gzip")
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec
+		}
+
+		ginkgo.It("Should gzip large responses", func() {
+			rec := send("/users")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(rec.Header().Get(echo.HeaderContentEncoding)).Should(gomega.Equal("gzip"))
+			gomega.Expect(rec.Body.Len()).Should(gomega.BeNumerically("<", len(body)))
+
+			reader, err := gzip.NewReader(rec.Body)
+			gomega.Expect(err).Should(gomega.BeNil())
+			decoded, err := io.ReadAll(reader)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(string(decoded)).Should(gomega.Equal(body))
+		})
+
+		ginkgo.It("Should send responses below the threshold as they are", func() {
+			rec := send("/users/7")
+			gomega.Expect(rec.Header().Get(echo.HeaderContentEncoding)).Should(gomega.BeEmpty())
+			gomega.Expect(rec.Body.String()).Should(gomega.Equal("short"))
+		})
+
+		ginkgo.It("Should skip the metrics endpoint", func() {
+			rec := send("/metrics")
+			gomega.Expect(rec.Header().Get(echo.HeaderContentEncoding)).Should(gomega.BeEmpty())
+			gomega.Expect(rec.Body.String()).Should(gomega.Equal(body))
+		})
+
+		ginkgo.It("Should reject levels gzip doesn't support", func() {
+			_, err := compressResponses(10, 1024, nil)
+			gomega.Expect(err).ShouldNot(gomega.BeNil())
+		})
+	})
+	ginkgo.Context("requestLogger", func() {
+		var (
+			server *echo.Echo
+			output *bytes.Buffer
+		)
+
+		ginkgo.BeforeEach(func() {
+			output = &bytes.Buffer{}
+			server = echo.New()
+			server.Logger.SetLevel(log.INFO)
+			server.Use(middleware.RequestID())
+			server.Use(requestLogger(server.Logger, output))
+			server.GET("/users/:id", func(c echo.Context) error {
+				return c.NoContent(http.StatusNotFound)
+			})
+			server.GET("/swagger/*", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+		})
+
+		ginkgo.It("Should write one JSON line per request", func() {
+			req := httptest.NewRequest(http.MethodGet, "/users/7", nil)
+			req.Header.Set(echo.HeaderXRequestID, "req-123")
+			server.ServeHTTP(httptest.NewRecorder(), req)
+
+			var line map[string]interface{}
+			gomega.Expect(json.Unmarshal(output.Bytes(), &line)).Should(gomega.Succeed())
+			gomega.Expect(line["id"]).Should(gomega.Equal("req-123"))
+			gomega.Expect(line["method"]).Should(gomega.Equal("GET"))
+			gomega.Expect(line["path"]).Should(gomega.Equal("/users/7"))
+			gomega.Expect(line["status"]).Should(gomega.BeNumerically("==", 404))
+			gomega.Expect(line).Should(gomega.HaveKey("latency"))
+			gomega.Expect(line).Should(gomega.HaveKey("remote_ip"))
+		})
+
+		ginkgo.It("Should generate a request ID when none is sent", func() {
+			server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/7", nil))
+
+			var line map[string]interface{}
+			gomega.Expect(json.Unmarshal(output.Bytes(), &line)).Should(gomega.Succeed())
+			gomega.Expect(line["id"]).ShouldNot(gomega.BeEmpty())
+		})
+
+		ginkgo.It("Should skip Swagger requests", func() {
+			server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/swagger/index.html", nil))
+			gomega.Expect(output.Len()).Should(gomega.Equal(0))
+		})
+
+		ginkgo.It("Should stay quiet above INFO", func() {
+			server.Logger.SetLevel(log.WARN)
+			server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/7", nil))
+			gomega.Expect(output.Len()).Should(gomega.Equal(0))
+		})
+	})
+	ginkgo.Context("request ID", func() {
+		var server *echo.Echo
+
+		ginkgo.BeforeEach(func() {
+			server = echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.Use(middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+				TargetHeader: echo.HeaderXRequestID,
+			}))
+			server.PUT("/users/:id", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, RequireAuth(cfg, db))
+		})
+
+		decode := func(rec *httptest.ResponseRecorder) map[string]interface{} {
+			var body map[string]interface{}
+			gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(gomega.Succeed())
+			return body
+		}
+
+		ginkgo.It("Should add the request ID to error bodies", func() {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/users/1", nil))
+
+			id := rec.Header().Get(echo.HeaderXRequestID)
+			gomega.Expect(id).ShouldNot(gomega.BeEmpty())
+			body := decode(rec)
+			gomega.Expect(body["error"]).Should(gomega.Equal("missing_token"))
+			gomega.Expect(body["request_id"]).Should(gomega.Equal(id))
+		})
+
+		ginkgo.It("Should keep an incoming request ID", func() {
+			req := httptest.NewRequest(http.MethodPut, "/users/1", nil)
+			req.Header.Set(echo.HeaderXRequestID, "from-frontend-42")
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+
+			gomega.Expect(rec.Header().Get(echo.HeaderXRequestID)).Should(gomega.Equal("from-frontend-42"))
+			gomega.Expect(decode(rec)["request_id"]).Should(gomega.Equal("from-frontend-42"))
+		})
+	})
+	ginkgo.Context("strictQueryParams", func() {
+		send := func(enabled bool, target string) *httptest.ResponseRecorder {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.Use(strictQueryParams(enabled, knownQueryParams))
+			server.GET("/users", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+			return rec
+		}
+
+		ginkgo.It("Should reject a typo'd parameter in strict mode", func() {
+			rec := send(true, "/users?page=2&pagesize=5&sortt=id")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusBadRequest))
+
+			var body map[string]interface{}
+			gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(gomega.Succeed())
+			gomega.Expect(body["error"]).Should(gomega.Equal("unknown_query_parameters"))
+			gomega.Expect(body["params"]).Should(gomega.Equal([]interface{}{"pagesize", "sortt"}))
+		})
+
+		ginkgo.It("Should accept known parameters in strict mode", func() {
+			gomega.Expect(send(true, "/users?page=2&pageSize=5").Code).Should(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("Should ignore unknown parameters when strict mode is off", func() {
+			gomega.Expect(send(false, "/users?pagesize=5").Code).Should(gomega.Equal(http.StatusOK))
+		})
+	})
+
+	ginkgo.Context("apiVersionPrefix", func() {
+		send := func(target string) *httptest.ResponseRecorder {
+			server := echo.New()
+			server.Pre(apiVersionPrefix("/api/v1", []string{"/swagger/"}))
+			server.GET("/users/:id", func(c echo.Context) error {
+				return c.String(http.StatusOK, c.Param("id"))
+			})
+			server.GET("/swagger/*", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+			return rec
+		}
+
+		ginkgo.It("Should mark a legacy route as deprecated", func() {
+			rec := send("/users/7")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(rec.Body.String()).Should(gomega.Equal("7"))
+			gomega.Expect(rec.Header().Get("Warning")).Should(gomega.Equal(`299 - "Deprecated, use /api/v1/users/7"`))
+		})
+
+		ginkgo.It("Should serve the versioned route without a warning", func() {
+			rec := send("/api/v1/users/7")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(rec.Body.String()).Should(gomega.Equal("7"))
+			gomega.Expect(rec.Header().Get("Warning")).Should(gomega.BeEmpty())
+		})
+
+		ginkgo.It("Should leave unversioned paths alone", func() {
+			rec := send("/swagger/index.html")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(rec.Header().Get("Warning")).Should(gomega.BeEmpty())
+		})
+
+		ginkgo.It("Should not treat a look-alike prefix as versioned", func() {
+			rec := send("/api/v1users/7")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationFiles holds the schema migrations, named <version>_<name>.sql.
+// Versions are applied in ascending order and never edited once released;
+// schema changes go in a new file.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock held while migrating, so two
+// instances starting together don't apply the same migration twice.
+const migrationLockID = 7236301
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads the *.sql files in the root of fsys, sorted by version.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	paths, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := make(map[int]string)
+	for _, p := range paths {
+		base := path.Base(p)
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s must be named <version>_<name>.sql", base)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, base, version)
+		}
+		seen[version] = base
+
+		contents, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, sql: string(contents)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// migrate applies the migrations in fsys that schema_migrations doesn't list
+// yet, each in its own transaction, and returns how many it applied.
+func migrate(ctx context.Context, db *sql.DB, fsys fs.FS) (int, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return 0, err
+	}
+
+	// The advisory lock belongs to a session, so everything runs on one
+	// connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return 0, err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		var done bool
+		err := conn.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.version).Scan(&done)
+		if err != nil {
+			return applied, err
+		}
+		if done {
+			continue
+		}
+		if err := applyMigration(ctx, conn, m); err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", m.version, m.name, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// embeddedMigrations returns the migrations compiled into the binary.
+func embeddedMigrations() fs.FS {
+	// fs.Sub only fails for invalid paths, and this one is a constant.
+	sub, _ := fs.Sub(migrationFiles, "migrations")
+	return sub
+}
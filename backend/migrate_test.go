@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing/fstest"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Migrations", func() {
+	ginkgo.Context("loadMigrations", func() {
+		ginkgo.It("Should sort migrations by version", func() {
+			migrations, err := loadMigrations(fstest.MapFS{
+				"0010_later.sql":  {Data: []byte("SELECT 10")},
+				"0002_second.sql": {Data: []byte("SELECT 2")},
+				"0001_first.sql":  {Data: []byte("SELECT 1")},
+				"README.md":       {Data: []byte("not a migration")},
+			})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(migrations).Should(gomega.Equal([]migration{
+				{version: 1, name: "first", sql: "SELECT 1"},
+				{version: 2, name: "second", sql: "SELECT 2"},
+				{version: 10, name: "later", sql: "SELECT 10"},
+			}))
+		})
+
+		ginkgo.It("Should reject a file without a version", func() {
+			_, err := loadMigrations(fstest.MapFS{"initial.sql": {Data: []byte("SELECT 1")}})
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("Should reject two files with the same version", func() {
+			_, err := loadMigrations(fstest.MapFS{
+				"0001_first.sql": {Data: []byte("SELECT 1")},
+				"1_other.sql":    {Data: []byte("SELECT 1")},
+			})
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("Should embed the initial schema", func() {
+			migrations, err := loadMigrations(embeddedMigrations())
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(migrations).ShouldNot(gomega.BeEmpty())
+			gomega.Expect(migrations[0].version).Should(gomega.Equal(1))
+		})
+	})
+
+	ginkgo.Context("On an empty database", func() {
+		var emptyDB *sql.DB
+
+		ginkgo.BeforeEach(func() {
+			_, err := db.Exec("CREATE SCHEMA IF NOT EXISTS migrate_empty")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s search_path=migrate_empty",
+				os.Getenv("DB_HOST"),
+				os.Getenv("DB_USER"),
+				os.Getenv("DB_PASSWORD"),
+				os.Getenv("DB_NAME"),
+				getEnvAsInt("DB_PORT", 5432),
+				os.Getenv("DB_SSLMODE"),
+			)
+			emptyDB, err = sql.Open("postgres", dsn)
+			gomega.Expect(err).Should(gomega.BeNil())
+		})
+
+		ginkgo.AfterEach(func() {
+			emptyDB.Close()
+			db.Exec("DROP SCHEMA migrate_empty CASCADE")
+		})
+
+		ginkgo.It("Should create the schema the server expects", func() {
+			gomega.Expect(checkSchema(emptyDB)).Should(gomega.MatchError(errSchemaMissing))
+
+			applied, err := migrate(context.Background(), emptyDB, embeddedMigrations())
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(applied).Should(gomega.BeNumerically(">", 0))
+			gomega.Expect(checkSchema(emptyDB)).Should(gomega.Succeed())
+
+			var recorded int
+			gomega.Expect(emptyDB.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&recorded)).Should(gomega.Succeed())
+			gomega.Expect(recorded).Should(gomega.Equal(applied))
+		})
+
+		ginkgo.It("Should apply nothing the second time", func() {
+			_, err := migrate(context.Background(), emptyDB, embeddedMigrations())
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			applied, err := migrate(context.Background(), emptyDB, embeddedMigrations())
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(applied).Should(gomega.BeZero())
+		})
+
+		ginkgo.It("Should roll back a failing migration", func() {
+			broken := fstest.MapFS{
+				"0001_ok.sql":     {Data: []byte("CREATE TABLE widgets (id INTEGER)")},
+				"0002_broken.sql": {Data: []byte("CREATE TABLE gadgets (id INTEGER); SELECT * FROM missing_table")},
+			}
+			applied, err := migrate(context.Background(), emptyDB, broken)
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+			gomega.Expect(applied).Should(gomega.Equal(1))
+
+			var gadgets sql.NullString
+			gomega.Expect(emptyDB.QueryRow("SELECT to_regclass('gadgets')::text").Scan(&gadgets)).Should(gomega.Succeed())
+			gomega.Expect(gadgets.Valid).Should(gomega.BeFalse())
+		})
+	})
+})
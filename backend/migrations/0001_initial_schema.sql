@@ -0,0 +1,105 @@
+-- Initial schema. Migrations are embedded in the binary and applied in
+-- version order by the server's -migrate flag (see migrate.go). Every
+-- statement here is idempotent so databases set up by hand from the old
+-- schema.sql can be migrated too.
+--
+-- The server checks for the tables and columns at startup (see
+-- expectedSchema in schema.go), so keep it in sync with the migrations.
+
+CREATE TABLE IF NOT EXISTS users (
+    id                  SERIAL PRIMARY KEY,
+    tenant_id           INTEGER NOT NULL DEFAULT 0,
+    username            VARCHAR(255) NOT NULL,
+    email               VARCHAR(255) NOT NULL,
+    password            VARCHAR(255) NOT NULL,
+    profile_picture_url TEXT NOT NULL DEFAULT '' CHECK (char_length(profile_picture_url) <= 2048),
+    -- BlobStore key of an uploaded avatar, deleted with the user.
+    avatar_key          TEXT NOT NULL DEFAULT '',
+    bio                 TEXT NOT NULL DEFAULT '',
+    timezone            VARCHAR(64) NOT NULL DEFAULT '',
+    verification_token  VARCHAR(255),
+    email_verified      BOOLEAN NOT NULL DEFAULT FALSE,
+    pending_email       VARCHAR(255),
+    role                VARCHAR(32) NOT NULL DEFAULT 'user',
+    signup_source       VARCHAR(16) NOT NULL DEFAULT 'api',
+    tokens_revoked_at   TIMESTAMPTZ,
+    failed_logins       INTEGER NOT NULL DEFAULT 0,
+    locked_until        TIMESTAMPTZ,
+    last_login_at       TIMESTAMPTZ,
+    -- Set once personal data has been scrubbed; such users can't be restored.
+    anonymized_at       TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at          TIMESTAMPTZ
+);
+
+-- Usernames and emails are unique per tenant, not globally.
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_username_key ON users (tenant_id, username);
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_email_key ON users (tenant_id, email);
+-- Neither may differ from another user's only by case. Creating these fails
+-- on a database that already has such pairs; rename or merge them first.
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_lower_username_key ON users (tenant_id, LOWER(username));
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_lower_email_key ON users (tenant_id, LOWER(email));
+
+-- Logins and password resets only look at active users. These partial
+-- indexes skip soft-deleted rows; queries must repeat deleted_at IS NULL
+-- for the planner to use them.
+CREATE INDEX IF NOT EXISTS users_active_username_idx ON users (LOWER(username)) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS users_active_email_idx ON users (LOWER(email)) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS users_recent_login_idx ON users (last_login_at DESC) WHERE deleted_at IS NULL AND last_login_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id         BIGSERIAL PRIMARY KEY,
+    action     VARCHAR(64) NOT NULL,
+    user_id    INTEGER NOT NULL,
+    actor_id   INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_logs_created_at_idx ON audit_logs (created_at);
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS password_reset_tokens_user_id_idx ON password_reset_tokens (user_id);
+
+-- Tokens emailed to locked out users that lift the lock early.
+CREATE TABLE IF NOT EXISTS unlock_tokens (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Refresh tokens are rotated on every use. Tokens from one login share a
+-- family so reuse of a rotated token can revoke them all.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    family     CHAR(64) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family);
+CREATE INDEX IF NOT EXISTS refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+
+-- IDs (jti) of access tokens revoked by POST /logout, kept until the token
+-- would have expired.
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti        CHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
@@ -0,0 +1,9 @@
+-- Public user IDs for Config.App.IDType "uuid". The integer id stays the
+-- primary key that other tables reference; clients only see this column
+-- when UUID IDs are on. Existing rows each get their own UUID.
+--
+-- gen_random_uuid() is built in from PostgreSQL 13. Older servers need
+-- CREATE EXTENSION pgcrypto before this runs.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS uuid UUID NOT NULL DEFAULT gen_random_uuid();
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_uuid_key ON users (uuid);
@@ -1,7 +1,10 @@
 package models
 
+import "time"
+
 type User struct {
-	ID       uint   `json:"id" gorm:"primaryKey"`
-	Username string `json:"username" gorm:"unique;not null"`
-	Email    string `json:"email" gorm:"unique;not null"`
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	Username  string     `json:"username" gorm:"unique;not null"`
+	Email     string     `json:"email" gorm:"unique;not null"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
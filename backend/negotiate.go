@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// responseMediaTypes are the media types negotiated routes can answer with,
+// in order of preference when a client accepts several equally.
+var responseMediaTypes = []string{echo.MIMEApplicationJSON, echo.MIMEApplicationXML}
+
+// acceptedMediaType returns the entry of offered that the Accept header
+// accept ranks highest, or "" if it accepts none of them. A missing header
+// accepts anything. Ties go to the earlier entry of offered.
+func acceptedMediaType(accept string, offered []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offered[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, mediaType := range offered {
+		// The most specific matching range decides the quality, so
+		// "*/*;q=0.1, application/xml" prefers XML.
+		q, specificity := 0.0, -1
+		for _, part := range strings.Split(accept, ",") {
+			params := strings.Split(part, ";")
+			mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+			s := rangeSpecificity(mediaRange, mediaType)
+			if s <= specificity {
+				continue
+			}
+			specificity, q = s, 1
+			for _, param := range params[1:] {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(name, "q") {
+					if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+						q = parsed
+					}
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = mediaType, q
+		}
+	}
+	return best
+}
+
+// rangeSpecificity reports how specifically mediaRange matches mediaType:
+// 2 for an exact match, 1 for "type/*", 0 for "*/*" and -1 for no match.
+func rangeSpecificity(mediaRange string, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
+
+// negotiateMediaType picks the response media type of requests to the
+// routes in negotiated, keyed like "GET /users/:id", from their Accept
+// header. Requests that accept none of responseMediaTypes get 406. Error
+// responses stay JSON either way.
+func negotiateMediaType(negotiated map[string]bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !negotiated[c.Request().Method+" "+c.Path()] {
+				return next(c)
+			}
+			c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+			mediaType := acceptedMediaType(c.Request().Header.Get(echo.HeaderAccept), responseMediaTypes)
+			if mediaType == "" {
+				return newAPIError(http.StatusNotAcceptable, "not_acceptable", "Responses are only available as JSON or XML").
+					With("available", responseMediaTypes)
+			}
+			c.Set("media_type", mediaType)
+			return next(c)
+		}
+	}
+}
+
+// wantsXML reports whether negotiateMediaType chose XML for c.
+func wantsXML(c echo.Context) bool {
+	return c.Get("media_type") == echo.MIMEApplicationXML
+}
+
+// respond writes i with status code in the media type negotiateMediaType
+// chose, JSON unless the client asked for XML.
+func respond(c echo.Context, code int, i interface{}) error {
+	if wantsXML(c) {
+		return c.XML(code, i)
+	}
+	return c.JSON(code, i)
+}
+
+// xmlUserList wraps a list of users so it has a root element in XML.
+type xmlUserList struct {
+	XMLName xml.Name `xml:"users"`
+	Users   []User   `xml:"user"`
+}
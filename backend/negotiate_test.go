@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Content negotiation", func() {
+	ginkgo.Context("acceptedMediaType", func() {
+		ginkgo.It("Should pick the type the Accept header ranks highest", func() {
+			for accept, want := range map[string]string{
+				"":                                  echo.MIMEApplicationJSON,
+				"*/*":                               echo.MIMEApplicationJSON,
+				"application/json":                  echo.MIMEApplicationJSON,
+				"application/xml":                   echo.MIMEApplicationXML,
+				"Application/XML":                   echo.MIMEApplicationXML,
+				"application/*":                     echo.MIMEApplicationJSON,
+				"*/*;q=0.1, application/xml":        echo.MIMEApplicationXML,
+				"application/json;q=0.5, */*;q=0.9": echo.MIMEApplicationXML,
+				"application/xml;q=0, */*":          echo.MIMEApplicationJSON,
+				"text/html":                         "",
+				"application/json;q=0":              "",
+			} {
+				gomega.Expect(acceptedMediaType(accept, responseMediaTypes)).Should(gomega.Equal(want), accept)
+			}
+		})
+	})
+
+	ginkgo.Context("GET /users/:id", func() {
+		var testUser User
+
+		ginkgo.BeforeEach(func() {
+			testUser = User{Username: "xmluser", Email: "xmluser@example.com", Password: "password123", Bio: "Likes <angle> brackets"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+		})
+
+		get := func(accept string) *httptest.ResponseRecorder {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.Use(negotiateMediaType(negotiatedRoutes))
+			server.GET("/users/:id", getUserHandler(db))
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/users/%d", testUser.ID), nil)
+			if accept != "" {
+				req.Header.Set(echo.HeaderAccept, accept)
+			}
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec
+		}
+
+		ginkgo.It("Should send XML when asked for it", func() {
+			rec := get("application/xml")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(rec.Header().Get(echo.HeaderContentType)).Should(gomega.HavePrefix(echo.MIMEApplicationXML))
+			gomega.Expect(rec.Header().Values(echo.HeaderVary)).Should(gomega.ContainElement(echo.HeaderAccept))
+
+			var user User
+			gomega.Expect(xml.Unmarshal(rec.Body.Bytes(), &user)).Should(gomega.Succeed())
+			gomega.Expect(user.ID).Should(gomega.Equal(testUser.ID))
+			gomega.Expect(user.Username).Should(gomega.Equal("xmluser"))
+			gomega.Expect(user.Bio).Should(gomega.Equal("Likes <angle> brackets"))
+			gomega.Expect(rec.Body.String()).ShouldNot(gomega.ContainSubstring("password"))
+		})
+
+		ginkgo.It("Should default to JSON", func() {
+			rec := get("")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(rec.Header().Get(echo.HeaderContentType)).Should(gomega.HavePrefix(echo.MIMEApplicationJSON))
+
+			var user User
+			gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &user)).Should(gomega.Succeed())
+			gomega.Expect(user.ID).Should(gomega.Equal(testUser.ID))
+		})
+
+		ginkgo.It("Should refuse media types it can't produce", func() {
+			rec := get("text/html")
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusNotAcceptable))
+
+			var body map[string]interface{}
+			gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(gomega.Succeed())
+			gomega.Expect(body["error"]).Should(gomega.Equal("not_acceptable"))
+		})
+	})
+})
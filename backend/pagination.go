@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errConflictingPagination = newAPIError(http.StatusBadRequest, "conflicting_pagination", "page and after can't be used together")
+	errInvalidCursor         = newAPIError(http.StatusBadRequest, "invalid_cursor", "Invalid cursor")
+	errBareCursorSort        = newAPIError(http.StatusBadRequest, "invalid_cursor", "A plain ID in after only works with sort=id; use nextCursor for other sorts")
+)
+
+// listPagination is the page requested by a list call: either a page number
+// or a cursor to continue after.
+type listPagination struct {
+	Page     int
+	PageSize int
+	After    *userCursor
+}
+
+// parsePagination reads page, pageSize and after from a list request. limit
+// is accepted as another name for pageSize. after is either a nextCursor from
+// an earlier page or, for lists sorted by id, a plain user ID. When uuidIDs is
+// on only cursors are accepted, and they must carry a UUID. Offset and
+// cursor pagination can't be combined, and guessing which one the client
+// meant would hand back the wrong page, so sending both page and after is an
+// error.
+func parsePagination(params url.Values) (listPagination, error) {
+	pagination := listPagination{Page: 1, PageSize: 10}
+	if params.Has("page") && params.Has("after") {
+		return pagination, errConflictingPagination
+	}
+
+	if page, err := strconv.Atoi(params.Get("page")); err == nil && page >= 1 {
+		pagination.Page = page
+	}
+	if pageSize, err := strconv.Atoi(params.Get("pageSize")); err == nil && pageSize >= 1 {
+		pagination.PageSize = pageSize
+	}
+	if limit, err := strconv.Atoi(params.Get("limit")); err == nil && limit >= 1 {
+		pagination.PageSize = limit
+	}
+	if after := params.Get("after"); after != "" {
+		// Encoded cursors are base64 JSON and never all digits.
+		if id, err := strconv.Atoi(after); err == nil {
+			if uuidIDs {
+				return pagination, errInvalidCursor
+			}
+			pagination.After = &userCursor{Value: after, ID: id, bare: true}
+			return pagination, nil
+		}
+		cursor, err := decodeUserCursor(after)
+		if err != nil {
+			return pagination, errInvalidCursor
+		}
+		if uuidIDs && !uuidPattern.MatchString(cursor.UUID) {
+			return pagination, errInvalidCursor
+		}
+		pagination.After = &cursor
+	}
+	return pagination, nil
+}
+
+// userCursor marks a position in a sorted user list: the sort column value
+// and id of the last user on the previous page, or its UUID when uuidIDs is
+// on so the cursor doesn't give the integer ID away. It is handed to clients
+// as an opaque string, but it is only base64 and can be decoded.
+type userCursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id,omitempty"`
+	UUID  string `json:"u,omitempty"`
+	// bare is set for a plain ID passed as after, which only has a
+	// position in lists sorted by id.
+	bare bool
+}
+
+// encodeUserCursor returns the cursor that continues a list sorted by sort
+// after u.
+func encodeUserCursor(sort UserSort, u User) string {
+	cursor := userCursor{ID: u.ID}
+	if uuidIDs {
+		cursor = userCursor{UUID: u.UUID}
+	}
+	switch sort.Column {
+	case "id":
+		cursor.Value = strconv.Itoa(u.ID)
+	case "username":
+		cursor.Value = u.Username
+	case "email":
+		cursor.Value = u.Email
+	case "created_at":
+		cursor.Value = u.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		cursor.Value = u.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeUserCursor(s string) (userCursor, error) {
+	var cursor userCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(b, &cursor)
+	return cursor, err
+}
+
+// predicate returns the condition selecting rows that come after the cursor
+// in sort order. It compares (column, id), or (column, uuid) when uuidIDs is
+// on, so ties on the sort column are broken the same way as in
+// UserSort.orderBy.
+func (c userCursor) predicate(sort UserSort) squirrel.Sqlizer {
+	op := ">"
+	if sort.Descending {
+		op = "<"
+	}
+	if sort.Column == "id" {
+		return squirrel.Expr("id "+op+" ?", c.ID)
+	}
+	if uuidIDs {
+		return squirrel.Expr(fmt.Sprintf("(%s, uuid) %s (?, ?)", sort.Column, op), c.Value, c.UUID)
+	}
+	return squirrel.Expr(fmt.Sprintf("(%s, id) %s (?, ?)", sort.Column, op), c.Value, c.ID)
+}
+
+// linkBaseURL returns the scheme and host clients reach the API at, for
+// building absolute links. Config.App.FrontendBaseURL wins when set.
+// Otherwise X-Forwarded-Host and X-Forwarded-Proto are used, but only when
+// the request came straight from one of trustedProxies; anyone else could
+// point the links at a host of their choosing.
+func linkBaseURL(c echo.Context, frontendBaseURL string, trustedProxies []*net.IPNet) string {
+	if frontendBaseURL != "" {
+		return strings.TrimSuffix(frontendBaseURL, "/")
+	}
+
+	req := c.Request()
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	host := req.Host
+
+	peer, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		peer = req.RemoteAddr
+	}
+	if ipAllowed(trustedProxies, peer) {
+		if forwarded := firstHeaderValue(req.Header.Get("X-Forwarded-Host")); forwarded != "" {
+			host = forwarded
+		}
+		if forwarded := firstHeaderValue(req.Header.Get(echo.HeaderXForwardedProto)); forwarded != "" {
+			scheme = forwarded
+		}
+	}
+	return scheme + "://" + host
+}
+
+// firstHeaderValue returns the first entry of a comma separated header,
+// the one set by the proxy closest to the client.
+func firstHeaderValue(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
+
+// paginationLinks builds the Link header for a page of users requested at
+// path with query. Offset pages get first, prev, next and last links; cursor
+// pages only a next link, as long as there is a nextCursor.
+func paginationLinks(baseURL string, path string, query url.Values, userPage UserPage) string {
+	link := func(rel string, set url.Values) string {
+		q := url.Values{}
+		for key, values := range query {
+			q[key] = values
+		}
+		for key, values := range set {
+			q[key] = values
+		}
+		return fmt.Sprintf(`<%s%s?%s>; rel="%s"`, baseURL, path, q.Encode(), rel)
+	}
+
+	var links []string
+	if userPage.Page == 0 {
+		if userPage.NextCursor != "" {
+			links = append(links, link("next", url.Values{"after": {userPage.NextCursor}}))
+		}
+		return strings.Join(links, ", ")
+	}
+
+	pageValues := func(page int) url.Values {
+		return url.Values{"page": {strconv.Itoa(page)}}
+	}
+	links = append(links, link("first", pageValues(1)))
+	if userPage.Page > 1 {
+		links = append(links, link("prev", pageValues(userPage.Page-1)))
+	}
+	if userPage.Page < userPage.TotalPages {
+		links = append(links, link("next", pageValues(userPage.Page+1)))
+	}
+	if userPage.TotalPages > 0 {
+		links = append(links, link("last", pageValues(userPage.TotalPages)))
+	}
+	return strings.Join(links, ", ")
+}
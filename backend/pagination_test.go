@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Pagination", func() {
+	ginkgo.Context("parsePagination", func() {
+		ginkgo.It("Should reject page and after together", func() {
+			cursor := encodeUserCursor(defaultUserSort, User{ID: 1})
+			_, err := parsePagination(url.Values{"page": {"2"}, "after": {cursor}})
+			gomega.Expect(err).Should(gomega.Equal(errConflictingPagination))
+
+			_, err = parsePagination(url.Values{"page": {""}, "after": {cursor}})
+			gomega.Expect(err).Should(gomega.Equal(errConflictingPagination))
+		})
+
+		ginkgo.It("Should accept either on its own", func() {
+			pagination, err := parsePagination(url.Values{"page": {"2"}, "pageSize": {"5"}})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(pagination.Page).Should(gomega.Equal(2))
+			gomega.Expect(pagination.PageSize).Should(gomega.Equal(5))
+			gomega.Expect(pagination.After).Should(gomega.BeNil())
+
+			pagination, err = parsePagination(url.Values{"after": {encodeUserCursor(defaultUserSort, User{ID: 7})}})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(pagination.After.ID).Should(gomega.Equal(7))
+		})
+
+		ginkgo.It("Should take limit and a plain ID as after", func() {
+			pagination, err := parsePagination(url.Values{"after": {"42"}, "limit": {"10"}})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(pagination.PageSize).Should(gomega.Equal(10))
+			gomega.Expect(pagination.After.ID).Should(gomega.Equal(42))
+			gomega.Expect(pagination.After.bare).Should(gomega.BeTrue())
+		})
+
+		ginkgo.It("Should reject a malformed cursor", func() {
+			_, err := parsePagination(url.Values{"after": {"not a cursor"}})
+			gomega.Expect(err).Should(gomega.Equal(errInvalidCursor))
+		})
+	})
+
+	ginkgo.Context("getUsersAfter", func() {
+		ginkgo.It("Should continue where the previous page ended", func() {
+			for _, name := range []string{"alpha", "bravo", "charlie", "delta"} {
+				user := User{Username: name, Email: name + "@example.com", Password: "password123"}
+				gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			}
+			sort := UserSort{Column: "username"}
+
+			first, err := getUsers(context.Background(), db, 1, 2, UserFilter{}, sort)
+			gomega.Expect(err).Should(gomega.BeNil())
+			cursor, err := decodeUserCursor(encodeUserCursor(sort, first[len(first)-1]))
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			next, err := getUsersAfter(context.Background(), db, cursor, 2, UserFilter{}, sort)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(next).Should(gomega.HaveLen(2))
+			gomega.Expect(next[0].Username).Should(gomega.Equal("charlie"))
+			gomega.Expect(next[1].Username).Should(gomega.Equal("delta"))
+		})
+
+		ginkgo.It("Should neither skip nor repeat users created while paging", func() {
+			var existing []int
+			for i := 0; i < 6; i++ {
+				user := User{Username: fmt.Sprintf("pager%d", i), Email: fmt.Sprintf("pager%d@example.com", i), Password: "password123"}
+				gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+				existing = append(existing, user.ID)
+			}
+			sort := UserSort{Column: "id"}
+
+			seen := map[int]bool{}
+			cursor := userCursor{ID: 0, Value: "0"}
+			for page := 0; ; page++ {
+				users, err := getUsersAfter(context.Background(), db, cursor, 2, UserFilter{}, sort)
+				gomega.Expect(err).Should(gomega.BeNil())
+				if len(users) == 0 {
+					break
+				}
+				for _, u := range users {
+					gomega.Expect(seen[u.ID]).Should(gomega.BeFalse())
+					seen[u.ID] = true
+				}
+				last := users[len(users)-1].ID
+				cursor = userCursor{ID: last, Value: strconv.Itoa(last)}
+
+				// Another client signs up between page requests.
+				user := User{Username: fmt.Sprintf("latecomer%d", page), Email: fmt.Sprintf("latecomer%d@example.com", page), Password: "password123"}
+				gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+				if page > 20 {
+					break
+				}
+			}
+			for _, id := range existing {
+				gomega.Expect(seen).Should(gomega.HaveKey(id))
+			}
+		})
+	})
+
+	ginkgo.Context("with UUID IDs", func() {
+		ginkgo.BeforeEach(func() {
+			uuidIDs = true
+		})
+
+		ginkgo.AfterEach(func() {
+			uuidIDs = false
+		})
+
+		ginkgo.It("Should key cursors on the UUID, not the integer ID", func() {
+			user := User{ID: 42, UUID: "3f2b8c1e-9a4d-4e6b-8f0a-1c2d3e4f5a6b", Username: "cursoruser"}
+			encoded := encodeUserCursor(UserSort{Column: "username"}, user)
+
+			raw, err := base64.RawURLEncoding.DecodeString(encoded)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(string(raw)).ShouldNot(gomega.ContainSubstring(`"id"`))
+
+			pagination, err := parsePagination(url.Values{"after": {encoded}})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(pagination.After.UUID).Should(gomega.Equal(user.UUID))
+			gomega.Expect(pagination.After.ID).Should(gomega.BeZero())
+		})
+
+		ginkgo.It("Should reject plain IDs and integer cursors as after", func() {
+			_, err := parsePagination(url.Values{"after": {"42"}})
+			gomega.Expect(err).Should(gomega.Equal(errInvalidCursor))
+
+			uuidIDs = false
+			intCursor := encodeUserCursor(UserSort{Column: "username"}, User{ID: 42, Username: "cursoruser"})
+			uuidIDs = true
+			_, err = parsePagination(url.Values{"after": {intCursor}})
+			gomega.Expect(err).Should(gomega.Equal(errInvalidCursor))
+		})
+
+		ginkgo.It("Should continue where the previous page ended", func() {
+			for _, name := range []string{"uuidalpha", "uuidbravo", "uuidcharlie", "uuiddelta"} {
+				user := User{Username: name, Email: name + "@example.com", Password: "password123"}
+				gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			}
+			sort := UserSort{Column: "username"}
+			filter := UserFilter{Search: "uuid"}
+
+			first, err := getUsers(context.Background(), db, 1, 2, filter, sort)
+			gomega.Expect(err).Should(gomega.BeNil())
+			pagination, err := parsePagination(url.Values{"after": {encodeUserCursor(sort, first[len(first)-1])}})
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			next, err := getUsersAfter(context.Background(), db, *pagination.After, 2, filter, sort)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(next).Should(gomega.HaveLen(2))
+			gomega.Expect(next[0].Username).Should(gomega.Equal("uuidcharlie"))
+			gomega.Expect(next[1].Username).Should(gomega.Equal("uuiddelta"))
+		})
+	})
+
+	ginkgo.Context("Link header", func() {
+		var trusted []*net.IPNet
+
+		ginkgo.BeforeEach(func() {
+			var err error
+			trusted, err = parseIPAllowlist([]string{"10.0.0.0/8"})
+			gomega.Expect(err).Should(gomega.BeNil())
+		})
+
+		contextFrom := func(remoteAddr string) echo.Context {
+			req := httptest.NewRequest(http.MethodGet, "http://internal:8080/api/v1/users?page=2", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Set("X-Forwarded-Host", "api.example.com")
+			req.Header.Set("X-Forwarded-Proto", "https")
+			return echo.New().NewContext(req, httptest.NewRecorder())
+		}
+
+		ginkgo.It("Should use the forwarded host behind a trusted proxy", func() {
+			base := linkBaseURL(contextFrom("10.1.2.3:5000"), "", trusted)
+			gomega.Expect(base).Should(gomega.Equal("https://api.example.com"))
+
+			links := paginationLinks(base, "/api/v1/users", url.Values{"page": {"2"}, "pageSize": {"5"}}, UserPage{Page: 2, TotalPages: 3})
+			gomega.Expect(links).Should(gomega.Equal(`<https://api.example.com/api/v1/users?page=1&pageSize=5>; rel="first", ` +
+				`<https://api.example.com/api/v1/users?page=1&pageSize=5>; rel="prev", ` +
+				`<https://api.example.com/api/v1/users?page=3&pageSize=5>; rel="next", ` +
+				`<https://api.example.com/api/v1/users?page=3&pageSize=5>; rel="last"`))
+		})
+
+		ginkgo.It("Should ignore forwarded headers from anyone else", func() {
+			gomega.Expect(linkBaseURL(contextFrom("203.0.113.9:5000"), "", trusted)).Should(gomega.Equal("http://internal:8080"))
+		})
+
+		ginkgo.It("Should prefer the configured base URL", func() {
+			gomega.Expect(linkBaseURL(contextFrom("10.1.2.3:5000"), "https://www.example.com/", trusted)).Should(gomega.Equal("https://www.example.com"))
+		})
+
+		ginkgo.It("Should link cursor pages by nextCursor", func() {
+			links := paginationLinks("https://api.example.com", "/api/v1/users", url.Values{"after": {"5"}, "limit": {"2"}}, UserPage{NextCursor: "abc"})
+			gomega.Expect(links).Should(gomega.Equal(`<https://api.example.com/api/v1/users?after=abc&limit=2>; rel="next"`))
+
+			gomega.Expect(paginationLinks("https://api.example.com", "/api/v1/users", url.Values{}, UserPage{})).Should(gomega.BeEmpty())
+		})
+	})
+})
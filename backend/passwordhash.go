@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes new passwords and checks passwords against stored
+// hashes. Every hash starts with its scheme's prefix, "$2a$" for bcrypt and
+// "$argon2id$" for Argon2id, so stored hashes of either scheme can be
+// verified whichever hasher is configured.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash. A mismatch is not an
+	// error; a hash that can't be parsed is.
+	Verify(hash string, password string) (bool, error)
+	// NeedsRehash reports whether hash was made with another scheme or
+	// other parameters than Hash would use now.
+	NeedsRehash(hash string) bool
+}
+
+// passwordHasher hashes every new password. Set by main from
+// Config.App.PasswordHasher.
+var passwordHasher PasswordHasher = bcryptHasher{cost: bcrypt.DefaultCost}
+
+// newPasswordHasher returns the hasher named by Config.App.PasswordHasher.
+func newPasswordHasher(name string) (PasswordHasher, error) {
+	switch name {
+	case "", "bcrypt":
+		return bcryptHasher{cost: bcrypt.DefaultCost}, nil
+	case "argon2id":
+		return defaultArgon2idHasher, nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", name)
+	}
+}
+
+// verifyPassword checks password against hash using the scheme the hash was
+// made with.
+func verifyPassword(hash string, password string) (bool, error) {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		return argon2idHasher{}.Verify(hash, password)
+	}
+	return bcryptHasher{}.Verify(hash, password)
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+func (h bcryptHasher) Verify(hash string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h bcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost
+}
+
+const argon2idPrefix = "$argon2id$"
+
+var errMalformedArgon2idHash = errors.New("malformed argon2id hash")
+
+// argon2idHasher hashes with Argon2id, encoded as
+// $argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<key> in unpadded
+// base64, the format used by the reference implementation.
+type argon2idHasher struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	saltLen int
+	keyLen  uint32
+}
+
+// defaultArgon2idHasher uses the second recommended option of RFC 9106:
+// 64 MiB of memory and three passes.
+var defaultArgon2idHasher = argon2idHasher{memory: 64 * 1024, time: 3, threads: 4, saltLen: 16, keyLen: 32}
+
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, h.keyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h argon2idHasher) Verify(hash string, password string) (bool, error) {
+	params, salt, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return params.memory != h.memory || params.time != h.time || params.threads != h.threads ||
+		len(salt) != h.saltLen || uint32(len(key)) != h.keyLen
+}
+
+// parseArgon2idHash splits an encoded Argon2id hash into its parameters,
+// salt and key.
+func parseArgon2idHash(hash string) (argon2idHasher, []byte, []byte, error) {
+	var params argon2idHasher
+	parts := strings.Split(strings.TrimPrefix(hash, argon2idPrefix), "$")
+	if !strings.HasPrefix(hash, argon2idPrefix) || len(parts) != 4 {
+		return params, nil, nil, errMalformedArgon2idHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errMalformedArgon2idHash
+	}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, errMalformedArgon2idHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return params, nil, nil, errMalformedArgon2idHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errMalformedArgon2idHash
+	}
+	return params, salt, key, nil
+}
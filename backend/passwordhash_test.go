@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ = ginkgo.Describe("Password Hashing", func() {
+	// A cheap Argon2id setting keeps the tests fast.
+	testArgon2id := argon2idHasher{memory: 1024, time: 1, threads: 1, saltLen: 16, keyLen: 32}
+
+	for name, hasher := range map[string]PasswordHasher{
+		"bcrypt":   bcryptHasher{cost: bcrypt.MinCost},
+		"argon2id": testArgon2id,
+	} {
+		name, hasher := name, hasher
+		ginkgo.Context(name, func() {
+			ginkgo.It("Should verify the password it hashed and nothing else", func() {
+				hash, err := hasher.Hash("correct horse")
+				gomega.Expect(err).Should(gomega.BeNil())
+				gomega.Expect(hash).ShouldNot(gomega.ContainSubstring("correct horse"))
+
+				ok, err := hasher.Verify(hash, "correct horse")
+				gomega.Expect(err).Should(gomega.BeNil())
+				gomega.Expect(ok).Should(gomega.BeTrue())
+
+				ok, err = hasher.Verify(hash, "wrong horse")
+				gomega.Expect(err).Should(gomega.BeNil())
+				gomega.Expect(ok).Should(gomega.BeFalse())
+			})
+
+			ginkgo.It("Should salt each hash", func() {
+				first, err := hasher.Hash("correct horse")
+				gomega.Expect(err).Should(gomega.BeNil())
+				second, err := hasher.Hash("correct horse")
+				gomega.Expect(err).Should(gomega.BeNil())
+				gomega.Expect(first).ShouldNot(gomega.Equal(second))
+			})
+
+			ginkgo.It("Should be verified by verifyPassword", func() {
+				hash, err := hasher.Hash("correct horse")
+				gomega.Expect(err).Should(gomega.BeNil())
+				gomega.Expect(hasher.NeedsRehash(hash)).Should(gomega.BeFalse())
+
+				ok, err := verifyPassword(hash, "correct horse")
+				gomega.Expect(err).Should(gomega.BeNil())
+				gomega.Expect(ok).Should(gomega.BeTrue())
+			})
+		})
+	}
+
+	ginkgo.It("Should prefix Argon2id hashes with their scheme and parameters", func() {
+		hash, err := testArgon2id.Hash("correct horse")
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(hash).Should(gomega.HavePrefix("$argon2id$v=19$m=1024,t=1,p=1$"))
+	})
+
+	ginkgo.It("Should reject a malformed Argon2id hash", func() {
+		_, err := verifyPassword("$argon2id$v=19$m=1024,t=1$salt", "correct horse")
+		gomega.Expect(err).Should(gomega.Equal(errMalformedArgon2idHash))
+	})
+
+	ginkgo.It("Should reject unknown hasher names", func() {
+		_, err := newPasswordHasher("md5")
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+	})
+
+	ginkgo.Context("Rehash on login", func() {
+		var previous PasswordHasher
+
+		ginkgo.BeforeEach(func() {
+			previous = passwordHasher
+			passwordHasher = bcryptHasher{cost: bcrypt.MinCost}
+			user := User{Username: "rehashuser", Email: "rehashuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			passwordHasher = testArgon2id
+		})
+
+		ginkgo.AfterEach(func() {
+			passwordHasher = previous
+		})
+
+		storedHash := func() string {
+			var hash string
+			gomega.Expect(db.QueryRow("SELECT password FROM users WHERE username = 'rehashuser'").Scan(&hash)).Should(gomega.Succeed())
+			return hash
+		}
+
+		ginkgo.It("Should keep a bcrypt hash working after switching schemes", func() {
+			_, err := authenticateUser(db, cfg, testEmailSender, 0, "rehashuser", "password123")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(storedHash()).Should(gomega.HavePrefix("$2a$"))
+		})
+
+		ginkgo.It("Should re-hash with the configured scheme when enabled", func() {
+			rehashCfg := *cfg
+			rehashCfg.App.RehashPasswords = true
+
+			_, err := authenticateUser(db, &rehashCfg, testEmailSender, 0, "rehashuser", "password123")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(strings.HasPrefix(storedHash(), argon2idPrefix)).Should(gomega.BeTrue())
+
+			_, err = authenticateUser(db, &rehashCfg, testEmailSender, 0, "rehashuser", "password123")
+			gomega.Expect(err).Should(gomega.BeNil())
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Password policy rules, as reported under "failed_rules" so clients can
+// show a checklist.
+const (
+	passwordRuleMinLength = "min_length"
+	passwordRuleMixedCase = "mixed_case"
+	passwordRuleDigit     = "digit"
+	passwordRuleSymbol    = "symbol"
+	passwordRuleCommon    = "not_common"
+)
+
+// PasswordPolicy is the set of rules new passwords must follow, configured
+// under Config.App.PasswordPolicy. It applies on signup, password reset and
+// password change, on top of the payloads' own min=8.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters.
+	MinLength int `json:"min_length"`
+	// RequireMixedCase, RequireDigit and RequireSymbol each require at
+	// least one such character. Anything that is neither a letter, a digit
+	// nor a space counts as a symbol.
+	RequireMixedCase bool `json:"require_mixed_case"`
+	RequireDigit     bool `json:"require_digit"`
+	RequireSymbol    bool `json:"require_symbol"`
+	// Denylist lists common passwords that are refused, compared ignoring
+	// case.
+	Denylist []string `json:"denylist"`
+}
+
+// Check returns the rules password breaks, in a fixed order, or nil if it
+// satisfies the policy.
+func (p PasswordPolicy) Check(password string) []string {
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r) && !unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	var failed []string
+	if utf8.RuneCountInString(password) < p.MinLength {
+		failed = append(failed, passwordRuleMinLength)
+	}
+	if p.RequireMixedCase && !(hasUpper && hasLower) {
+		failed = append(failed, passwordRuleMixedCase)
+	}
+	if p.RequireDigit && !hasDigit {
+		failed = append(failed, passwordRuleDigit)
+	}
+	if p.RequireSymbol && !hasSymbol {
+		failed = append(failed, passwordRuleSymbol)
+	}
+	for _, common := range p.Denylist {
+		if strings.EqualFold(password, common) {
+			failed = append(failed, passwordRuleCommon)
+			break
+		}
+	}
+	return failed
+}
+
+// weakPasswordError renders the rules a password broke as a
+// validationErrorStatus response naming the payload field, e.g.
+// {"error": "weak_password", "field": "password", "failed_rules": ["digit"]}.
+func weakPasswordError(field string, failed []string) *APIError {
+	return newAPIError(validationErrorStatus, "weak_password", "Password does not meet the password policy").
+		With("field", field).
+		With("failed_rules", failed)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Password Policy", func() {
+	policy := PasswordPolicy{
+		MinLength:        10,
+		RequireMixedCase: true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		Denylist:         []string{"Password123!"},
+	}
+
+	ginkgo.It("Should accept a password that follows every rule", func() {
+		gomega.Expect(policy.Check("Correct-horse-9")).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("Should report a password that is too short", func() {
+		gomega.Expect(policy.Check("Ab1!")).Should(gomega.Equal([]string{passwordRuleMinLength}))
+	})
+
+	ginkgo.It("Should report a password without mixed case", func() {
+		gomega.Expect(policy.Check("correct-horse-9")).Should(gomega.Equal([]string{passwordRuleMixedCase}))
+		gomega.Expect(policy.Check("CORRECT-HORSE-9")).Should(gomega.Equal([]string{passwordRuleMixedCase}))
+	})
+
+	ginkgo.It("Should report a password without a digit", func() {
+		gomega.Expect(policy.Check("Correct-horse")).Should(gomega.Equal([]string{passwordRuleDigit}))
+	})
+
+	ginkgo.It("Should report a password without a symbol", func() {
+		gomega.Expect(policy.Check("Correcthorse9")).Should(gomega.Equal([]string{passwordRuleSymbol}))
+	})
+
+	ginkgo.It("Should report a denylisted password ignoring case", func() {
+		gomega.Expect(policy.Check("password123!")).Should(gomega.Equal([]string{passwordRuleMixedCase, passwordRuleCommon}))
+		gomega.Expect(policy.Check("PASSWORD123!")).Should(gomega.ContainElement(passwordRuleCommon))
+	})
+
+	ginkgo.It("Should report every failed rule together", func() {
+		gomega.Expect(policy.Check("abc")).Should(gomega.Equal([]string{passwordRuleMinLength, passwordRuleMixedCase, passwordRuleDigit, passwordRuleSymbol}))
+	})
+
+	ginkgo.It("Should list the failed rules when a signup is refused", func() {
+		policyCfg := *cfg
+		policyCfg.App.PasswordPolicy = policy
+
+		server := echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.Validator = e.Validator
+		server.POST("/users", createUserHandler(&policyCfg, db, testEmailSender, nil))
+
+		payload := `{"username":"weakuser","email":"weakuser@example.com","password":"password123"}`
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(payload))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		gomega.Expect(rec.Code).Should(gomega.Equal(validationErrorStatus))
+
+		var body map[string]interface{}
+		gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(gomega.Succeed())
+		gomega.Expect(body["error"]).Should(gomega.Equal("weak_password"))
+		gomega.Expect(body["field"]).Should(gomega.Equal("password"))
+		gomega.Expect(body["failed_rules"]).Should(gomega.ConsistOf(passwordRuleMixedCase, passwordRuleSymbol))
+	})
+})
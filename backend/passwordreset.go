@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+var errInvalidResetToken = errors.New("invalid_reset_token")
+
+type PasswordResetRequest struct {
+	TenantID int    `json:"tenant_id"`
+	Email    string `json:"email" validate:"required,email"`
+}
+
+type PasswordResetConfirmation struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8"`
+}
+
+// newResetToken returns a random token to hand to the user and the hash that
+// is stored in its place, so a leaked table doesn't leak usable tokens.
+func newResetToken() (token string, tokenHash string, err error) {
+	token, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createPasswordResetToken stores a new reset token for userID and returns
+// it. Only the newest Config.App.MaxResetTokens tokens per user are kept;
+// older ones are deleted so they can no longer be used.
+func createPasswordResetToken(db *sql.DB, cfg *Config, userID int) (string, error) {
+	token, tokenHash, err := newResetToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	expiresAt := time.Now().Add(cfg.App.ResetTokenTTL.Duration)
+	_, err = tx.Exec("INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)", userID, tokenHash, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(`DELETE FROM password_reset_tokens WHERE user_id = $1 AND id NOT IN (
+		SELECT id FROM password_reset_tokens WHERE user_id = $1 ORDER BY id DESC LIMIT $2
+	)`, userID, cfg.App.MaxResetTokens)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// lookupPasswordResetToken returns the user a token was issued to, or
+// errInvalidResetToken if the token is unknown, used, or expired.
+func lookupPasswordResetToken(db *sql.DB, token string) (int, error) {
+	var userID int
+	err := db.QueryRow("SELECT user_id FROM password_reset_tokens WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()", hashResetToken(token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, errInvalidResetToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// resetLookupQuery finds an active user by email, using the partial
+// users_active_email_idx index.
+const resetLookupQuery = "SELECT id FROM users WHERE tenant_id = $1 AND LOWER(email) = LOWER($2) AND deleted_at IS NULL"
+
+// requestPasswordReset emails a reset token to the active user with the given
+// email. Unknown emails are silently ignored so callers can't use the
+// endpoint to discover which accounts exist.
+func requestPasswordReset(db *sql.DB, cfg *Config, sender EmailSender, tenantID int, email string) error {
+	var userID int
+	err := db.QueryRow(resetLookupQuery, tenantID, email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := createPasswordResetToken(db, cfg, userID)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Use this token to reset your password: %s\n\nIt expires in %s.", token, cfg.App.ResetTokenTTL.Duration)
+	return sender.Send(email, "Reset your password", body)
+}
+
+// resetPassword sets a new password for the user a reset token was issued to
+// and consumes all of the user's reset tokens so none can be used again. Like
+// a password change, it revokes the user's refresh and access tokens so every
+// session has to log in again. A token issued to a user who has since been
+// deleted is refused with errInvalidResetToken and left unused.
+func resetPassword(db *sql.DB, token string, newPassword string) error {
+	hashedPassword, err := passwordHasher.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow("UPDATE password_reset_tokens SET used_at = NOW() WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW() RETURNING user_id", hashResetToken(token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return errInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("UPDATE users SET password = $1, tokens_revoked_at = NOW(), updated_at = NOW() WHERE id = $2 AND deleted_at IS NULL", hashedPassword, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errInvalidResetToken
+	}
+
+	if _, err := tx.Exec("UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	invalidateUser(userID)
+	return nil
+}
+
+// validateResetTokenHandler reports whether the token query parameter is a
+// reset token that can still be used, without consuming it, so the frontend
+// can check a link before showing the new-password form.
+func validateResetTokenHandler(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_, err := lookupPasswordResetToken(db, c.QueryParam("token"))
+		if err == errInvalidResetToken {
+			return newAPIError(http.StatusBadRequest, "invalid_reset_token", "Invalid or expired reset token")
+		}
+		if err != nil {
+			log.Errorf("request %s: looking up reset token: %v", requestID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_validate_reset_token", "Failed to validate reset token")
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"status": "valid"})
+	}
+}
+
+// pruneResetTokens deletes expired password reset tokens, used or not.
+func pruneResetTokens(db *sql.DB) (int64, error) {
+	result, err := db.Exec("DELETE FROM password_reset_tokens WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
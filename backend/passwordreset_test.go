@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Password Reset", func() {
+	var testUser User
+
+	ginkgo.BeforeEach(func() {
+		db.Exec("DELETE FROM password_reset_tokens")
+
+		testUser = User{Username: "resetuser", Email: "resetuser@example.com", Password: "password123"}
+		err := createUser(context.Background(), db, testEmailSender, &testUser)
+		gomega.Expect(err).Should(gomega.BeNil())
+	})
+
+	ginkgo.Context("createPasswordResetToken", func() {
+		ginkgo.It("Should keep only the most recent tokens valid", func() {
+			var tokens []string
+			for i := 0; i < cfg.App.MaxResetTokens+2; i++ {
+				token, err := createPasswordResetToken(db, cfg, testUser.ID)
+				gomega.Expect(err).Should(gomega.BeNil())
+				tokens = append(tokens, token)
+			}
+
+			oldest := tokens[:len(tokens)-cfg.App.MaxResetTokens]
+			newest := tokens[len(tokens)-cfg.App.MaxResetTokens:]
+
+			for _, token := range oldest {
+				_, err := lookupPasswordResetToken(db, token)
+				gomega.Expect(err).Should(gomega.Equal(errInvalidResetToken))
+			}
+			for _, token := range newest {
+				userID, err := lookupPasswordResetToken(db, token)
+				gomega.Expect(err).Should(gomega.BeNil())
+				gomega.Expect(userID).Should(gomega.Equal(testUser.ID))
+			}
+		})
+
+		ginkgo.It("Should not store the token in plain text", func() {
+			token, err := createPasswordResetToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			var count int
+			err = db.QueryRow("SELECT COUNT(*) FROM password_reset_tokens WHERE token_hash = $1", token).Scan(&count)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(count).Should(gomega.Equal(0))
+		})
+	})
+
+	ginkgo.Context("requestPasswordReset", func() {
+		ginkgo.It("Should email a token for a known address", func() {
+			sender := &fakeEmailSender{}
+			err := requestPasswordReset(db, cfg, sender, 0, "resetuser@example.com")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(sender.Sent()).Should(gomega.HaveLen(1))
+			gomega.Expect(sender.Sent()[0].To).Should(gomega.Equal("resetuser@example.com"))
+		})
+
+		ginkgo.It("Should succeed without sending anything for an unknown address", func() {
+			sender := &fakeEmailSender{}
+			err := requestPasswordReset(db, cfg, sender, 0, "nobody@example.com")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(sender.Sent()).Should(gomega.BeEmpty())
+		})
+	})
+
+	ginkgo.Context("resetPassword", func() {
+		ginkgo.It("Should change the password and consume the token", func() {
+			token, err := createPasswordResetToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			err = resetPassword(db, token, "newpassword123")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			_, err = authenticateUser(db, cfg, testEmailSender, 0, "resetuser", "newpassword123")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			err = resetPassword(db, token, "anotherpassword123")
+			gomega.Expect(err).Should(gomega.Equal(errInvalidResetToken))
+		})
+
+		ginkgo.It("Should reject an expired token", func() {
+			token, err := createPasswordResetToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			_, err = db.Exec("UPDATE password_reset_tokens SET expires_at = NOW() - INTERVAL '1 minute'")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			err = resetPassword(db, token, "newpassword123")
+			gomega.Expect(err).Should(gomega.Equal(errInvalidResetToken))
+		})
+
+		ginkgo.It("Should invalidate the user's other reset tokens", func() {
+			token, err := createPasswordResetToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			other, err := createPasswordResetToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(resetPassword(db, token, "newpassword123")).Should(gomega.Succeed())
+
+			_, err = lookupPasswordResetToken(db, other)
+			gomega.Expect(err).Should(gomega.Equal(errInvalidResetToken))
+		})
+
+		ginkgo.It("Should log out the user's existing sessions", func() {
+			accessToken, err := issueToken(cfg, testUser.ID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+			claims, err := parseToken(cfg, accessToken)
+			gomega.Expect(err).Should(gomega.BeNil())
+			refreshToken, err := createRefreshToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			token, err := createPasswordResetToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(resetPassword(db, token, "newpassword123")).Should(gomega.Succeed())
+
+			revoked, err := tokenRevoked(db, claims)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(revoked).Should(gomega.BeTrue())
+			_, _, err = rotateRefreshToken(db, cfg, refreshToken)
+			gomega.Expect(err).Should(gomega.Equal(errInvalidRefreshToken))
+		})
+
+		ginkgo.It("Should refuse a token of a deleted user without consuming it", func() {
+			token, err := createPasswordResetToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(deleteUser(context.Background(), db, testUser.ID)).Should(gomega.Succeed())
+
+			err = resetPassword(db, token, "newpassword123")
+			gomega.Expect(err).Should(gomega.Equal(errInvalidResetToken))
+
+			var used bool
+			gomega.Expect(db.QueryRow("SELECT used_at IS NOT NULL FROM password_reset_tokens WHERE token_hash = $1", hashResetToken(token)).Scan(&used)).Should(gomega.Succeed())
+			gomega.Expect(used).Should(gomega.BeFalse())
+		})
+	})
+
+	ginkgo.Context("validateResetTokenHandler", func() {
+		validate := func(token string) int {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.GET("/password-reset/validate", validateResetTokenHandler(db))
+
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/password-reset/validate?token="+url.QueryEscape(token), nil))
+			return rec.Code
+		}
+
+		ginkgo.It("Should accept a valid token without consuming it", func() {
+			token, err := createPasswordResetToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(validate(token)).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(validate(token)).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(resetPassword(db, token, "newpassword123")).Should(gomega.Succeed())
+		})
+
+		ginkgo.It("Should reject an expired token", func() {
+			token, err := createPasswordResetToken(db, cfg, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			_, err = db.Exec("UPDATE password_reset_tokens SET expires_at = NOW() - INTERVAL '1 minute'")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			gomega.Expect(validate(token)).Should(gomega.Equal(http.StatusBadRequest))
+		})
+
+		ginkgo.It("Should reject an unknown or missing token", func() {
+			gomega.Expect(validate("not-a-real-token")).Should(gomega.Equal(http.StatusBadRequest))
+			gomega.Expect(validate("")).Should(gomega.Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+var errEmptyPatch = errors.New("no_fields_to_update")
+
+// UserPatch holds the fields a PATCH request may change. Fields left out of
+// the request body stay nil and are not touched.
+type UserPatch struct {
+	Username          *string `json:"username" validate:"omitempty,min=3,max=30"`
+	Email             *string `json:"email" validate:"omitempty,email"`
+	ProfilePictureURL *string `json:"profile_picture_url" validate:"omitempty,profile_picture_url,custom_profile_picture"`
+	Bio               *string `json:"bio" validate:"omitempty,bio"`
+	Timezone          *string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+// decodeUserPatch decodes a PATCH body, rejecting keys that aren't patchable.
+func decodeUserPatch(r io.Reader) (UserPatch, error) {
+	var patch UserPatch
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&patch)
+	return patch, err
+}
+
+// patchUser updates only the columns present in patch and returns the
+// resulting user. unmodifiedSince works as for updateUser.
+func patchUser(db *sql.DB, id int, patch UserPatch, unmodifiedSince *time.Time) (User, error) {
+	var user User
+
+	if patch.Email != nil {
+		email := normalizeEmail(*patch.Email)
+		patch.Email = &email
+	}
+
+	// Only check uniqueness for the fields that are actually changing.
+	uniqueFields := squirrel.Or{}
+	if patch.Username != nil {
+		uniqueFields = append(uniqueFields, squirrel.Expr("LOWER(username) = LOWER(?)", *patch.Username))
+	}
+	if patch.Email != nil {
+		uniqueFields = append(uniqueFields, squirrel.Expr("LOWER(email) = ?", *patch.Email))
+	}
+	if len(uniqueFields) > 0 {
+		query, args, err := statementBuilder.Select("id", "username").
+			From("users").
+			Where(squirrel.NotEq{"id": id}).
+			Where("tenant_id = (SELECT tenant_id FROM users WHERE id = ?)", id).
+			Where(uniqueFields).
+			Limit(1).
+			ToSql()
+		if err != nil {
+			return user, err
+		}
+
+		var existingID int
+		var existingUsername string
+		err = db.QueryRow(query, args...).Scan(&existingID, &existingUsername)
+		if err != nil && err != sql.ErrNoRows {
+			return user, err
+		}
+		if existingID != 0 {
+			field := "email"
+			if patch.Username != nil && strings.EqualFold(*patch.Username, existingUsername) {
+				field = "username"
+			}
+			return user, &duplicateUserError{Field: field}
+		}
+	}
+
+	changes := map[string]interface{}{}
+	if patch.Username != nil {
+		changes["username"] = *patch.Username
+	}
+	if patch.Email != nil {
+		changes["email"] = *patch.Email
+	}
+	if patch.ProfilePictureURL != nil {
+		changes["profile_picture_url"] = *patch.ProfilePictureURL
+	}
+	if patch.Bio != nil {
+		changes["bio"] = *patch.Bio
+	}
+	if patch.Timezone != nil {
+		changes["timezone"] = *patch.Timezone
+	}
+	if len(changes) == 0 {
+		return user, errEmptyPatch
+	}
+	changes["updated_at"] = squirrel.Expr("NOW()")
+
+	where := squirrel.Eq{"id": id, "deleted_at": nil}
+	if unmodifiedSince != nil {
+		where["updated_at"] = *unmodifiedSince
+	}
+	query, args, err := statementBuilder.Update("users").
+		SetMap(changes).
+		Where(where).
+		Suffix("RETURNING id, uuid, tenant_id, username, email, profile_picture_url, bio, timezone, email_verified, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return user, err
+	}
+
+	err = db.QueryRow(query, args...).Scan(&user.ID, &user.UUID, &user.TenantID, &user.Username, &user.Email, &user.ProfilePictureURL, &user.Bio, &user.Timezone, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
+	if dup := duplicateUserFromDB(err); dup != nil {
+		return user, dup
+	}
+	if err == sql.ErrNoRows && unmodifiedSince != nil {
+		return user, errUserModified
+	}
+	if err != nil {
+		return user, err
+	}
+
+	invalidateUser(id)
+	return user, nil
+}
+
+// patchUserHandler serves PATCH /users/:id. A username or email that belongs
+// to another user is reported as 409 username_taken or email_taken with the
+// field, so the frontend can mark it.
+func patchUserHandler(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
+		}
+		patch, err := decodeUserPatch(c.Request().Body)
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload").With("details", err.Error())
+		}
+		normalizeUserPatch(&patch)
+		if err := c.Validate(patch); err != nil {
+			return validationError(patch, err)
+		}
+		unmodifiedSince, err := checkIfMatch(c, db, id)
+		if err != nil {
+			return err
+		}
+		user, err := patchUser(db, id, patch, unmodifiedSince)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+			}
+			if err == errUserModified {
+				return errPreconditionFailed
+			}
+			if err == errEmptyPatch {
+				return newAPIError(http.StatusBadRequest, "no_fields_to_update", "No fields to update")
+			}
+			if err.Error() == "username_or_email_exists" {
+				return fieldTakenError(err)
+			}
+			log.Errorf("request %s: updating user %d: %v", requestID(c), id, err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_update_user", "Failed to update user")
+		}
+		c.Response().Header().Set("ETag", userETag(user))
+		return respond(c, http.StatusOK, presentUser(c, user))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Patch User", func() {
+	var testUser User
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "patchuser", Email: "patchuser@example.com", Password: "password123", Bio: "Original bio", ProfilePictureURL: "https://example.com/a.png"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+	})
+
+	ginkgo.Context("decodeUserPatch", func() {
+		ginkgo.It("Should leave absent fields nil", func() {
+			patch, err := decodeUserPatch(strings.NewReader(`{"bio":"New bio"}`))
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(*patch.Bio).Should(gomega.Equal("New bio"))
+			gomega.Expect(patch.Username).Should(gomega.BeNil())
+			gomega.Expect(patch.Email).Should(gomega.BeNil())
+		})
+
+		ginkgo.It("Should reject unknown keys", func() {
+			_, err := decodeUserPatch(strings.NewReader(`{"bio":"New bio","password":"x"}`))
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+	})
+
+	ginkgo.Context("patchUser", func() {
+		ginkgo.It("Should only change the provided fields", func() {
+			bio := "New bio"
+			user, err := patchUser(db, testUser.ID, UserPatch{Bio: &bio}, nil)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(user.Bio).Should(gomega.Equal("New bio"))
+			gomega.Expect(user.Username).Should(gomega.Equal("patchuser"))
+			gomega.Expect(user.Email).Should(gomega.Equal("patchuser@example.com"))
+			gomega.Expect(user.ProfilePictureURL).Should(gomega.Equal("https://example.com/a.png"))
+		})
+
+		ginkgo.It("Should check uniqueness only for the fields being changed", func() {
+			otherUser := User{Username: "otheruser", Email: "otheruser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &otherUser)).Should(gomega.Succeed())
+
+			username := "otheruser"
+			_, err := patchUser(db, testUser.ID, UserPatch{Username: &username}, nil)
+			gomega.Expect(err).Should(gomega.MatchError("username_or_email_exists"))
+
+			bio := "Still fine"
+			_, err = patchUser(db, testUser.ID, UserPatch{Bio: &bio}, nil)
+			gomega.Expect(err).Should(gomega.BeNil())
+		})
+
+		ginkgo.It("Should reject an empty patch", func() {
+			_, err := patchUser(db, testUser.ID, UserPatch{}, nil)
+			gomega.Expect(err).Should(gomega.Equal(errEmptyPatch))
+		})
+	})
+	ginkgo.Context("PATCH conflicts", func() {
+		ginkgo.BeforeEach(func() {
+			otherUser := User{Username: "takenname", Email: "taken@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &otherUser)).Should(gomega.Succeed())
+		})
+
+		patch := func(body string) (int, map[string]interface{}) {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.Validator = e.Validator
+			server.PATCH("/users/:id", patchUserHandler(db))
+
+			req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%d", testUser.ID), strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+
+			var response map[string]interface{}
+			json.Unmarshal(rec.Body.Bytes(), &response)
+			return rec.Code, response
+		}
+
+		ginkgo.It("Should name the email field when only the email is taken", func() {
+			code, response := patch(`{"email":"Taken@example.com"}`)
+			gomega.Expect(code).Should(gomega.Equal(http.StatusConflict))
+			gomega.Expect(response["error"]).Should(gomega.Equal("email_taken"))
+			gomega.Expect(response["field"]).Should(gomega.Equal("email"))
+		})
+
+		ginkgo.It("Should name the username field when only the username is taken", func() {
+			code, response := patch(`{"username":"TakenName","email":"fresh@example.com"}`)
+			gomega.Expect(code).Should(gomega.Equal(http.StatusConflict))
+			gomega.Expect(response["error"]).Should(gomega.Equal("username_taken"))
+			gomega.Expect(response["field"]).Should(gomega.Equal("username"))
+		})
+	})
+})
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// defaultRolePermissions is used when the config doesn't set
+// role_permissions. The permissions are only reported to clients; access is
+// still enforced by RequireSelf and RequireRole.
+var defaultRolePermissions = map[string][]string{
+	roleUser:  {"users:read", "users:update:self", "users:delete:self"},
+	roleAdmin: {"users:read", "users:update:self", "users:delete:self", "users:delete", "users:restore", "users:purge", "users:logout", "stats:read", "email:test"},
+}
+
+// UserPermissions is the body of GET /users/me/permissions.
+type UserPermissions struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// permissionsFor returns the sorted permissions granted to role.
+func permissionsFor(cfg *Config, role string) []string {
+	permissions := append([]string{}, cfg.App.RolePermissions[role]...)
+	sort.Strings(permissions)
+	return permissions
+}
+
+// permissionsHandler reports the authenticated user's role and permissions.
+// It must run after RequireAuth.
+func permissionsHandler(cfg *Config, db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		role, err := getUserRole(db, authenticatedUserID(c))
+		if err == sql.ErrNoRows {
+			return newAPIError(http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+		}
+		if err != nil {
+			log.Errorf("request %s: reading role of user %d: %v", requestID(c), authenticatedUserID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_check_role", "Failed to check role")
+		}
+		return c.JSON(http.StatusOK, UserPermissions{Role: role, Permissions: permissionsFor(cfg, role)})
+	}
+}
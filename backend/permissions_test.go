@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Permissions", func() {
+	var admin, member User
+
+	ginkgo.BeforeEach(func() {
+		admin = User{Username: "permadmin", Email: "permadmin@example.com", Password: "password123", Role: roleAdmin}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &admin)).Should(gomega.Succeed())
+		member = User{Username: "permmember", Email: "permmember@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &member)).Should(gomega.Succeed())
+	})
+
+	get := func(testCfg *Config, userID int) (int, UserPermissions) {
+		server := echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.GET("/users/me/permissions", permissionsHandler(testCfg, db), RequireAuth(testCfg, db))
+
+		token, err := issueToken(testCfg, userID, roleUser)
+		gomega.Expect(err).Should(gomega.BeNil())
+		req := httptest.NewRequest(http.MethodGet, "/users/me/permissions", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		var body UserPermissions
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	ginkgo.It("Should give admins and regular users different permissions", func() {
+		testCfg := *cfg
+		testCfg.App.RolePermissions = defaultRolePermissions
+
+		code, adminPermissions := get(&testCfg, admin.ID)
+		gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+		gomega.Expect(adminPermissions.Role).Should(gomega.Equal(roleAdmin))
+		gomega.Expect(adminPermissions.Permissions).Should(gomega.ContainElement("users:purge"))
+
+		code, memberPermissions := get(&testCfg, member.ID)
+		gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+		gomega.Expect(memberPermissions.Role).Should(gomega.Equal(roleUser))
+		gomega.Expect(memberPermissions.Permissions).Should(gomega.ContainElement("users:read"))
+		gomega.Expect(memberPermissions.Permissions).ShouldNot(gomega.ContainElement("users:purge"))
+		gomega.Expect(memberPermissions.Permissions).ShouldNot(gomega.Equal(adminPermissions.Permissions))
+	})
+
+	ginkgo.It("Should use the configured role to permissions map", func() {
+		testCfg := *cfg
+		testCfg.App.RolePermissions = map[string][]string{roleUser: {"b", "a"}}
+
+		_, memberPermissions := get(&testCfg, member.ID)
+		gomega.Expect(memberPermissions.Permissions).Should(gomega.Equal([]string{"a", "b"}))
+
+		_, adminPermissions := get(&testCfg, admin.ID)
+		gomega.Expect(adminPermissions.Permissions).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("Should require authentication", func() {
+		server := echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.GET("/users/me/permissions", permissionsHandler(cfg, db), RequireAuth(cfg, db))
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/me/permissions", nil))
+		gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/labstack/gommon/log"
+)
+
+// purgeUser permanently removes a soft-deleted user, their tokens and their
+// uploaded avatar, for erasure requests. Active users are refused with
+// errUserNotDeleted so an account can't be destroyed without being deleted
+// first. It returns sql.ErrNoRows for unknown users.
+func purgeUser(db *sql.DB, store BlobStore, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var deletedAt sql.NullTime
+	var avatarKey string
+	err = tx.QueryRow("SELECT deleted_at, avatar_key FROM users WHERE id = $1 FOR UPDATE", id).Scan(&deletedAt, &avatarKey)
+	if err != nil {
+		return err
+	}
+	if !deletedAt.Valid {
+		return errUserNotDeleted
+	}
+
+	if _, err := tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = $1", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM refresh_tokens WHERE user_id = $1", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM unlock_tokens WHERE user_id = $1", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	invalidateUser(id)
+	// The user is gone either way; a blob left behind is only logged.
+	if avatarKey != "" {
+		if err := store.Delete(avatarKey); err != nil {
+			log.Warnf("Error deleting avatar %s of purged user %d: %v", avatarKey, id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Purge User", func() {
+	var testUser User
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "purgeuser", Email: "purgeuser@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+		_, err := createPasswordResetToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+	})
+
+	countRows := func(query string) int {
+		var count int
+		gomega.Expect(db.QueryRow(query, testUser.ID).Scan(&count)).Should(gomega.Succeed())
+		return count
+	}
+
+	ginkgo.It("Should remove a soft-deleted user and their tokens", func() {
+		gomega.Expect(deleteUser(context.Background(), db, testUser.ID)).Should(gomega.Succeed())
+
+		gomega.Expect(purgeUser(db, newMemoryBlobStore(), testUser.ID)).Should(gomega.Succeed())
+		gomega.Expect(countRows("SELECT COUNT(*) FROM users WHERE id = $1")).Should(gomega.Equal(0))
+		gomega.Expect(countRows("SELECT COUNT(*) FROM password_reset_tokens WHERE user_id = $1")).Should(gomega.Equal(0))
+	})
+
+	ginkgo.It("Should delete the user's uploaded avatar", func() {
+		store := newMemoryBlobStore()
+		url, err := store.Put("avatars/purge.png", bytes.NewReader(smallPNG()), "image/png")
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, err = store.Put("avatars/other.png", bytes.NewReader(smallPNG()), "image/png")
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, err = setAvatar(db, testUser.ID, url, "avatars/purge.png")
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(deleteUser(context.Background(), db, testUser.ID)).Should(gomega.Succeed())
+
+		gomega.Expect(purgeUser(db, store, testUser.ID)).Should(gomega.Succeed())
+		gomega.Expect(store.Keys()).Should(gomega.ConsistOf("avatars/other.png"))
+	})
+
+	ginkgo.It("Should refuse to purge an active user", func() {
+		gomega.Expect(purgeUser(db, newMemoryBlobStore(), testUser.ID)).Should(gomega.Equal(errUserNotDeleted))
+		gomega.Expect(countRows("SELECT COUNT(*) FROM users WHERE id = $1")).Should(gomega.Equal(1))
+		gomega.Expect(countRows("SELECT COUNT(*) FROM password_reset_tokens WHERE user_id = $1")).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("Should report an unknown user", func() {
+		gomega.Expect(purgeUser(db, newMemoryBlobStore(), 999999)).Should(gomega.Equal(sql.ErrNoRows))
+	})
+})
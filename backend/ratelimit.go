@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
+	"golang.org/x/time/rate"
+)
+
+// newRateLimiter builds the rate-limiting middleware on top of store. Requests
+// from admins and from IPs in Config.App.RateLimitExemptIPs skip the limit so
+// bulk jobs aren't throttled by the public limit. Admins are recognized by the
+// role claim of their token alone, so the limiter never queries the database.
+// Rejected requests get 429 rate_limited with retry_after_seconds and a
+// matching Retry-After header.
+func newRateLimiter(cfg *Config, store middleware.RateLimiterStore) (echo.MiddlewareFunc, error) {
+	exempt, err := parseIPAllowlist(cfg.App.RateLimitExemptIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Skipper: func(c echo.Context) bool {
+			// Scrapers poll /metrics on a schedule; counting those polls
+			// against the public limit would only produce gaps in the data.
+			if c.Request().URL.Path == "/metrics" {
+				return true
+			}
+			if ipAllowed(exempt, c.RealIP()) {
+				return true
+			}
+			return hasAdminClaim(cfg, c)
+		},
+		Store: store,
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			retryAfter := 1
+			if s, ok := store.(*rateLimitStore); ok {
+				retryAfter = s.retryAfterSeconds()
+			}
+			c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			return newAPIError(http.StatusTooManyRequests, "rate_limited", "Too many requests").With("retry_after_seconds", retryAfter)
+		},
+	}), nil
+}
+
+// rateLimitMiddleware builds the rate limiter on the store returned by open.
+// If the store can't be initialized the error is returned so the server
+// refuses to start, unless Config.App.RateLimitFailOpen is set, in which case
+// a warning is logged and every request is let through unlimited.
+func rateLimitMiddleware(cfg *Config, open func() (middleware.RateLimiterStore, error)) (echo.MiddlewareFunc, error) {
+	store, err := open()
+	if err != nil {
+		if !cfg.App.RateLimitFailOpen {
+			return nil, fmt.Errorf("initializing rate limit store: %w", err)
+		}
+		log.Warnf("Rate limiting is disabled: initializing rate limit store failed: %v", err)
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return next
+		}, nil
+	}
+	return newRateLimiter(cfg, store)
+}
+
+// rateLimitStore is an in-memory RateLimiterStore whose rate can be changed
+// while the server is running. Changing the rate starts from a fresh store,
+// so per-visitor state is reset.
+type rateLimitStore struct {
+	mu    sync.RWMutex
+	store *middleware.RateLimiterMemoryStore
+	limit int
+}
+
+// openRateLimitStore is newRateLimitStore for a limit read from the config.
+// A limit of zero or less would turn every request away, so it is refused.
+func openRateLimitStore(limit int) (*rateLimitStore, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive, got %d", limit)
+	}
+	return newRateLimitStore(limit), nil
+}
+
+func newRateLimitStore(limit int) *rateLimitStore {
+	s := &rateLimitStore{}
+	s.setRate(limit)
+	return s
+}
+
+func (s *rateLimitStore) setRate(limit int) {
+	store := middleware.NewRateLimiterMemoryStore(rate.Limit(limit))
+	s.mu.Lock()
+	s.store = store
+	s.limit = limit
+	s.mu.Unlock()
+}
+
+// retryAfterSeconds is how long a throttled visitor has to wait for the
+// next request to be allowed, rounded up to whole seconds.
+func (s *rateLimitStore) retryAfterSeconds() int {
+	s.mu.RLock()
+	limit := s.limit
+	s.mu.RUnlock()
+	if limit <= 0 {
+		return 1
+	}
+	return int(math.Ceil(1 / float64(limit)))
+}
+
+func (s *rateLimitStore) Allow(identifier string) (bool, error) {
+	s.mu.RLock()
+	store := s.store
+	s.mu.RUnlock()
+	return store.Allow(identifier)
+}
+
+// parseIPAllowlist parses a list of IPs and CIDR ranges. Bare IPs are treated
+// as single-address ranges.
+func parseIPAllowlist(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// newIPExtractor returns how the client IP is determined for c.RealIP(),
+// which the rate limiter and request logs use. X-Forwarded-For and X-Real-IP
+// are only believed when the connection comes from one of the trusted proxy
+// ranges; otherwise anyone could pick their own IP. Without trusted proxies
+// the peer address is used as is.
+func newIPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	networks, err := parseIPAllowlist(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(networks) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	// Echo trusts loopback, link-local and private ranges by default; only
+	// the configured proxies should be.
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, network := range networks {
+		options = append(options, echo.TrustIPRange(network))
+	}
+	fromXFF := echo.ExtractIPFromXFFHeader(options...)
+	fromRealIP := echo.ExtractIPFromRealIPHeader(options...)
+	return func(req *http.Request) string {
+		if req.Header.Get(echo.HeaderXForwardedFor) != "" {
+			return fromXFF(req)
+		}
+		return fromRealIP(req)
+	}, nil
+}
+
+func ipAllowed(networks []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
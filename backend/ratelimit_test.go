@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Rate Limiter", func() {
+	var limited *echo.Echo
+
+	ginkgo.BeforeEach(func() {
+		testCfg := *cfg
+		testCfg.App.RateLimit = 1
+		testCfg.App.RateLimitExemptIPs = []string{"10.0.0.0/24"}
+
+		rateLimiter, err := newRateLimiter(&testCfg, newRateLimitStore(testCfg.App.RateLimit))
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		limited = echo.New()
+		limited.HTTPErrorHandler = httpErrorHandler
+		limited.Use(rateLimiter)
+		limited.GET("/users", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+		limited.GET("/metrics", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+	})
+
+	sendTo := func(path string, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	send := func(remoteAddr string) int {
+		return sendTo("/users", remoteAddr)
+	}
+
+	ginkgo.It("Should let an allowlisted IP exceed the public limit", func() {
+		for i := 0; i < 5; i++ {
+			gomega.Expect(send("10.0.0.5:4321")).Should(gomega.Equal(http.StatusOK))
+		}
+	})
+
+	ginkgo.It("Should throttle an IP that is not allowlisted", func() {
+		codes := []int{}
+		for i := 0; i < 5; i++ {
+			codes = append(codes, send("192.168.1.5:4321"))
+		}
+		gomega.Expect(codes).Should(gomega.ContainElement(http.StatusTooManyRequests))
+	})
+
+	ginkgo.It("Should let an admin exceed the limit while a regular user is throttled", func() {
+		sendAs := func(role string, remoteAddr string) int {
+			token, err := issueToken(cfg, 1, role)
+			gomega.Expect(err).Should(gomega.BeNil())
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			limited.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		for i := 0; i < 5; i++ {
+			gomega.Expect(sendAs(roleAdmin, "192.168.1.9:4321")).Should(gomega.Equal(http.StatusOK))
+		}
+		codes := []int{}
+		for i := 0; i < 5; i++ {
+			codes = append(codes, sendAs(roleUser, "192.168.1.10:4321"))
+		}
+		gomega.Expect(codes).Should(gomega.ContainElement(http.StatusTooManyRequests))
+	})
+
+	ginkgo.It("Should describe the rejection in the error envelope", func() {
+		var rec *httptest.ResponseRecorder
+		for i := 0; i < 5; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.RemoteAddr = "192.168.1.6:4321"
+			rec = httptest.NewRecorder()
+			limited.ServeHTTP(rec, req)
+			if rec.Code == http.StatusTooManyRequests {
+				break
+			}
+		}
+		gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusTooManyRequests))
+		gomega.Expect(rec.Header().Get("Retry-After")).Should(gomega.Equal("1"))
+
+		var body map[string]interface{}
+		gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(gomega.Succeed())
+		gomega.Expect(body["error"]).Should(gomega.Equal("rate_limited"))
+		gomega.Expect(body["retry_after_seconds"]).Should(gomega.BeNumerically("==", 1))
+	})
+
+	ginkgo.It("Should not rate limit /metrics", func() {
+		for i := 0; i < 5; i++ {
+			gomega.Expect(sendTo("/metrics", "192.168.1.7:4321")).Should(gomega.Equal(http.StatusOK))
+		}
+	})
+
+	ginkgo.It("Should apply a new rate without rebuilding the middleware", func() {
+		store := newRateLimitStore(1)
+		for i := 0; i < 5; i++ {
+			store.Allow("192.168.1.6")
+		}
+		allowed, _ := store.Allow("192.168.1.6")
+		gomega.Expect(allowed).Should(gomega.BeFalse())
+
+		store.setRate(1000)
+		allowed, _ = store.Allow("192.168.1.6")
+		gomega.Expect(allowed).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should reject an invalid allowlist entry", func() {
+		testCfg := *cfg
+		testCfg.App.RateLimitExemptIPs = []string{"not-an-ip"}
+
+		_, err := newRateLimiter(&testCfg, newRateLimitStore(testCfg.App.RateLimit))
+		gomega.Expect(err).Should(gomega.HaveOccurred())
+	})
+
+	ginkgo.Context("Store initialization failure", func() {
+		failingStore := func() (middleware.RateLimiterStore, error) {
+			return nil, errors.New("store unavailable")
+		}
+
+		ginkgo.It("Should refuse to start when failing closed", func() {
+			testCfg := *cfg
+			testCfg.App.RateLimitFailOpen = false
+
+			rateLimiter, err := rateLimitMiddleware(&testCfg, failingStore)
+			gomega.Expect(err).Should(gomega.MatchError(gomega.ContainSubstring("store unavailable")))
+			gomega.Expect(rateLimiter).Should(gomega.BeNil())
+		})
+
+		ginkgo.It("Should serve requests unlimited when failing open", func() {
+			testCfg := *cfg
+			testCfg.App.RateLimitFailOpen = true
+
+			rateLimiter, err := rateLimitMiddleware(&testCfg, failingStore)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			limited = echo.New()
+			limited.HTTPErrorHandler = httpErrorHandler
+			limited.Use(rateLimiter)
+			limited.GET("/users", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+			for i := 0; i < 5; i++ {
+				gomega.Expect(send("192.168.1.8:4321")).Should(gomega.Equal(http.StatusOK))
+			}
+		})
+
+		ginkgo.It("Should refuse a rate limit that is not positive", func() {
+			_, err := openRateLimitStore(0)
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+	})
+	ginkgo.Context("Trusted proxies", func() {
+		forwardedFrom := func(trustedProxies []string, remoteAddr string, header string, value string) string {
+			extractor, err := newIPExtractor(trustedProxies)
+			gomega.Expect(err).Should(gomega.BeNil())
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Set(header, value)
+			return extractor(req)
+		}
+
+		ginkgo.It("Should ignore forged headers from an untrusted peer", func() {
+			gomega.Expect(forwardedFrom([]string{"10.1.0.0/16"}, "192.168.1.5:4321", echo.HeaderXForwardedFor, "10.0.0.5")).Should(gomega.Equal("192.168.1.5"))
+			gomega.Expect(forwardedFrom([]string{"10.1.0.0/16"}, "192.168.1.5:4321", echo.HeaderXRealIP, "10.0.0.5")).Should(gomega.Equal("192.168.1.5"))
+			gomega.Expect(forwardedFrom(nil, "127.0.0.1:4321", echo.HeaderXForwardedFor, "10.0.0.5")).Should(gomega.Equal("127.0.0.1"))
+		})
+
+		ginkgo.It("Should use the forwarded client IP from a trusted proxy", func() {
+			gomega.Expect(forwardedFrom([]string{"10.1.0.0/16"}, "10.1.2.3:4321", echo.HeaderXForwardedFor, "203.0.113.9")).Should(gomega.Equal("203.0.113.9"))
+			gomega.Expect(forwardedFrom([]string{"10.1.0.0/16"}, "10.1.2.3:4321", echo.HeaderXRealIP, "203.0.113.9")).Should(gomega.Equal("203.0.113.9"))
+		})
+
+		ginkgo.It("Should not let an untrusted peer claim an exempt IP", func() {
+			extractor, err := newIPExtractor([]string{"10.1.0.0/16"})
+			gomega.Expect(err).Should(gomega.BeNil())
+			limited.IPExtractor = extractor
+
+			codes := []int{}
+			for i := 0; i < 5; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/users", nil)
+				req.RemoteAddr = "192.168.1.8:4321"
+				req.Header.Set(echo.HeaderXForwardedFor, "10.0.0.5")
+				rec := httptest.NewRecorder()
+				limited.ServeHTTP(rec, req)
+				codes = append(codes, rec.Code)
+			}
+			gomega.Expect(codes).Should(gomega.ContainElement(http.StatusTooManyRequests))
+		})
+
+		ginkgo.It("Should reject an invalid proxy entry", func() {
+			_, err := newIPExtractor([]string{"not-an-ip"})
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+	})
+})
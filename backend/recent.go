@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// defaultRecentUsers is how many users GET /users/recent returns without a
+// limit.
+const defaultRecentUsers = 20
+
+// getRecentUsers returns up to limit active users who have logged in,
+// most recent login first.
+func getRecentUsers(ctx context.Context, db *sql.DB, limit int) ([]User, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `SELECT id, uuid, tenant_id, username, email, profile_picture_url, bio, timezone, email_verified, last_login_at, created_at, updated_at
+		FROM users
+		WHERE deleted_at IS NULL AND last_login_at IS NOT NULL
+		ORDER BY last_login_at DESC, id DESC
+		LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.UUID, &u.TenantID, &u.Username, &u.Email, &u.ProfilePictureURL, &u.Bio, &u.Timezone, &u.EmailVerified, &u.LastLoginAt, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// recentUsersHandler serves GET /users/recent. limit defaults to
+// defaultRecentUsers and is capped at Config.App.MaxRecentUsers.
+func recentUsersHandler(config *Config, db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		limit := defaultRecentUsers
+		if value := c.QueryParam("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return newAPIError(http.StatusBadRequest, "invalid_limit", "limit must be a positive integer")
+			}
+			limit = n
+		}
+		if limit > config.App.MaxRecentUsers {
+			limit = config.App.MaxRecentUsers
+		}
+
+		users, err := getRecentUsers(c.Request().Context(), db, limit)
+		if err != nil {
+			log.Errorf("request %s: listing recent users: %v", requestID(c), err)
+			return databaseError(err, "failed_to_retrieve_users", "Failed to retrieve users")
+		}
+		return respond(c, http.StatusOK, presentUsers(c, users))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Recent Users", func() {
+	var recentCfg Config
+
+	ginkgo.BeforeEach(func() {
+		for _, name := range []string{"early", "late", "middle", "idle", "gone"} {
+			user := User{Username: name, Email: name + "@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+		}
+		recentCfg = *cfg
+		recentCfg.App.MaxRecentUsers = 100
+	})
+
+	login := func(username string) int {
+		id, err := authenticateUser(db, &recentCfg, testEmailSender, 0, username, "password123")
+		gomega.Expect(err).Should(gomega.BeNil())
+		return id
+	}
+
+	recent := func(query string) (int, []User) {
+		server := echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.GET("/users/recent", recentUsersHandler(&recentCfg, db))
+
+		req := httptest.NewRequest(http.MethodGet, "/users/recent"+query, nil)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		var users []User
+		if rec.Code == http.StatusOK {
+			gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &users)).Should(gomega.Succeed())
+		}
+		return rec.Code, users
+	}
+
+	usernames := func(users []User) []string {
+		names := make([]string, len(users))
+		for i, u := range users {
+			names[i] = u.Username
+		}
+		return names
+	}
+
+	ginkgo.It("Should list users by most recent login", func() {
+		login("early")
+		login("middle")
+		goneID := login("gone")
+		login("late")
+		gomega.Expect(deleteUser(context.Background(), db, goneID)).Should(gomega.Succeed())
+
+		code, users := recent("")
+		gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+		gomega.Expect(usernames(users)).Should(gomega.Equal([]string{"late", "middle", "early"}))
+		gomega.Expect(users[0].LastLoginAt).ShouldNot(gomega.BeNil())
+
+		// Logging in again moves a user to the front.
+		login("early")
+		_, users = recent("?limit=2")
+		gomega.Expect(usernames(users)).Should(gomega.Equal([]string{"early", "late"}))
+	})
+
+	ginkgo.It("Should cap the limit", func() {
+		login("early")
+		login("middle")
+		login("late")
+		recentCfg.App.MaxRecentUsers = 2
+
+		code, users := recent("?limit=50")
+		gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+		gomega.Expect(users).Should(gomega.HaveLen(2))
+	})
+
+	ginkgo.It("Should reject a bad limit", func() {
+		code, _ := recent("?limit=0")
+		gomega.Expect(code).Should(gomega.Equal(http.StatusBadRequest))
+
+		code, _ = recent("?limit=many")
+		gomega.Expect(code).Should(gomega.Equal(http.StatusBadRequest))
+	})
+})
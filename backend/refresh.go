@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid_refresh_token")
+	// errRefreshTokenReused means an already rotated token was presented
+	// again. Only one party should hold a token at a time, so the whole
+	// chain is revoked.
+	errRefreshTokenReused = errors.New("refresh_token_reused")
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// issueRefreshToken stores a new refresh token for userID in family and
+// returns it. An empty family starts a new chain, as on login. Like reset
+// tokens, only the hash is stored.
+func issueRefreshToken(tx *sql.Tx, cfg *Config, userID int, family string) (string, error) {
+	if family == "" {
+		var err error
+		family, err = randomToken()
+		if err != nil {
+			return "", err
+		}
+	}
+	token, tokenHash, err := newResetToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(cfg.App.RefreshTokenTTL.Duration)
+	_, err = tx.Exec("INSERT INTO refresh_tokens (user_id, family, token_hash, expires_at) VALUES ($1, $2, $3, $4)", userID, family, tokenHash, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// createRefreshToken starts a new refresh token chain for userID.
+func createRefreshToken(db *sql.DB, cfg *Config, userID int) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	token, err := issueRefreshToken(tx, cfg, userID, "")
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// rotateRefreshToken exchanges token for a new one in the same chain and
+// returns the user it belongs to. Tokens that are unknown, expired, revoked,
+// issued before a forced logout, or whose user is deleted are refused with
+// errInvalidRefreshToken. Presenting a token that was already rotated
+// revokes every token in its chain and returns errRefreshTokenReused.
+func rotateRefreshToken(db *sql.DB, cfg *Config, token string) (int, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	var (
+		id, userID      int
+		family          string
+		expiresAt       time.Time
+		createdAt       time.Time
+		rotatedAt       sql.NullTime
+		revokedAt       sql.NullTime
+		userDeletedAt   sql.NullTime
+		tokensRevokedAt sql.NullTime
+	)
+	err = tx.QueryRow(`SELECT t.id, t.user_id, t.family, t.expires_at, t.created_at, t.rotated_at, t.revoked_at, u.deleted_at, u.tokens_revoked_at
+		FROM refresh_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1
+		FOR UPDATE OF t`, hashResetToken(token)).
+		Scan(&id, &userID, &family, &expiresAt, &createdAt, &rotatedAt, &revokedAt, &userDeletedAt, &tokensRevokedAt)
+	if err == sql.ErrNoRows {
+		return 0, "", errInvalidRefreshToken
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	if rotatedAt.Valid && !revokedAt.Valid {
+		if _, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE family = $1 AND revoked_at IS NULL", family); err != nil {
+			return 0, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, "", err
+		}
+		return 0, "", errRefreshTokenReused
+	}
+	if revokedAt.Valid || rotatedAt.Valid || !expiresAt.After(time.Now()) || userDeletedAt.Valid ||
+		(tokensRevokedAt.Valid && !createdAt.After(tokensRevokedAt.Time)) {
+		return 0, "", errInvalidRefreshToken
+	}
+
+	if _, err := tx.Exec("UPDATE refresh_tokens SET rotated_at = NOW() WHERE id = $1", id); err != nil {
+		return 0, "", err
+	}
+	newToken, err := issueRefreshToken(tx, cfg, userID, family)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return userID, newToken, nil
+}
+
+// revokeRefreshTokenFamily revokes the chain token belongs to, if it was
+// issued to userID. Unknown tokens are ignored.
+func revokeRefreshTokenFamily(db *sql.DB, userID int, token string) error {
+	_, err := db.Exec(`UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family = (SELECT family FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2)
+		AND revoked_at IS NULL`, hashResetToken(token), userID)
+	return err
+}
+
+// refreshTokenHandler serves POST /token/refresh. It rotates the refresh
+// token in the body and returns a new access token alongside its
+// replacement.
+func refreshTokenHandler(cfg *Config, db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req RefreshRequest
+		if err := c.Bind(&req); err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_request_payload", "Invalid request payload")
+		}
+		if err := c.Validate(req); err != nil {
+			return validationError(req, err)
+		}
+
+		userID, refreshToken, err := rotateRefreshToken(db, cfg, req.RefreshToken)
+		switch {
+		case err == errRefreshTokenReused:
+			log.Warnf("request %s: refresh token reused, revoked its chain", requestID(c))
+			return newAPIError(http.StatusUnauthorized, "refresh_token_reused", "Refresh token was already used; log in again")
+		case err == errInvalidRefreshToken:
+			return newAPIError(http.StatusUnauthorized, "invalid_refresh_token", "Invalid or expired refresh token")
+		case err != nil:
+			log.Errorf("request %s: rotating refresh token: %v", requestID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_refresh_token", "Failed to refresh token")
+		}
+
+		role, err := getUserRole(db, userID)
+		if err != nil {
+			log.Errorf("request %s: reading role: %v", requestID(c), err)
+			return newAPIError(http.StatusInternalServerError, "failed_to_refresh_token", "Failed to refresh token")
+		}
+		accessToken, err := issueToken(cfg, userID, role)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, "failed_to_refresh_token", "Failed to refresh token")
+		}
+		return c.JSON(http.StatusOK, TokenResponse{
+			AccessToken:  accessToken,
+			TokenType:    "Bearer",
+			ExpiresIn:    int(cfg.App.TokenTTL.Seconds()),
+			RefreshToken: refreshToken,
+		})
+	}
+}
+
+// pruneRefreshTokens deletes expired refresh tokens. Once expired a token is
+// refused anyway, so its row is no longer needed for reuse detection.
+func pruneRefreshTokens(db *sql.DB) (int64, error) {
+	result, err := db.Exec("DELETE FROM refresh_tokens WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
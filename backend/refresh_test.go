@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Refresh Tokens", func() {
+	var testUser User
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "refreshuser", Email: "refreshuser@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+	})
+
+	refresh := func(token string) (int, map[string]interface{}) {
+		server := echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.Validator = e.Validator
+		server.POST("/token/refresh", refreshTokenHandler(cfg, db))
+
+		req := httptest.NewRequest(http.MethodPost, "/token/refresh", strings.NewReader(`{"refresh_token":"`+token+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		var body map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	ginkgo.It("Should rotate the token and return a fresh access token", func() {
+		first, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		code, body := refresh(first)
+		gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+		claims, err := parseToken(cfg, body["access_token"].(string))
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(claims.UserID).Should(gomega.Equal(testUser.ID))
+
+		second := body["refresh_token"].(string)
+		gomega.Expect(second).ShouldNot(gomega.Equal(first))
+		code, _ = refresh(second)
+		gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+	})
+
+	ginkgo.It("Should revoke the whole chain when a rotated token is reused", func() {
+		first, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, second, err := rotateRefreshToken(db, cfg, first)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		code, body := refresh(first)
+		gomega.Expect(code).Should(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(body["error"]).Should(gomega.Equal("refresh_token_reused"))
+
+		// The legitimate holder's newer token is gone too.
+		_, _, err = rotateRefreshToken(db, cfg, second)
+		gomega.Expect(err).Should(gomega.Equal(errInvalidRefreshToken))
+	})
+
+	ginkgo.It("Should leave other logins' chains alone on reuse", func() {
+		first, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		other, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, _, err = rotateRefreshToken(db, cfg, first)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		_, _, err = rotateRefreshToken(db, cfg, first)
+		gomega.Expect(err).Should(gomega.Equal(errRefreshTokenReused))
+		_, _, err = rotateRefreshToken(db, cfg, other)
+		gomega.Expect(err).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("Should refuse unknown, expired and force-logged-out tokens", func() {
+		code, body := refresh("not-a-token")
+		gomega.Expect(code).Should(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(body["error"]).Should(gomega.Equal("invalid_refresh_token"))
+
+		expired, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, err = db.Exec("UPDATE refresh_tokens SET expires_at = NOW() - INTERVAL '1 minute' WHERE token_hash = $1", hashResetToken(expired))
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, _, err = rotateRefreshToken(db, cfg, expired)
+		gomega.Expect(err).Should(gomega.Equal(errInvalidRefreshToken))
+
+		loggedOut, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, err = db.Exec("UPDATE users SET tokens_revoked_at = NOW() + INTERVAL '1 second' WHERE id = $1", testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, _, err = rotateRefreshToken(db, cfg, loggedOut)
+		gomega.Expect(err).Should(gomega.Equal(errInvalidRefreshToken))
+	})
+
+	ginkgo.It("Should prune only expired tokens", func() {
+		live, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		expired, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		_, err = db.Exec("UPDATE refresh_tokens SET expires_at = NOW() - INTERVAL '1 minute' WHERE token_hash = $1", hashResetToken(expired))
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		removed, err := pruneRefreshTokens(db)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(removed).Should(gomega.BeNumerically(">=", 1))
+
+		var remaining int
+		gomega.Expect(db.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE token_hash = $1", hashResetToken(live)).Scan(&remaining)).Should(gomega.Succeed())
+		gomega.Expect(remaining).Should(gomega.Equal(1))
+	})
+})
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// runtimeSettings holds the settings that can be changed without a restart:
+// log level, rate limit, CORS origins and feature flags. Everything else in
+// Config, such as the database connection, is only read at startup.
+type runtimeSettings struct {
+	logger echo.Logger
+	// rateLimit is set by main once the rate limiter is up, and stays nil
+	// if it failed open.
+	rateLimit *rateLimitStore
+
+	mu          sync.RWMutex
+	corsOrigins []string
+	features    map[string]bool
+}
+
+func newRuntimeSettings(cfg *Config, logger echo.Logger) *runtimeSettings {
+	s := &runtimeSettings{logger: logger}
+	s.apply(cfg)
+	return s
+}
+
+// apply copies the reloadable settings from cfg.
+func (s *runtimeSettings) apply(cfg *Config) {
+	s.logger.SetLevel(parseLogLevel(cfg.App.LogLevel))
+	if s.rateLimit != nil {
+		if cfg.App.RateLimit > 0 {
+			s.rateLimit.setRate(cfg.App.RateLimit)
+		} else {
+			log.Errorf("Ignoring rate limit %d, keeping the current one", cfg.App.RateLimit)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.corsOrigins = cfg.App.CORSOrigins
+	s.features = cfg.App.Features
+}
+
+// allowOrigin is used as the CORS AllowOriginFunc so origin changes take
+// effect on the next request.
+func (s *runtimeSettings) allowOrigin(origin string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, allowed := range s.corsOrigins {
+		if allowed == "*" || allowed == origin {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *runtimeSettings) featureEnabled(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.features[name]
+}
+
+// watchConfigReload re-reads filename on every SIGHUP and applies the
+// reloadable settings. A config that fails to load is logged and the current
+// settings are kept.
+func watchConfigReload(ctx context.Context, filename string, settings *runtimeSettings) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			config, err := readConfig(filename)
+			if err != nil {
+				log.Errorf("Config reload failed, keeping current settings: %v", err)
+				continue
+			}
+			settings.apply(config)
+			log.Infof("Config reloaded")
+		}
+	}
+}
+
+func parseLogLevel(level string) log.Lvl {
+	switch level {
+	case "DEBUG":
+		return log.DEBUG
+	case "INFO":
+		return log.INFO
+	case "WARN":
+		return log.WARN
+	case "ERROR":
+		return log.ERROR
+	default:
+		return log.INFO
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Config Reload", func() {
+	var (
+		dir        string
+		configFile string
+		settings   *runtimeSettings
+		logger     echo.Logger
+	)
+
+	writeConfig := func(contents string) {
+		gomega.Expect(os.WriteFile(configFile, []byte(contents), 0o600)).Should(gomega.Succeed())
+	}
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "reload")
+		gomega.Expect(err).Should(gomega.BeNil())
+		configFile = filepath.Join(dir, "config.json")
+		writeConfig(`{"app": {"log_level": "ERROR", "rate_limit": 10, "cors_origins": ["http://localhost:4200"]}}`)
+
+		initial, err := readConfig(configFile)
+		gomega.Expect(err).Should(gomega.BeNil())
+		logger = echo.New().Logger
+		settings = newRuntimeSettings(initial, logger)
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	ginkgo.It("Should apply a new log level on SIGHUP", func() {
+		// Keep SIGHUP from terminating the test binary if it arrives before
+		// the watcher has registered.
+		guard := make(chan os.Signal, 1)
+		signal.Notify(guard, syscall.SIGHUP)
+		defer signal.Stop(guard)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go watchConfigReload(ctx, configFile, settings)
+
+		gomega.Expect(logger.Level()).Should(gomega.Equal(log.ERROR))
+		writeConfig(`{"app": {"log_level": "DEBUG", "rate_limit": 10, "cors_origins": ["https://example.com"], "features": {"beta": true}}}`)
+
+		gomega.Eventually(func() log.Lvl {
+			syscall.Kill(os.Getpid(), syscall.SIGHUP)
+			return logger.Level()
+		}).Should(gomega.Equal(log.DEBUG))
+
+		allowed, _ := settings.allowOrigin("https://example.com")
+		gomega.Expect(allowed).Should(gomega.BeTrue())
+		allowed, _ = settings.allowOrigin("http://localhost:4200")
+		gomega.Expect(allowed).Should(gomega.BeFalse())
+		gomega.Expect(settings.featureEnabled("beta")).Should(gomega.BeTrue())
+	})
+})
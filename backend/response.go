@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MarshalJSON sends the user's public ID as "id": its UUID when uuidIDs is
+// on, its integer ID otherwise.
+func (u User) MarshalJSON() ([]byte, error) {
+	type plainUser User
+
+	return json.Marshal(struct {
+		ID interface{} `json:"id"`
+		plainUser
+	}{
+		ID:        publicUserID(u.ID, u.UUID),
+		plainUser: plainUser(u),
+	})
+}
+
+// UnmarshalJSON reads back what MarshalJSON writes: a numeric "id" into ID
+// and a string one into UUID.
+func (u *User) UnmarshalJSON(data []byte) error {
+	type plainUser User
+
+	aux := struct {
+		ID json.RawMessage `json:"id"`
+		*plainUser
+	}{
+		plainUser: (*plainUser)(u),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if len(aux.ID) == 0 || string(aux.ID) == "null" {
+		return nil
+	}
+	if aux.ID[0] == '"' {
+		return json.Unmarshal(aux.ID, &u.UUID)
+	}
+	return json.Unmarshal(aux.ID, &u.ID)
+}
+
+// MarshalXML is MarshalJSON for XML responses. The element is always named
+// user.
+func (u User) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type plainUser User
+
+	start.Name = xml.Name{Local: "user"}
+	return e.EncodeElement(struct {
+		ID interface{} `xml:"id"`
+		plainUser
+	}{
+		ID:        publicUserID(u.ID, u.UUID),
+		plainUser: plainUser(u),
+	}, start)
+}
+
+// unixTimeUser is a User whose timestamps marshal as epoch milliseconds
+// instead of RFC3339 strings.
+type unixTimeUser User
+
+func (u unixTimeUser) MarshalJSON() ([]byte, error) {
+	type plainUser User
+
+	var deletedAt *int64
+	if u.DeletedAt != nil {
+		millis := u.DeletedAt.UnixMilli()
+		deletedAt = &millis
+	}
+
+	return json.Marshal(struct {
+		ID interface{} `json:"id"`
+		plainUser
+		Bio               *string `json:"bio"`
+		ProfilePictureURL *string `json:"profile_picture_url"`
+		CreatedAt         int64   `json:"created_at"`
+		UpdatedAt         int64   `json:"updated_at"`
+		DeletedAt         *int64  `json:"deleted_at,omitempty"`
+	}{
+		ID:                publicUserID(u.ID, u.UUID),
+		plainUser:         plainUser(u),
+		Bio:               optionalField(u.Bio),
+		ProfilePictureURL: optionalField(u.ProfilePictureURL),
+		CreatedAt:         u.CreatedAt.UnixMilli(),
+		UpdatedAt:         u.UpdatedAt.UnixMilli(),
+		DeletedAt:         deletedAt,
+	})
+}
+
+// nullOptionalFields is Config.App.NullOptionalFields, set by main. When it
+// is on, unset optional fields (bio, profile_picture_url, pending_email) are
+// sent as null; otherwise they are sent as "".
+var nullOptionalFields bool
+
+// optionalField returns the value to send for an optional string field.
+func optionalField(value string) *string {
+	if value == "" && nullOptionalFields {
+		return nil
+	}
+	return &value
+}
+
+// nullOptionalUser is a User whose unset optional fields marshal as null.
+// presentUser only uses it when nullOptionalFields is on.
+type nullOptionalUser User
+
+func (u nullOptionalUser) MarshalJSON() ([]byte, error) {
+	type plainUser User
+
+	return json.Marshal(struct {
+		ID interface{} `json:"id"`
+		plainUser
+		Bio               *string `json:"bio"`
+		ProfilePictureURL *string `json:"profile_picture_url"`
+	}{
+		ID:                publicUserID(u.ID, u.UUID),
+		plainUser:         plainUser(u),
+		Bio:               optionalField(u.Bio),
+		ProfilePictureURL: optionalField(u.ProfilePictureURL),
+	})
+}
+
+// presentUser returns the value to serialize for u. Clients can pass
+// ?timeFormat=unix to receive timestamps as epoch milliseconds; RFC3339 is
+// the default. XML responses always use RFC3339.
+func presentUser(c echo.Context, u User) interface{} {
+	u.ProfileCompleteness = profileCompleteness(u)
+	u = withDefaultProfilePicture(localizeUser(u))
+	if wantsXML(c) {
+		return u
+	}
+	if c.QueryParam("timeFormat") == "unix" {
+		return unixTimeUser(u)
+	}
+	if nullOptionalFields {
+		return nullOptionalUser(u)
+	}
+	return u
+}
+
+// presentUsers is presentUser for a list of users.
+func presentUsers(c echo.Context, users []User) interface{} {
+	localized := make([]User, len(users))
+	for i, u := range users {
+		u.ProfileCompleteness = profileCompleteness(u)
+		localized[i] = withDefaultProfilePicture(localizeUser(u))
+	}
+	if wantsXML(c) {
+		return xmlUserList{Users: localized}
+	}
+	if c.QueryParam("timeFormat") != "unix" {
+		if nullOptionalFields {
+			presented := make([]nullOptionalUser, len(localized))
+			for i, u := range localized {
+				presented[i] = nullOptionalUser(u)
+			}
+			return presented
+		}
+		return localized
+	}
+	presented := make([]unixTimeUser, len(localized))
+	for i, u := range localized {
+		presented[i] = unixTimeUser(u)
+	}
+	return presented
+}
+
+// UserPage is the envelope returned by GET /users. Clients that still expect a
+// bare array can pass ?envelope=false. Page is omitted for cursor requests;
+// NextCursor is set whenever there may be more results.
+type UserPage struct {
+	XMLName    xml.Name    `json:"-" xml:"userPage"`
+	Data       interface{} `json:"data" xml:"users"`
+	Page       int         `json:"page,omitempty" xml:"page,omitempty"`
+	PageSize   int         `json:"pageSize" xml:"pageSize"`
+	Total      int         `json:"total" xml:"total"`
+	TotalPages int         `json:"totalPages" xml:"totalPages"`
+	NextCursor string      `json:"nextCursor,omitempty" xml:"nextCursor,omitempty"`
+}
+
+func newUserPage(data interface{}, page int, pageSize int, total int) UserPage {
+	return UserPage{
+		Data:       data,
+		Page:       page,
+		PageSize:   pageSize,
+		Total:      total,
+		TotalPages: (total + pageSize - 1) / pageSize,
+	}
+}
+
+// defaultProfilePictureURL is Config.App.DefaultProfilePictureURL, set by
+// main.
+var defaultProfilePictureURL string
+
+// withDefaultProfilePicture fills in the default profile picture for users
+// without one. The stored value stays empty.
+func withDefaultProfilePicture(u User) User {
+	if u.ProfilePictureURL == "" {
+		u.ProfilePictureURL = defaultProfilePictureURL
+	}
+	return u
+}
+
+// defaultCompletenessWeights counts each optional profile part equally.
+var defaultCompletenessWeights = map[string]int{"bio": 1, "avatar": 1, "verified_email": 1}
+
+// completenessWeights is Config.App.ProfileCompletenessWeights, set by main.
+var completenessWeights = defaultCompletenessWeights
+
+// profileCompleteness returns the weighted share, 0 to 100, of the optional
+// profile parts u has filled in, so the frontend can nudge users to finish
+// their profile. The default picture doesn't count as an avatar.
+func profileCompleteness(u User) int {
+	filled := map[string]bool{
+		"bio":            u.Bio != "",
+		"avatar":         u.ProfilePictureURL != "",
+		"verified_email": u.EmailVerified,
+	}
+	total, score := 0, 0
+	for part, weight := range completenessWeights {
+		total += weight
+		if filled[part] {
+			score += weight
+		}
+	}
+	if total == 0 {
+		return 100
+	}
+	return score * 100 / total
+}
+
+// localizeUser converts u's timestamps to the user's preferred timezone, if
+// one is set.
+func localizeUser(u User) User {
+	if u.Timezone == "" {
+		return u
+	}
+	location, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return u
+	}
+	u.CreatedAt = u.CreatedAt.In(location)
+	u.UpdatedAt = u.UpdatedAt.In(location)
+	if u.DeletedAt != nil {
+		deletedAt := u.DeletedAt.In(location)
+		u.DeletedAt = &deletedAt
+	}
+	if u.LastLoginAt != nil {
+		lastLoginAt := u.LastLoginAt.In(location)
+		u.LastLoginAt = &lastLoginAt
+	}
+	return u
+}
+
+// requestID returns the ID assigned to the current request by the request ID
+// middleware.
+func requestID(c echo.Context) string {
+	id := c.Response().Header().Get(echo.HeaderXRequestID)
+	if id == "" {
+		id = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	return id
+}
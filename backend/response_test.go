@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("User Responses", func() {
+	ginkgo.Context("timeFormat", func() {
+		var user User
+
+		ginkgo.BeforeEach(func() {
+			testUser := User{Username: "timeuser", Email: "timeuser@example.com", Password: "password123"}
+			err := createUser(context.Background(), db, testEmailSender, &testUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			user, err = getUserByID(context.Background(), db, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+		})
+
+		render := func(target string) map[string]interface{} {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			c := e.NewContext(req, httptest.NewRecorder())
+
+			body, err := json.Marshal(presentUser(c, user))
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			var decoded map[string]interface{}
+			gomega.Expect(json.Unmarshal(body, &decoded)).Should(gomega.Succeed())
+			return decoded
+		}
+
+		ginkgo.It("Should render RFC3339 timestamps by default", func() {
+			decoded := render("/users/1")
+
+			createdAt, ok := decoded["created_at"].(string)
+			gomega.Expect(ok).Should(gomega.BeTrue())
+			parsed, err := time.Parse(time.RFC3339, createdAt)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(parsed.Equal(user.CreatedAt)).Should(gomega.BeTrue())
+		})
+
+		ginkgo.It("Should render epoch milliseconds with timeFormat=unix", func() {
+			decoded := render("/users/1?timeFormat=unix")
+
+			gomega.Expect(decoded["created_at"]).Should(gomega.BeEquivalentTo(user.CreatedAt.UnixMilli()))
+			gomega.Expect(decoded["updated_at"]).Should(gomega.BeEquivalentTo(user.UpdatedAt.UnixMilli()))
+			gomega.Expect(decoded["username"]).Should(gomega.Equal("timeuser"))
+		})
+	})
+
+	ginkgo.Context("optional fields", func() {
+		var testUser User
+		var previousDefaultPicture string
+
+		ginkgo.BeforeEach(func() {
+			previousDefaultPicture = defaultProfilePictureURL
+			testUser = User{Username: "bareuser", Email: "bareuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+			defaultProfilePictureURL = ""
+		})
+
+		ginkgo.AfterEach(func() {
+			nullOptionalFields = false
+			defaultProfilePictureURL = previousDefaultPicture
+		})
+
+		decode := func(body []byte, target interface{}) {
+			gomega.Expect(json.Unmarshal(body, target)).Should(gomega.Succeed())
+		}
+
+		// rendered returns the user as sent by GET /users/:id and as an
+		// element of a list, in both time formats.
+		rendered := func() []map[string]interface{} {
+			server := echo.New()
+			server.GET("/users/:id", getUserHandler(db))
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/"+strconv.Itoa(testUser.ID), nil))
+			var fetched map[string]interface{}
+			decode(rec.Body.Bytes(), &fetched)
+
+			results := []map[string]interface{}{fetched}
+			for _, target := range []string{"/users", "/users?timeFormat=unix"} {
+				c := e.NewContext(httptest.NewRequest(http.MethodGet, target, nil), httptest.NewRecorder())
+				body, err := json.Marshal(presentUsers(c, []User{testUser}))
+				gomega.Expect(err).Should(gomega.BeNil())
+				var listed []map[string]interface{}
+				decode(body, &listed)
+				results = append(results, listed[0])
+			}
+			return results
+		}
+
+		ginkgo.It("Should send unset fields as empty strings by default", func() {
+			for _, user := range rendered() {
+				gomega.Expect(user).Should(gomega.HaveKeyWithValue("bio", ""))
+				gomega.Expect(user).Should(gomega.HaveKeyWithValue("profile_picture_url", ""))
+			}
+
+			body, err := json.Marshal(VerificationStatus{})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(string(body)).Should(gomega.Equal(`{"verified":false,"pending_email":""}`))
+		})
+
+		ginkgo.It("Should send unset fields as null when NullOptionalFields is on", func() {
+			nullOptionalFields = true
+			for _, user := range rendered() {
+				gomega.Expect(user).Should(gomega.HaveKeyWithValue("bio", gomega.BeNil()))
+				gomega.Expect(user).Should(gomega.HaveKeyWithValue("profile_picture_url", gomega.BeNil()))
+				gomega.Expect(user).Should(gomega.HaveKeyWithValue("username", "bareuser"))
+			}
+
+			body, err := json.Marshal(VerificationStatus{})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(string(body)).Should(gomega.Equal(`{"verified":false,"pending_email":null}`))
+		})
+	})
+
+	ginkgo.Context("timezone", func() {
+		ginkgo.It("Should render timestamps in the user's timezone", func() {
+			testUser := User{Username: "tzuser", Email: "tzuser@example.com", Password: "password123", Timezone: "Asia/Tokyo"}
+			err := createUser(context.Background(), db, testEmailSender, &testUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			user, err := getUserByID(context.Background(), db, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(user.Timezone).Should(gomega.Equal("Asia/Tokyo"))
+
+			req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+			c := e.NewContext(req, httptest.NewRecorder())
+			body, err := json.Marshal(presentUser(c, user))
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			var decoded map[string]interface{}
+			gomega.Expect(json.Unmarshal(body, &decoded)).Should(gomega.Succeed())
+			gomega.Expect(decoded["created_at"]).Should(gomega.HaveSuffix("+09:00"))
+		})
+	})
+
+	ginkgo.Context("pagination", func() {
+		ginkgo.It("Should round totalPages up", func() {
+			page := newUserPage([]User{}, 2, 10, 21)
+			gomega.Expect(page.TotalPages).Should(gomega.Equal(3))
+
+			page = newUserPage([]User{}, 1, 10, 0)
+			gomega.Expect(page.TotalPages).Should(gomega.Equal(0))
+		})
+
+		ginkgo.It("Should count with the same conditions as the list query", func() {
+			for _, name := range []string{"counta", "countb", "other"} {
+				user := User{Username: name, Email: name + "@example.com", Password: "password123"}
+				gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			}
+			_, err := db.Exec("UPDATE users SET deleted_at = NOW() WHERE username = 'countb'")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			total, err := countUsers(context.Background(), db, UserFilter{})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(total).Should(gomega.Equal(2))
+
+			total, err = countUsers(context.Background(), db, UserFilter{Search: "count"})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(total).Should(gomega.Equal(1))
+		})
+
+		ginkgo.It("Should wrap the list in an envelope", func() {
+			body, err := json.Marshal(newUserPage([]User{{Username: "a"}}, 1, 10, 1))
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			var decoded map[string]interface{}
+			gomega.Expect(json.Unmarshal(body, &decoded)).Should(gomega.Succeed())
+			gomega.Expect(decoded).Should(gomega.HaveKey("data"))
+			gomega.Expect(decoded["page"]).Should(gomega.BeNumerically("==", 1))
+			gomega.Expect(decoded["pageSize"]).Should(gomega.BeNumerically("==", 10))
+			gomega.Expect(decoded["total"]).Should(gomega.BeNumerically("==", 1))
+			gomega.Expect(decoded["totalPages"]).Should(gomega.BeNumerically("==", 1))
+		})
+	})
+	ginkgo.Context("profile_completeness", func() {
+		var testUser User
+
+		ginkgo.BeforeEach(func() {
+			testUser = User{Username: "completeuser", Email: "completeuser@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+		})
+
+		ginkgo.AfterEach(func() {
+			completenessWeights = defaultCompletenessWeights
+		})
+
+		completeness := func() float64 {
+			user, err := getUserByID(context.Background(), db, testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/users/1", nil), httptest.NewRecorder())
+			body, err := json.Marshal(presentUser(c, user))
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			var decoded map[string]interface{}
+			gomega.Expect(json.Unmarshal(body, &decoded)).Should(gomega.Succeed())
+			return decoded["profile_completeness"].(float64)
+		}
+
+		ginkgo.It("Should score a bare user 0", func() {
+			gomega.Expect(completeness()).Should(gomega.Equal(0.0))
+		})
+
+		ginkgo.It("Should score a fully filled profile 100", func() {
+			_, err := db.Exec("UPDATE users SET bio = 'Hello', profile_picture_url = 'https://example.com/me.png', email_verified = TRUE WHERE id = $1", testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			invalidateUser(testUser.ID)
+
+			gomega.Expect(completeness()).Should(gomega.Equal(100.0))
+		})
+
+		ginkgo.It("Should apply the configured weights", func() {
+			completenessWeights = map[string]int{"bio": 1, "avatar": 2, "verified_email": 1}
+			_, err := db.Exec("UPDATE users SET profile_picture_url = 'https://example.com/me.png' WHERE id = $1", testUser.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+			invalidateUser(testUser.ID)
+
+			gomega.Expect(completeness()).Should(gomega.Equal(50.0))
+		})
+	})
+})
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	errUserNotDeleted       = errors.New("user_not_deleted")
+	errRestoreWindowExpired = errors.New("restore_window_expired")
+	errUserAnonymized       = errors.New("user_anonymized")
+)
+
+// restoreUser undoes the soft delete of a user deleted less than window ago.
+// Users deleted earlier may already have had data purged or their username
+// released, so they are refused with errRestoreWindowExpired. It returns
+// sql.ErrNoRows for unknown users, errUserNotDeleted for active ones and
+// errUserAnonymized for users whose data has been scrubbed.
+func restoreUser(db *sql.DB, id int, window time.Duration) error {
+	var deletedAt, anonymizedAt sql.NullTime
+	err := db.QueryRow("SELECT deleted_at, anonymized_at FROM users WHERE id = $1", id).Scan(&deletedAt, &anonymizedAt)
+	if err != nil {
+		return err
+	}
+	if anonymizedAt.Valid {
+		return errUserAnonymized
+	}
+	if !deletedAt.Valid {
+		return errUserNotDeleted
+	}
+	if time.Since(deletedAt.Time) > window {
+		return errRestoreWindowExpired
+	}
+
+	// deleted_at is matched again so a concurrent restore, or a delete and
+	// restore in between, isn't applied twice.
+	result, err := db.Exec("UPDATE users SET deleted_at = NULL, updated_at = NOW() WHERE id = $1 AND deleted_at = $2", id, deletedAt.Time)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errUserNotDeleted
+	}
+
+	invalidateUser(id)
+	return nil
+}
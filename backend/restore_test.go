@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Restore User", func() {
+	var testUser User
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "restoreuser", Email: "restoreuser@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+	})
+
+	deleteAgo := func(age time.Duration) {
+		_, err := db.Exec("UPDATE users SET deleted_at = $1 WHERE id = $2", time.Now().Add(-age), testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		invalidateUser(testUser.ID)
+	}
+
+	ginkgo.It("Should restore a user deleted within the window", func() {
+		deleteAgo(time.Hour)
+		_, err := getUserByID(context.Background(), db, testUser.ID)
+		gomega.Expect(err).Should(gomega.Equal(sql.ErrNoRows))
+
+		gomega.Expect(restoreUser(db, testUser.ID, 24*time.Hour)).Should(gomega.Succeed())
+
+		user, err := getUserByID(context.Background(), db, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(user.Username).Should(gomega.Equal("restoreuser"))
+	})
+
+	ginkgo.It("Should refuse a user deleted outside the window", func() {
+		deleteAgo(48 * time.Hour)
+
+		gomega.Expect(restoreUser(db, testUser.ID, 24*time.Hour)).Should(gomega.Equal(errRestoreWindowExpired))
+
+		_, err := getUserByID(context.Background(), db, testUser.ID)
+		gomega.Expect(err).Should(gomega.Equal(sql.ErrNoRows))
+	})
+
+	ginkgo.It("Should refuse a user that isn't deleted", func() {
+		gomega.Expect(restoreUser(db, testUser.ID, 24*time.Hour)).Should(gomega.Equal(errUserNotDeleted))
+	})
+
+	ginkgo.It("Should report an unknown user", func() {
+		gomega.Expect(restoreUser(db, 999999, 24*time.Hour)).Should(gomega.Equal(sql.ErrNoRows))
+	})
+
+	ginkgo.Context("Creating a user with a deleted user's email", func() {
+		var admin User
+
+		ginkgo.BeforeEach(func() {
+			admin = User{Username: "restoreadmin", Email: "restoreadmin@example.com", Password: "password123", Role: roleAdmin}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &admin)).Should(gomega.Succeed())
+			deleteAgo(time.Hour)
+		})
+
+		post := func(token string) (int, map[string]interface{}) {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.Validator = e.Validator
+			server.POST("/users", createUserHandler(cfg, db, testEmailSender, nil))
+
+			payload := `{"username":"newname","email":"restoreuser@example.com","password":"password123"}`
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(payload))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+
+			var body map[string]interface{}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			return rec.Code, body
+		}
+
+		ginkgo.It("Should point admins at the restore endpoint", func() {
+			token, err := issueToken(cfg, admin.ID, roleAdmin)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			code, body := post(token)
+			gomega.Expect(code).Should(gomega.Equal(http.StatusConflict))
+			gomega.Expect(body["error"]).Should(gomega.Equal("email_exists_deleted"))
+			gomega.Expect(body["restore_path"]).Should(gomega.Equal(fmt.Sprintf("/users/%d/restore", testUser.ID)))
+		})
+
+		ginkgo.It("Should give everyone else the plain conflict", func() {
+			member := User{Username: "restoremember", Email: "restoremember@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &member)).Should(gomega.Succeed())
+			token, err := issueToken(cfg, member.ID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			for _, t := range []string{"", token} {
+				code, body := post(t)
+				gomega.Expect(code).Should(gomega.Equal(http.StatusBadRequest))
+				gomega.Expect(body["error"]).Should(gomega.Equal("username_or_email_exists"))
+				gomega.Expect(body).ShouldNot(gomega.HaveKey("restore_path"))
+			}
+		})
+
+		ginkgo.It("Should report an active user's conflict even when a deleted user also matches", func() {
+			active := User{Username: "newname", Email: "active@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &active)).Should(gomega.Succeed())
+
+			err := createUser(context.Background(), db, testEmailSender, &User{Username: "newname", Email: "restoreuser@example.com", Password: "password123"})
+			var deleted *deletedEmailConflictError
+			gomega.Expect(errors.As(err, &deleted)).Should(gomega.BeFalse())
+			gomega.Expect(err).Should(gomega.MatchError("username_or_email_exists"))
+		})
+	})
+})
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3BlobStore keeps blobs in an S3 bucket. Requests are signed with AWS
+// Signature Version 4, which is all this needs from an SDK.
+type s3BlobStore struct {
+	bucket          string
+	region          string
+	endpoint        string
+	pathStyle       bool
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	baseURL         string
+	client          *http.Client
+}
+
+// newS3BlobStore builds an s3BlobStore from Config.Storage. Without
+// S3Endpoint it talks to AWS using virtual-hosted URLs; with one, e.g. for
+// MinIO, it uses path-style URLs against that endpoint. BaseURL defaults to
+// the bucket URL. S3SessionToken is only needed with temporary credentials,
+// such as those from an assumed role.
+func newS3BlobStore(cfg *Config) (*s3BlobStore, error) {
+	st := cfg.Storage
+	if st.S3Bucket == "" || st.S3Region == "" || st.S3AccessKeyID == "" || st.S3SecretAccessKey == "" {
+		return nil, errors.New("the s3 storage driver needs s3_bucket, s3_region, s3_access_key_id and s3_secret_access_key")
+	}
+	s := &s3BlobStore{
+		bucket:          st.S3Bucket,
+		region:          st.S3Region,
+		accessKeyID:     st.S3AccessKeyID,
+		secretAccessKey: st.S3SecretAccessKey,
+		sessionToken:    st.S3SessionToken,
+		client:          &http.Client{Timeout: 30 * time.Second},
+	}
+	if st.S3Endpoint != "" {
+		s.endpoint = strings.TrimSuffix(st.S3Endpoint, "/")
+		s.pathStyle = true
+	} else {
+		s.endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s.bucket, s.region)
+	}
+	s.baseURL = strings.TrimSuffix(st.S3BaseURL, "/")
+	if s.baseURL == "" {
+		s.baseURL = strings.TrimSuffix(s.objectURL(""), "/")
+	}
+	return s, nil
+}
+
+func (s *s3BlobStore) objectURL(key string) string {
+	if s.pathStyle {
+		return s.endpoint + "/" + s.bucket + "/" + key
+	}
+	return s.endpoint + "/" + key
+}
+
+func (s *s3BlobStore) Put(key string, r io.Reader, contentType string) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := s.do(req, data); err != nil {
+		return "", err
+	}
+	return s.baseURL + "/" + key, nil
+}
+
+func (s *s3BlobStore) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	// S3 answers 204 whether or not the key existed.
+	return s.do(req, nil)
+}
+
+func (s *s3BlobStore) do(req *http.Request, payload []byte) error {
+	s.sign(req, payload, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, body)
+	}
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 headers to req.
+func (s *s3BlobStore) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalURI percent-encodes path the way SigV4 expects: every byte
+// except unreserved characters and '/' is escaped, so keys containing
+// characters such as '$' or '+' sign the same way S3 checks them.
+func canonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var errSchemaMissing = errors.New("database schema is missing or out of date; run the server with -migrate")
+
+// expectedSchema lists the tables and columns the backend queries. It mirrors
+// the migrations in migrations/.
+var expectedSchema = []struct {
+	table   string
+	columns []string
+}{
+	{"users", []string{"id", "uuid", "tenant_id", "username", "email", "password", "profile_picture_url", "avatar_key", "bio", "timezone", "verification_token", "email_verified", "pending_email", "role", "signup_source", "tokens_revoked_at", "failed_logins", "locked_until", "last_login_at", "anonymized_at", "created_at", "updated_at", "deleted_at"}},
+	{"audit_logs", []string{"id", "action", "user_id", "actor_id", "created_at"}},
+	{"password_reset_tokens", []string{"id", "user_id", "token_hash", "expires_at", "used_at", "created_at"}},
+	{"unlock_tokens", []string{"id", "user_id", "token_hash", "expires_at", "used_at", "created_at"}},
+	{"refresh_tokens", []string{"id", "user_id", "family", "token_hash", "expires_at", "rotated_at", "revoked_at", "created_at"}},
+	{"revoked_tokens", []string{"jti", "expires_at"}},
+}
+
+// checkSchema verifies that every table and column in expectedSchema exists,
+// so a database without migrations is reported at startup instead of as a
+// 500 on the first request.
+func checkSchema(db *sql.DB) error {
+	for _, t := range expectedSchema {
+		_, err := db.Exec(fmt.Sprintf("SELECT %s FROM %s LIMIT 0", strings.Join(t.columns, ", "), t.table))
+		// 42P01 is undefined_table and 42703 is undefined_column.
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && (pqErr.Code == "42P01" || pqErr.Code == "42703") {
+			return fmt.Errorf("%w: %s", errSchemaMissing, pqErr.Message)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
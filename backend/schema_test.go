@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Schema Check", func() {
+	ginkgo.It("Should pass against the test database", func() {
+		gomega.Expect(checkSchema(db)).Should(gomega.Succeed())
+	})
+
+	ginkgo.Context("Without migrations", func() {
+		var emptyDB *sql.DB
+
+		ginkgo.BeforeEach(func() {
+			_, err := db.Exec("CREATE SCHEMA IF NOT EXISTS schema_check_empty")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s search_path=schema_check_empty",
+				os.Getenv("DB_HOST"),
+				os.Getenv("DB_USER"),
+				os.Getenv("DB_PASSWORD"),
+				os.Getenv("DB_NAME"),
+				getEnvAsInt("DB_PORT", 5432),
+				os.Getenv("DB_SSLMODE"),
+			)
+			emptyDB, err = sql.Open("postgres", dsn)
+			gomega.Expect(err).Should(gomega.BeNil())
+		})
+
+		ginkgo.AfterEach(func() {
+			emptyDB.Close()
+			db.Exec("DROP SCHEMA schema_check_empty CASCADE")
+		})
+
+		ginkgo.It("Should report a missing table clearly", func() {
+			err := checkSchema(emptyDB)
+			gomega.Expect(err).Should(gomega.MatchError(errSchemaMissing))
+			gomega.Expect(err.Error()).Should(gomega.ContainSubstring(`relation "users" does not exist`))
+		})
+
+		ginkgo.It("Should report a missing column clearly", func() {
+			_, err := emptyDB.Exec("CREATE TABLE users (id SERIAL PRIMARY KEY, username VARCHAR(255))")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			err = checkSchema(emptyDB)
+			gomega.Expect(err).Should(gomega.MatchError(errSchemaMissing))
+			gomega.Expect(err.Error()).Should(gomega.ContainSubstring("does not exist"))
+		})
+	})
+
+	ginkgo.Context("Active user indexes", func() {
+		// With sequential scans disabled the planner picks an index whenever
+		// one can serve the query, so a plan without the partial index means
+		// the query lost the predicate the index requires.
+		plan := func(query string, args ...interface{}) string {
+			tx, err := db.Begin()
+			gomega.Expect(err).Should(gomega.BeNil())
+			defer tx.Rollback()
+			_, err = tx.Exec("SET LOCAL enable_seqscan = off")
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			rows, err := tx.Query("EXPLAIN "+query, args...)
+			gomega.Expect(err).Should(gomega.BeNil())
+			defer rows.Close()
+			var lines []string
+			for rows.Next() {
+				var line string
+				gomega.Expect(rows.Scan(&line)).Should(gomega.Succeed())
+				lines = append(lines, line)
+			}
+			return strings.Join(lines, "\n")
+		}
+
+		ginkgo.It("Should look up logins through the partial indexes", func() {
+			p := plan(loginQuery, 0, "someone@example.com")
+			gomega.Expect(p).Should(gomega.ContainSubstring("users_active_username_idx"))
+			gomega.Expect(p).Should(gomega.ContainSubstring("users_active_email_idx"))
+		})
+
+		ginkgo.It("Should look up password reset emails through the partial index", func() {
+			gomega.Expect(plan(resetLookupQuery, 0, "someone@example.com")).Should(gomega.ContainSubstring("users_active_email_idx"))
+		})
+
+		ginkgo.It("Should keep the soft-delete predicate in email filters", func() {
+			query, _, err := usersQuery(UserFilter{Email: "someone@example.com"}, defaultUserSort).ToSql()
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(query).Should(gomega.ContainSubstring("deleted_at IS NULL"))
+			gomega.Expect(query).Should(gomega.ContainSubstring("LOWER(email) = LOWER("))
+		})
+	})
+})
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// seedPassword is the password of every seeded user. It satisfies the
+// strictest PasswordPolicy settings so seeding works whatever is configured.
+const seedPassword = "Seed-password-1"
+
+// devDatabaseName matches database names that look like they hold throwaway
+// data, such as app_dev, website-test or lzake_temp_website.
+var devDatabaseName = regexp.MustCompile(`(?i)(^|[_-])(dev|development|test|testing|local|demo|temp|tmp)($|[_-])`)
+
+var errSeedRefused = errors.New("refusing to seed a database whose name doesn't look like a dev or test database")
+
+var (
+	seedFirstNames = []string{"ada", "grace", "alan", "linus", "margaret", "dennis", "barbara", "ken", "radia", "edsger", "frances", "donald"}
+	seedLastNames  = []string{"lovelace", "hopper", "turing", "torvalds", "hamilton", "ritchie", "liskov", "thompson", "perlman", "dijkstra", "allen", "knuth"}
+	seedBios       = []string{
+		"Backend developer who enjoys tidy SQL.",
+		"Frontend tinkerer, Angular by day and CSS art by night.",
+		"Coffee first, code second.",
+		"Writes tests before breakfast.",
+		"Open source contributor and occasional speaker.",
+		"",
+	}
+)
+
+// seedUser returns the i-th fake user. The same i always gives the same
+// user, so seeding twice finds the first batch already there.
+func seedUser(i int) User {
+	first := seedFirstNames[i%len(seedFirstNames)]
+	last := seedLastNames[(i/len(seedFirstNames))%len(seedLastNames)]
+	username := first + "_" + last
+	if round := i / (len(seedFirstNames) * len(seedLastNames)); round > 0 {
+		username = fmt.Sprintf("%s%d", username, round)
+	}
+	return User{
+		Username:     username,
+		Email:        username + "@example.com",
+		Password:     seedPassword,
+		Bio:          seedBios[i%len(seedBios)],
+		SignupSource: signupSourceImport,
+		Role:         roleUser,
+	}
+}
+
+// seedUsers inserts n fake users for local development through createUser,
+// after the same validation and password policy as signups. Users that
+// already exist are skipped and counted. It refuses to run unless
+// Config.Database.DBName looks like a dev or test database.
+func seedUsers(ctx context.Context, db *sql.DB, cfg *Config, sender EmailSender, n int) (created int, skipped int, err error) {
+	if !devDatabaseName.MatchString(cfg.Database.DBName) {
+		return 0, 0, fmt.Errorf("%w: %q", errSeedRefused, cfg.Database.DBName)
+	}
+
+	v, err := newValidator(cfg)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := 0; i < n; i++ {
+		user := seedUser(i)
+		if err := validateSeedUser(v, cfg, user); err != nil {
+			return created, skipped, fmt.Errorf("seed user %s: %w", user.Username, err)
+		}
+		err := createUser(ctx, db, sender, &user)
+		if err != nil && err.Error() == "username_or_email_exists" {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return created, skipped, fmt.Errorf("seed user %s: %w", user.Username, err)
+		}
+		created++
+	}
+	return created, skipped, nil
+}
+
+func validateSeedUser(v *validator.Validate, cfg *Config, user User) error {
+	if err := v.Struct(user); err != nil {
+		return err
+	}
+	if err := v.Struct(newUserPassword{Password: user.Password}); err != nil {
+		return err
+	}
+	if failed := cfg.App.PasswordPolicy.Check(user.Password); failed != nil {
+		return fmt.Errorf("password breaks %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
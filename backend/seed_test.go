@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Seeding", func() {
+	var seedCfg Config
+
+	ginkgo.BeforeEach(func() {
+		seedCfg = *cfg
+		seedCfg.Database.DBName = "website_test"
+	})
+
+	ginkgo.It("Should create users that can log in with the seed password", func() {
+		created, skipped, err := seedUsers(context.Background(), db, &seedCfg, testEmailSender, 5)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(created).Should(gomega.Equal(5))
+		gomega.Expect(skipped).Should(gomega.BeZero())
+
+		_, err = authenticateUser(db, &seedCfg, testEmailSender, 0, seedUser(0).Username, seedPassword)
+		gomega.Expect(err).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("Should skip users that already exist", func() {
+		_, _, err := seedUsers(context.Background(), db, &seedCfg, testEmailSender, 3)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		created, skipped, err := seedUsers(context.Background(), db, &seedCfg, testEmailSender, 5)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(created).Should(gomega.Equal(2))
+		gomega.Expect(skipped).Should(gomega.Equal(3))
+	})
+
+	ginkgo.It("Should give every seeded user a distinct username", func() {
+		seen := map[string]bool{}
+		for i := 0; i < 500; i++ {
+			user := seedUser(i)
+			gomega.Expect(seen).ShouldNot(gomega.HaveKey(user.Username))
+			gomega.Expect(len(user.Username)).Should(gomega.BeNumerically("<=", 30))
+			seen[user.Username] = true
+		}
+	})
+
+	ginkgo.It("Should refuse a database that doesn't look like dev or test", func() {
+		for _, name := range []string{"website", "production", "contest"} {
+			seedCfg.Database.DBName = name
+			_, _, err := seedUsers(context.Background(), db, &seedCfg, testEmailSender, 1)
+			gomega.Expect(errors.Is(err, errSeedRefused)).Should(gomega.BeTrue(), name)
+		}
+
+		var count int
+		gomega.Expect(db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)).Should(gomega.Succeed())
+		gomega.Expect(count).Should(gomega.BeZero())
+	})
+})
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// listenAddress builds the address to listen on from Config.Server. An empty
+// host listens on all interfaces.
+func listenAddress(cfg *Config) (string, error) {
+	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
+		return "", fmt.Errorf("server port %d is out of range 1-65535", cfg.Server.Port)
+	}
+	return net.JoinHostPort(cfg.Server.Host, strconv.Itoa(cfg.Server.Port)), nil
+}
+
+// serve runs e on address until ctx is cancelled, then shuts it down, giving
+// in-flight requests up to shutdownTimeout to finish.
+func serve(ctx context.Context, e *echo.Echo, address string, shutdownTimeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- e.Start(address)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
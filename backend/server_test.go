@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Graceful Shutdown", func() {
+	ginkgo.It("Should drain in-flight requests and not leak goroutines", func() {
+		baseline := runtime.NumGoroutine()
+
+		server := echo.New()
+		server.HideBanner = true
+		server.HidePort = true
+		started := make(chan struct{})
+		server.GET("/slow", func(c echo.Context) error {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			return c.String(http.StatusOK, "done")
+		})
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		gomega.Expect(err).Should(gomega.BeNil())
+		server.Listener = listener
+
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() {
+			served <- serve(ctx, server, "", 5*time.Second)
+		}()
+
+		type result struct {
+			body string
+			err  error
+		}
+		responses := make(chan result, 1)
+		go func() {
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			resp, err := client.Get("http://" + listener.Addr().String() + "/slow")
+			if err != nil {
+				responses <- result{err: err}
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			responses <- result{body: string(body), err: err}
+		}()
+
+		<-started
+		cancel()
+
+		var res result
+		gomega.Eventually(responses, 5*time.Second).Should(gomega.Receive(&res))
+		gomega.Expect(res.err).Should(gomega.BeNil())
+		gomega.Expect(res.body).Should(gomega.Equal("done"))
+		gomega.Eventually(served, 5*time.Second).Should(gomega.Receive(gomega.BeNil()))
+
+		gomega.Eventually(runtime.NumGoroutine, 5*time.Second).Should(gomega.BeNumerically("<=", baseline))
+	})
+	ginkgo.Context("listenAddress", func() {
+		ginkgo.It("Should default to :8080", func() {
+			testCfg := Config{}
+			applyConfigDefaults(&testCfg)
+			address, err := listenAddress(&testCfg)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(address).Should(gomega.Equal(":8080"))
+		})
+
+		ginkgo.It("Should bind to the configured host and port", func() {
+			testCfg := Config{}
+			testCfg.Server.Host = "127.0.0.1"
+			testCfg.Server.Port = 9090
+			address, err := listenAddress(&testCfg)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(address).Should(gomega.Equal("127.0.0.1:9090"))
+		})
+
+		ginkgo.It("Should reject ports out of range", func() {
+			testCfg := Config{}
+			testCfg.Server.Port = 70000
+			_, err := listenAddress(&testCfg)
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+
+			testCfg.Server.Port = -1
+			_, err = listenAddress(&testCfg)
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+	})
+})
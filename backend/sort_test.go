@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("User Sorting", func() {
+	ginkgo.Context("parseUserSort", func() {
+		ginkgo.It("Should default to created_at descending", func() {
+			sort, err := parseUserSort("", "")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(sort).Should(gomega.Equal(UserSort{Column: "created_at", Descending: true}))
+		})
+
+		ginkgo.It("Should accept whitelisted columns", func() {
+			sort, err := parseUserSort("username", "desc")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(sort.orderBy()).Should(gomega.Equal([]string{"username DESC", "id DESC"}))
+		})
+
+		ginkgo.It("Should reject unknown columns and orders", func() {
+			_, err := parseUserSort("password", "asc")
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+
+			_, err = parseUserSort("id; DROP TABLE users", "")
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+
+			_, err = parseUserSort("id", "sideways")
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("Should not sort by id or break ties by id with UUID IDs", func() {
+			uuidIDs = true
+			defer func() { uuidIDs = false }()
+
+			_, err := parseUserSort("id", "asc")
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+
+			sort, err := parseUserSort("username", "asc")
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(sort.orderBy()).Should(gomega.Equal([]string{"username ASC", "uuid ASC"}))
+		})
+	})
+
+	ginkgo.Context("getUsers", func() {
+		ginkgo.BeforeEach(func() {
+			for _, name := range []string{"bravo", "alpha", "charlie"} {
+				user := User{Username: name, Email: name + "@example.com", Password: "password123"}
+				gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+			}
+		})
+
+		usernames := func(users []User) []string {
+			var names []string
+			for _, u := range users {
+				names = append(names, u.Username)
+			}
+			return names
+		}
+
+		ginkgo.It("Should sort by username", func() {
+			users, err := getUsers(context.Background(), db, 1, 10, UserFilter{}, UserSort{Column: "username"})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(usernames(users)).Should(gomega.Equal([]string{"alpha", "bravo", "charlie"}))
+
+			users, err = getUsers(context.Background(), db, 1, 10, UserFilter{}, UserSort{Column: "username", Descending: true})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(usernames(users)).Should(gomega.Equal([]string{"charlie", "bravo", "alpha"}))
+		})
+
+		ginkgo.It("Should return the newest users first by default", func() {
+			users, err := getUsers(context.Background(), db, 1, 10, UserFilter{}, defaultUserSort)
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(usernames(users)).Should(gomega.Equal([]string{"charlie", "alpha", "bravo"}))
+		})
+	})
+})
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Signup sources recorded in users.signup_source.
+const (
+	signupSourceWeb    = "web"
+	signupSourceAPI    = "api"
+	signupSourceImport = "import"
+	signupSourceInvite = "invite"
+)
+
+var signupSources = []string{signupSourceWeb, signupSourceAPI, signupSourceImport, signupSourceInvite}
+
+// signupSource classifies a single-user signup. Browsers always send an
+// Origin header on POST; API clients generally don't.
+func signupSource(c echo.Context) string {
+	if c.Request().Header.Get(echo.HeaderOrigin) != "" {
+		return signupSourceWeb
+	}
+	return signupSourceAPI
+}
+
+// countUsersBySource returns the number of active users per signup source.
+// Every known source is present, with 0 if nobody signed up through it.
+func countUsersBySource(db *sql.DB) (map[string]int, error) {
+	counts := make(map[string]int, len(signupSources))
+	for _, source := range signupSources {
+		counts[source] = 0
+	}
+
+	rows, err := db.Query("SELECT signup_source, COUNT(*) FROM users WHERE deleted_at IS NULL GROUP BY signup_source")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var source string
+		var count int
+		if err := rows.Scan(&source, &count); err != nil {
+			return nil, err
+		}
+		counts[source] = count
+	}
+	return counts, rows.Err()
+}
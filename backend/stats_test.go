@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Signup Source Stats", func() {
+	sourceOf := func(origin string) string {
+		req := httptest.NewRequest(http.MethodPost, "/users", nil)
+		if origin != "" {
+			req.Header.Set(echo.HeaderOrigin, origin)
+		}
+		return signupSource(e.NewContext(req, httptest.NewRecorder()))
+	}
+
+	ginkgo.It("Should classify browser and API signups", func() {
+		gomega.Expect(sourceOf("http://localhost:4200")).Should(gomega.Equal(signupSourceWeb))
+		gomega.Expect(sourceOf("")).Should(gomega.Equal(signupSourceAPI))
+	})
+
+	ginkgo.It("Should count active users per source", func() {
+		seed := []struct {
+			name   string
+			source string
+		}{
+			{"webuser1", sourceOf("http://localhost:4200")},
+			{"webuser2", sourceOf("http://localhost:4200")},
+			{"apiuser", sourceOf("")},
+			{"imported1", signupSourceImport},
+			{"imported2", signupSourceImport},
+			{"imported3", signupSourceImport},
+		}
+		for _, s := range seed {
+			user := User{Username: s.name, Email: s.name + "@example.com", Password: "password123", SignupSource: s.source}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+		}
+		_, err := db.Exec("UPDATE users SET deleted_at = NOW() WHERE username = 'imported3'")
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		counts, err := countUsersBySource(db)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(counts).Should(gomega.Equal(map[string]int{
+			signupSourceWeb:    2,
+			signupSourceAPI:    1,
+			signupSourceImport: 2,
+			signupSourceInvite: 0,
+		}))
+	})
+
+	ginkgo.Context("RequireRole", func() {
+		var admin, member User
+
+		ginkgo.BeforeEach(func() {
+			admin = User{Username: "admin", Email: "admin@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &admin)).Should(gomega.Succeed())
+			_, err := db.Exec("UPDATE users SET role = $1 WHERE id = $2", roleAdmin, admin.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			member = User{Username: "member", Email: "member@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &member)).Should(gomega.Succeed())
+		})
+
+		get := func(userID int) int {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.GET("/stats/sources", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, RequireAuth(cfg, db), RequireRole(db, roleAdmin))
+
+			token, err := issueToken(cfg, userID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+			req := httptest.NewRequest(http.MethodGet, "/stats/sources", nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		ginkgo.It("Should allow admins", func() {
+			gomega.Expect(get(admin.ID)).Should(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("Should deny other users", func() {
+			gomega.Expect(get(member.ID)).Should(gomega.Equal(http.StatusForbidden))
+		})
+	})
+})
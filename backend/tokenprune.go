@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// pruneTokens runs one cleanup cycle over every token table, deleting the
+// tokens that can no longer be used. A failing table doesn't stop the
+// others; their errors are returned together.
+func pruneTokens(db *sql.DB, cfg *Config) error {
+	steps := []struct {
+		what  string
+		prune func() (int64, error)
+	}{
+		{"refresh tokens", func() (int64, error) { return pruneRefreshTokens(db) }},
+		{"revoked token IDs", func() (int64, error) { return pruneRevokedTokens(db) }},
+		{"password reset tokens", func() (int64, error) { return pruneResetTokens(db) }},
+		{"unlock tokens", func() (int64, error) { return pruneUnlockTokens(db) }},
+		{"verification tokens", func() (int64, error) {
+			return pruneVerificationTokens(db, cfg.App.VerificationTokenTTL.Duration)
+		}},
+	}
+
+	var errs []error
+	for _, step := range steps {
+		removed, err := step.prune()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pruning %s: %w", step.what, err))
+			continue
+		}
+		if removed > 0 {
+			log.Infof("Pruned %d expired %s", removed, step.what)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runTokenPruner calls pruneTokens every
+// Config.App.RefreshTokenPruneInterval until ctx is cancelled.
+func runTokenPruner(ctx context.Context, db *sql.DB, cfg *Config) {
+	ticker := time.NewTicker(cfg.App.RefreshTokenPruneInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := pruneTokens(db, cfg); err != nil {
+				log.Errorf("Error pruning tokens: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Token Pruning", func() {
+	var testUser, staleUser User
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "pruneuser", Email: "pruneuser@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &testUser)).Should(gomega.Succeed())
+		staleUser = User{Username: "staleuser", Email: "staleuser@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &staleUser)).Should(gomega.Succeed())
+	})
+
+	count := func(query string, args ...interface{}) int {
+		var n int
+		gomega.Expect(db.QueryRow(query, args...).Scan(&n)).Should(gomega.Succeed())
+		return n
+	}
+
+	ginkgo.It("Should delete only expired tokens in one cycle", func() {
+		liveReset, err := createPasswordResetToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		expiredReset, err := createPasswordResetToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		liveUnlock, err := createUnlockToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		expiredUnlock, err := createUnlockToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		liveRefresh, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		expiredRefresh, err := createRefreshToken(db, cfg, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		for _, table := range []string{"password_reset_tokens", "unlock_tokens", "refresh_tokens"} {
+			_, err = db.Exec("UPDATE "+table+" SET expires_at = NOW() - INTERVAL '1 minute' WHERE token_hash = ANY(ARRAY[$1, $2, $3])",
+				hashResetToken(expiredReset), hashResetToken(expiredUnlock), hashResetToken(expiredRefresh))
+			gomega.Expect(err).Should(gomega.BeNil())
+		}
+		signedUpAt := time.Now().Add(-cfg.App.VerificationTokenTTL.Duration - time.Minute)
+		_, err = db.Exec("UPDATE users SET created_at = $1 WHERE id = $2", signedUpAt, staleUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		gomega.Expect(pruneTokens(db, cfg)).Should(gomega.Succeed())
+
+		gomega.Expect(count("SELECT COUNT(*) FROM password_reset_tokens WHERE user_id = $1", testUser.ID)).Should(gomega.Equal(1))
+		gomega.Expect(count("SELECT COUNT(*) FROM password_reset_tokens WHERE token_hash = $1", hashResetToken(liveReset))).Should(gomega.Equal(1))
+		gomega.Expect(count("SELECT COUNT(*) FROM unlock_tokens WHERE user_id = $1", testUser.ID)).Should(gomega.Equal(1))
+		gomega.Expect(count("SELECT COUNT(*) FROM unlock_tokens WHERE token_hash = $1", hashResetToken(liveUnlock))).Should(gomega.Equal(1))
+		gomega.Expect(count("SELECT COUNT(*) FROM refresh_tokens WHERE user_id = $1", testUser.ID)).Should(gomega.Equal(1))
+		gomega.Expect(count("SELECT COUNT(*) FROM refresh_tokens WHERE token_hash = $1", hashResetToken(liveRefresh))).Should(gomega.Equal(1))
+
+		gomega.Expect(count("SELECT COUNT(*) FROM users WHERE id = $1 AND verification_token IS NOT NULL", testUser.ID)).Should(gomega.Equal(1))
+		gomega.Expect(count("SELECT COUNT(*) FROM users WHERE id = $1 AND verification_token IS NOT NULL", staleUser.ID)).Should(gomega.Equal(0))
+	})
+})
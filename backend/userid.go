@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// ID types for Config.App.IDType.
+const (
+	idTypeInt  = "int"
+	idTypeUUID = "uuid"
+)
+
+// uuidIDs is Config.App.IDType == "uuid", set by main. When it is on,
+// clients see and address users by users.uuid instead of the integer
+// primary key, so IDs don't give away how many users there are or in which
+// order they signed up. The integer stays the internal ID: tokens, the
+// cache, audit logs and foreign keys keep using it.
+var uuidIDs bool
+
+var errInvalidUserID = errors.New("invalid user ID")
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// checkIDType reports whether idType is a supported Config.App.IDType.
+func checkIDType(idType string) error {
+	switch idType {
+	case idTypeInt, idTypeUUID:
+		return nil
+	}
+	return errors.New("unknown id_type " + strconv.Quote(idType))
+}
+
+// publicUserID returns the ID clients see for the user with the given
+// integer ID and UUID.
+func publicUserID(id int, uuid string) interface{} {
+	if uuidIDs {
+		return uuid
+	}
+	return id
+}
+
+// lookupUserIDs maps each UUID in uuids to the integer ID of the user,
+// deleted or not, that has it. UUIDs no user has are left out.
+func lookupUserIDs(ctx context.Context, db *sql.DB, uuids []string) (map[string]int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+
+	sql, args, err := statementBuilder.Select("uuid", "id").From("users").Where(squirrel.Eq{"uuid": uuids}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]int, len(uuids))
+	for rows.Next() {
+		var uuid string
+		var id int
+		if err := rows.Scan(&uuid, &id); err != nil {
+			return nil, err
+		}
+		ids[uuid] = id
+	}
+	return ids, rows.Err()
+}
+
+// resolveUserIDParam is middleware that, when uuidIDs is on, replaces a
+// UUID :id route parameter with the user's integer ID, so handlers and
+// RequireSelf keep parsing it with strconv.Atoi. A UUID no user has becomes
+// 0, which no user has either, so handlers answer as for any unknown ID.
+// Integer IDs are rejected; they would let clients enumerate users.
+func resolveUserIDParam(db *sql.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !uuidIDs {
+				return next(c)
+			}
+			values := c.ParamValues()
+			for i, name := range c.ParamNames() {
+				if name != "id" || i >= len(values) {
+					continue
+				}
+				if !uuidPattern.MatchString(values[i]) {
+					return newAPIError(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
+				}
+				uuid := strings.ToLower(values[i])
+				found, err := lookupUserIDs(c.Request().Context(), db, []string{uuid})
+				if err != nil {
+					log.Errorf("request %s: resolving user UUID %s: %v", requestID(c), uuid, err)
+					return databaseError(err, "failed_to_retrieve_user", "Failed to retrieve user")
+				}
+				values[i] = strconv.Itoa(found[uuid])
+				c.SetParamValues(values...)
+			}
+			return next(c)
+		}
+	}
+}
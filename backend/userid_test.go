@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("User IDs", func() {
+	var server *echo.Echo
+
+	ginkgo.BeforeEach(func() {
+		server = echo.New()
+		server.HTTPErrorHandler = httpErrorHandler
+		server.Validator = e.Validator
+		server.Use(resolveUserIDParam(db))
+		server.POST("/users", createUserHandler(cfg, db, testEmailSender, nil))
+		server.GET("/users/:id", getUserHandler(db))
+	})
+
+	request := func(method string, path string, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		var res map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &res)
+		return rec.Code, res
+	}
+
+	ginkgo.Context("with integer IDs", func() {
+		ginkgo.It("Should send and accept the integer ID", func() {
+			code, created := request(http.MethodPost, "/users", `{"username":"intuser","email":"intuser@example.com","password":"password123"}`)
+			gomega.Expect(code).Should(gomega.Equal(http.StatusCreated))
+			id, ok := created["id"].(float64)
+			gomega.Expect(ok).Should(gomega.BeTrue())
+
+			code, fetched := request(http.MethodGet, fmt.Sprintf("/users/%d", int(id)), "")
+			gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(fetched["id"]).Should(gomega.Equal(id))
+		})
+	})
+
+	ginkgo.Context("with UUID IDs", func() {
+		ginkgo.BeforeEach(func() {
+			uuidIDs = true
+		})
+
+		ginkgo.AfterEach(func() {
+			uuidIDs = false
+		})
+
+		ginkgo.It("Should create and fetch a user by UUID", func() {
+			code, created := request(http.MethodPost, "/users", `{"username":"uuiduser","email":"uuiduser@example.com","password":"password123"}`)
+			gomega.Expect(code).Should(gomega.Equal(http.StatusCreated))
+			id, ok := created["id"].(string)
+			gomega.Expect(ok).Should(gomega.BeTrue())
+			gomega.Expect(id).Should(gomega.MatchRegexp(uuidPattern.String()))
+
+			code, fetched := request(http.MethodGet, "/users/"+id, "")
+			gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+			gomega.Expect(fetched["id"]).Should(gomega.Equal(id))
+			gomega.Expect(fetched["username"]).Should(gomega.Equal("uuiduser"))
+
+			code, _ = request(http.MethodGet, "/users/"+strings.ToUpper(id), "")
+			gomega.Expect(code).Should(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("Should reject integer IDs", func() {
+			user := User{Username: "uuidint", Email: "uuidint@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &user)).Should(gomega.Succeed())
+
+			code, res := request(http.MethodGet, fmt.Sprintf("/users/%d", user.ID), "")
+			gomega.Expect(code).Should(gomega.Equal(http.StatusBadRequest))
+			gomega.Expect(res["error"]).Should(gomega.Equal("invalid_user_id"))
+		})
+
+		ginkgo.It("Should not find a UUID no user has", func() {
+			code, _ := request(http.MethodGet, "/users/00000000-0000-4000-8000-000000000000", "")
+			gomega.Expect(code).Should(gomega.Equal(http.StatusNotFound))
+		})
+
+		ginkgo.It("Should round-trip the UUID through User JSON", func() {
+			data, err := json.Marshal(User{ID: 7, UUID: "0b5f4f3e-93a1-4c55-9a43-3c2a8d1b7e10", Username: "roundtrip"})
+			gomega.Expect(err).Should(gomega.BeNil())
+			gomega.Expect(string(data)).Should(gomega.HavePrefix(`{"id":"0b5f4f3e-93a1-4c55-9a43-3c2a8d1b7e10",`))
+
+			var user User
+			gomega.Expect(json.Unmarshal(data, &user)).Should(gomega.Succeed())
+			gomega.Expect(user.UUID).Should(gomega.Equal("0b5f4f3e-93a1-4c55-9a43-3c2a8d1b7e10"))
+			gomega.Expect(user.Username).Should(gomega.Equal("roundtrip"))
+		})
+	})
+})
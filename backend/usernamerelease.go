@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// usernameReleaseInterval is how often runUsernameReleaser looks for deleted
+// users whose usernames can be released.
+const usernameReleaseInterval = time.Minute
+
+// releaseDeletedUsernames frees the usernames of users that were soft-deleted
+// more than releaseAfter ago by appending a tombstone suffix to them. The rows
+// themselves stay until they are purged. Anonymized users already carry a
+// placeholder username and are skipped. It returns the number of usernames
+// released.
+func releaseDeletedUsernames(db *sql.DB, releaseAfter time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-releaseAfter)
+	result, err := db.Exec(`UPDATE users SET username = username || '~deleted-' || id
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1 AND anonymized_at IS NULL AND username NOT LIKE ('%~deleted-' || id)`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// runUsernameReleaser releases deleted usernames until ctx is cancelled.
+func runUsernameReleaser(ctx context.Context, db *sql.DB, cfg *Config) {
+	ticker := time.NewTicker(usernameReleaseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			released, err := releaseDeletedUsernames(db, cfg.App.UsernameReleaseAfter.Duration)
+			if err != nil {
+				log.Errorf("Error releasing deleted usernames: %v", err)
+				continue
+			}
+			if released > 0 {
+				log.Infof("Released %d deleted usernames", released)
+			}
+		}
+	}
+}
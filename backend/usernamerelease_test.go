@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Username Release", func() {
+	var deletedUser User
+
+	ginkgo.BeforeEach(func() {
+		deletedUser = User{Username: "taken", Email: "taken@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &deletedUser)).Should(gomega.Succeed())
+		gomega.Expect(deleteUser(context.Background(), db, deletedUser.ID)).Should(gomega.Succeed())
+	})
+
+	ginkgo.It("Should keep the username reserved within the window", func() {
+		released, err := releaseDeletedUsernames(db, time.Hour)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(released).Should(gomega.Equal(int64(0)))
+
+		newUser := User{Username: "taken", Email: "new@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &newUser)).Should(gomega.MatchError("username_or_email_exists"))
+	})
+
+	ginkgo.It("Should make the username reusable after the window", func() {
+		_, err := db.Exec("UPDATE users SET deleted_at = $1 WHERE id = $2", time.Now().Add(-2*time.Hour), deletedUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		released, err := releaseDeletedUsernames(db, time.Hour)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(released).Should(gomega.Equal(int64(1)))
+
+		newUser := User{Username: "taken", Email: "new@example.com", Password: "password123"}
+		gomega.Expect(createUser(context.Background(), db, testEmailSender, &newUser)).Should(gomega.Succeed())
+
+		var tombstoned string
+		err = db.QueryRow("SELECT username FROM users WHERE id = $1", deletedUser.ID).Scan(&tombstoned)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(tombstoned).Should(gomega.HavePrefix("taken~deleted-"))
+
+		released, err = releaseDeletedUsernames(db, time.Hour)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(released).Should(gomega.Equal(int64(0)))
+	})
+})
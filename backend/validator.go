@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+// newValidator returns the validator used for request payloads with the
+// app's custom validations registered.
+func newValidator(cfg *Config) (*validator.Validate, error) {
+	v := validator.New()
+	validations := []struct {
+		tag string
+		fn  validator.Func
+	}{
+		{"bio", validateBio(cfg.App.BioMaxLength)},
+		{"profile_picture_url", validateProfilePictureURL(cfg.App.ProfilePictureURLMaxLength)},
+		{"custom_profile_picture", validateCustomProfilePicture(cfg.App.DefaultProfilePictureURL)},
+	}
+	for _, validation := range validations {
+		if err := v.RegisterValidation(validation.tag, validation.fn); err != nil {
+			return nil, fmt.Errorf("registering %s validation: %w", validation.tag, err)
+		}
+	}
+	return v, nil
+}
+
+// normalizeUser cleans up user input before validation. A profile picture
+// URL of only whitespace becomes empty so the default picture applies.
+func normalizeUser(user *User) {
+	user.ProfilePictureURL = strings.TrimSpace(user.ProfilePictureURL)
+}
+
+// normalizeEmail lowercases an email so addresses differing only in case
+// are treated as the same account. It is applied before every uniqueness
+// check and write.
+func normalizeEmail(email string) string {
+	return strings.ToLower(email)
+}
+
+// normalizeUserPatch is normalizeUser for a patch.
+func normalizeUserPatch(patch *UserPatch) {
+	if patch.ProfilePictureURL != nil {
+		trimmed := strings.TrimSpace(*patch.ProfilePictureURL)
+		patch.ProfilePictureURL = &trimmed
+	}
+}
+
+// FieldError is one failed rule in a validation_failed response. Code is the
+// rule's tag (required, email, min, ...) and Param its argument, so clients
+// can map them to their own localized text.
+type FieldError struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+	Param string `json:"param,omitempty"`
+}
+
+// newUserPassword holds the rules for the password of a user being created.
+// Updates don't change the password, so User itself doesn't require one.
+type newUserPassword struct {
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// validateNewUser validates a user being created, including the password,
+// and reports all failed fields together.
+func validateNewUser(c echo.Context, user User) error {
+	var failed validator.ValidationErrors
+	for _, payload := range []interface{}{user, newUserPassword{Password: user.Password}} {
+		err := c.Validate(payload)
+		var validationErrors validator.ValidationErrors
+		if errors.As(err, &validationErrors) {
+			failed = append(failed, validationErrors...)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// validationErrorStatus is the status of validation failures: 400, or 422
+// with Config.App.UnprocessableValidationErrors. Set by main. Malformed
+// bodies are always 400.
+var validationErrorStatus = http.StatusBadRequest
+
+// validationError turns an error from c.Validate(payload) into a
+// validationErrorStatus response. Each
+// failed rule is listed under "errors" using the payload's JSON field names,
+// and "fields" maps each field to a readable message. "details" keeps the
+// validator's own message.
+func validationError(payload interface{}, err error) *APIError {
+	apiErr := newAPIError(validationErrorStatus, "validation_failed", "Validation failed").With("details", err.Error())
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return apiErr
+	}
+	fieldErrors := make([]FieldError, 0, len(validationErrors))
+	messages := make(map[string]string, len(validationErrors))
+	for _, fe := range validationErrors {
+		field := jsonFieldName(payload, fe.StructField())
+		fieldErrors = append(fieldErrors, FieldError{Field: field, Code: fe.Tag(), Param: fe.Param()})
+		if _, ok := messages[field]; !ok {
+			messages[field] = validationMessage(fe)
+		}
+	}
+	return apiErr.With("errors", fieldErrors).With("fields", messages)
+}
+
+// validationMessage describes a failed rule in words, for display next to
+// the field.
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email"
+	case "min":
+		return "must be at least " + fe.Param() + " characters"
+	case "max":
+		return "must be at most " + fe.Param() + " characters"
+	case "timezone":
+		return "must be a valid time zone"
+	case "profile_picture_url":
+		return "must be an http or https URL"
+	case "custom_profile_picture":
+		return "must not be the default profile picture"
+	case "bio":
+		return "is too long or contains invalid characters"
+	default:
+		return "is invalid"
+	}
+}
+
+// jsonFieldName returns the JSON name of the named field of payload, falling
+// back to the Go field name.
+func jsonFieldName(payload interface{}, structField string) string {
+	t := reflect.TypeOf(payload)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return structField
+	}
+	f, ok := t.FieldByName(structField)
+	if !ok {
+		return structField
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return structField
+	}
+	return name
+}
+
+// validateBio rejects bios that are longer than maxLength characters, are not
+// valid UTF-8, or contain control characters other than line breaks and tabs.
+// Raw control bytes break rendering in the frontend and corrupt log lines.
+func validateBio(maxLength int) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		bio := fl.Field().String()
+		if !utf8.ValidString(bio) || utf8.RuneCountInString(bio) > maxLength {
+			return false
+		}
+		for _, r := range bio {
+			if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// validateProfilePictureURL accepts absolute http and https URLs of at most
+// maxLength characters.
+func validateProfilePictureURL(maxLength int) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		raw := fl.Field().String()
+		if utf8.RuneCountInString(raw) > maxLength {
+			return false
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			return false
+		}
+		return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	}
+}
+
+// validateCustomProfilePicture rejects the default profile picture URL, which
+// is only ever filled in when responses are rendered.
+func validateCustomProfilePicture(defaultURL string) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		return defaultURL == "" || fl.Field().String() != defaultURL
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Validator", func() {
+	var cv *CustomValidator
+
+	ginkgo.BeforeEach(func() {
+		v, err := newValidator(cfg)
+		gomega.Expect(err).Should(gomega.BeNil())
+		cv = &CustomValidator{validator: v}
+	})
+
+	ginkgo.Context("bio", func() {
+		ginkgo.It("Should reject a bio containing a null byte", func() {
+			user := User{Username: "biouser", Email: "biouser@example.com", Bio: "hello\x00world"}
+
+			err := cv.Validate(user)
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+
+			validationErrors, ok := err.(validator.ValidationErrors)
+			gomega.Expect(ok).Should(gomega.BeTrue())
+			gomega.Expect(validationErrors[0].Field()).Should(gomega.Equal("Bio"))
+		})
+
+		ginkgo.It("Should accept a valid multi-line bio", func() {
+			user := User{Username: "biouser", Email: "biouser@example.com", Bio: "First line\nSecond line\twith a tab"}
+
+			gomega.Expect(cv.Validate(user)).Should(gomega.Succeed())
+		})
+
+		ginkgo.It("Should reject a bio longer than the configured maximum", func() {
+			user := User{Username: "biouser", Email: "biouser@example.com", Bio: strings.Repeat("a", cfg.App.BioMaxLength+1)}
+
+			gomega.Expect(cv.Validate(user)).Should(gomega.HaveOccurred())
+		})
+	})
+
+	ginkgo.Context("timezone", func() {
+		ginkgo.It("Should accept a valid IANA timezone", func() {
+			user := User{Username: "tzuser", Email: "tzuser@example.com", Timezone: "Europe/Berlin"}
+
+			gomega.Expect(cv.Validate(user)).Should(gomega.Succeed())
+		})
+
+		ginkgo.It("Should reject an unknown timezone", func() {
+			user := User{Username: "tzuser", Email: "tzuser@example.com", Timezone: "Mars/Olympus_Mons"}
+
+			err := cv.Validate(user)
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+			gomega.Expect(err.(validator.ValidationErrors)[0].Field()).Should(gomega.Equal("Timezone"))
+		})
+	})
+	ginkgo.Context("default profile picture", func() {
+		const placeholder = "https://cdn.example.com/default-avatar.png"
+
+		ginkgo.AfterEach(func() {
+			defaultProfilePictureURL = ""
+		})
+
+		ginkgo.It("Should normalize a whitespace-only URL to empty", func() {
+			user := User{Username: "picuser", Email: "picuser@example.com", ProfilePictureURL: " \t\n "}
+			normalizeUser(&user)
+			gomega.Expect(user.ProfilePictureURL).Should(gomega.BeEmpty())
+			gomega.Expect(cv.Validate(user)).Should(gomega.Succeed())
+
+			url := "   "
+			patch := UserPatch{ProfilePictureURL: &url}
+			normalizeUserPatch(&patch)
+			gomega.Expect(*patch.ProfilePictureURL).Should(gomega.BeEmpty())
+		})
+
+		ginkgo.It("Should reject the placeholder as a user's own picture", func() {
+			testCfg := *cfg
+			testCfg.App.DefaultProfilePictureURL = placeholder
+			v, err := newValidator(&testCfg)
+			gomega.Expect(err).Should(gomega.BeNil())
+			placeholderValidator := &CustomValidator{validator: v}
+
+			user := User{Username: "picuser", Email: "picuser@example.com", ProfilePictureURL: placeholder}
+			err = placeholderValidator.Validate(user)
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+			gomega.Expect(err.(validator.ValidationErrors)[0].Tag()).Should(gomega.Equal("custom_profile_picture"))
+
+			user.ProfilePictureURL = "https://cdn.example.com/me.png"
+			gomega.Expect(placeholderValidator.Validate(user)).Should(gomega.Succeed())
+		})
+
+		ginkgo.It("Should show the default for users without a picture", func() {
+			defaultProfilePictureURL = placeholder
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/users/1", nil), httptest.NewRecorder())
+
+			normalized := User{Username: "picuser", ProfilePictureURL: "  "}
+			normalizeUser(&normalized)
+			gomega.Expect(presentUser(c, normalized).(User).ProfilePictureURL).Should(gomega.Equal(placeholder))
+
+			own := User{Username: "picuser", ProfilePictureURL: "https://cdn.example.com/me.png"}
+			gomega.Expect(presentUser(c, own).(User).ProfilePictureURL).Should(gomega.Equal("https://cdn.example.com/me.png"))
+		})
+	})
+
+	ginkgo.Context("profile_picture_url", func() {
+		urlOfLength := func(n int) string {
+			prefix := "https://example.com/"
+			return prefix + strings.Repeat("a", n-len(prefix))
+		}
+
+		ginkgo.It("Should accept a URL at the configured maximum", func() {
+			user := User{Username: "picuser", Email: "picuser@example.com", ProfilePictureURL: urlOfLength(cfg.App.ProfilePictureURLMaxLength)}
+
+			gomega.Expect(cv.Validate(user)).Should(gomega.Succeed())
+		})
+
+		ginkgo.It("Should reject a URL one character over the maximum", func() {
+			user := User{Username: "picuser", Email: "picuser@example.com", ProfilePictureURL: urlOfLength(cfg.App.ProfilePictureURLMaxLength + 1)}
+
+			err := cv.Validate(user)
+			gomega.Expect(err).Should(gomega.HaveOccurred())
+			gomega.Expect(err.(validator.ValidationErrors)[0].Field()).Should(gomega.Equal("ProfilePictureURL"))
+		})
+
+		ginkgo.It("Should reject a malformed URL", func() {
+			for _, malformed := range []string{"not a url", "example.com/pic.png", "javascript:alert(1)", "https://"} {
+				user := User{Username: "picuser", Email: "picuser@example.com", ProfilePictureURL: malformed}
+
+				gomega.Expect(cv.Validate(user)).Should(gomega.HaveOccurred(), malformed)
+			}
+		})
+
+		ginkgo.It("Should allow an empty URL", func() {
+			user := User{Username: "picuser", Email: "picuser@example.com"}
+
+			gomega.Expect(cv.Validate(user)).Should(gomega.Succeed())
+		})
+	})
+
+	ginkgo.Context("validationError", func() {
+		ginkgo.It("Should report the code and param for a too-short password", func() {
+			req := PasswordResetConfirmation{Token: "token", NewPassword: "short"}
+
+			apiErr := validationError(req, cv.Validate(req))
+			gomega.Expect(apiErr.Status).Should(gomega.Equal(http.StatusBadRequest))
+			gomega.Expect(apiErr.Code).Should(gomega.Equal("validation_failed"))
+			gomega.Expect(apiErr.Fields["errors"]).Should(gomega.Equal([]FieldError{
+				{Field: "new_password", Code: "min", Param: "8"},
+			}))
+		})
+
+		ginkgo.It("Should list every failed field with its JSON name", func() {
+			req := PasswordResetConfirmation{}
+
+			apiErr := validationError(req, cv.Validate(req))
+			gomega.Expect(apiErr.Fields["errors"]).Should(gomega.ConsistOf(
+				FieldError{Field: "token", Code: "required"},
+				FieldError{Field: "new_password", Code: "required"},
+			))
+		})
+
+		ginkgo.It("Should omit errors for a non-validator error", func() {
+			apiErr := validationError(User{}, errors.New("boom"))
+			gomega.Expect(apiErr.Fields).ShouldNot(gomega.HaveKey("errors"))
+			gomega.Expect(apiErr.Fields["details"]).Should(gomega.Equal("boom"))
+		})
+	})
+
+	ginkgo.Context("user fields", func() {
+		ginkgo.It("Should reject an empty username and an invalid email", func() {
+			user := User{Username: "", Email: "invalid_email"}
+
+			apiErr := validationError(user, cv.Validate(user))
+			gomega.Expect(apiErr.Fields["fields"]).Should(gomega.Equal(map[string]string{
+				"username": "is required",
+				"email":    "must be a valid email",
+			}))
+		})
+
+		ginkgo.It("Should limit the username length", func() {
+			gomega.Expect(cv.Validate(User{Username: "ab", Email: "ab@example.com"})).Should(gomega.HaveOccurred())
+			gomega.Expect(cv.Validate(User{Username: strings.Repeat("a", 31), Email: "long@example.com"})).Should(gomega.HaveOccurred())
+			gomega.Expect(cv.Validate(User{Username: "abc", Email: "abc@example.com"})).Should(gomega.Succeed())
+		})
+	})
+
+	ginkgo.Context("validateNewUser", func() {
+		var c echo.Context
+
+		ginkgo.BeforeEach(func() {
+			server := echo.New()
+			server.Validator = cv
+			c = server.NewContext(httptest.NewRequest(http.MethodPost, "/users", nil), httptest.NewRecorder())
+		})
+
+		ginkgo.It("Should require a password of at least 8 characters", func() {
+			user := User{Username: "newuser", Email: "newuser@example.com", Password: "short"}
+
+			apiErr := validationError(user, validateNewUser(c, user))
+			gomega.Expect(apiErr.Fields["fields"]).Should(gomega.Equal(map[string]string{
+				"password": "must be at least 8 characters",
+			}))
+
+			user.Password = ""
+			apiErr = validationError(user, validateNewUser(c, user))
+			gomega.Expect(apiErr.Fields["fields"]).Should(gomega.Equal(map[string]string{
+				"password": "is required",
+			}))
+		})
+
+		ginkgo.It("Should report user and password errors together", func() {
+			user := User{Username: "newuser", Email: "not-an-email"}
+
+			apiErr := validationError(user, validateNewUser(c, user))
+			gomega.Expect(apiErr.Fields["fields"]).Should(gomega.HaveKey("email"))
+			gomega.Expect(apiErr.Fields["fields"]).Should(gomega.HaveKey("password"))
+		})
+
+		ginkgo.It("Should accept a complete new user", func() {
+			user := User{Username: "newuser", Email: "newuser@example.com", Password: "password123"}
+			gomega.Expect(validateNewUser(c, user)).Should(gomega.Succeed())
+		})
+	})
+
+	ginkgo.Context("Validation error status", func() {
+		post := func(payload string) int {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.Validator = e.Validator
+			server.POST("/users", createUserHandler(cfg, db, testEmailSender, nil))
+
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(payload))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		ginkgo.AfterEach(func() {
+			validationErrorStatus = http.StatusBadRequest
+		})
+
+		ginkgo.It("Should answer both with 400 by default", func() {
+			gomega.Expect(post(`{"username":"newuser","email":"not-an-email","password":"password123"}`)).Should(gomega.Equal(http.StatusBadRequest))
+			gomega.Expect(post(`{"username":`)).Should(gomega.Equal(http.StatusBadRequest))
+		})
+
+		ginkgo.It("Should answer validation failures with 422 when configured", func() {
+			validationErrorStatus = http.StatusUnprocessableEntity
+
+			gomega.Expect(post(`{"username":"newuser","email":"not-an-email","password":"password123"}`)).Should(gomega.Equal(http.StatusUnprocessableEntity))
+			gomega.Expect(post(`{"username":`)).Should(gomega.Equal(http.StatusBadRequest))
+		})
+	})
+})
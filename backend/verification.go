@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/labstack/echo/v4"
+)
+
+// VerificationStatus is the subset of a user the frontend polls while the
+// user completes email verification.
+type VerificationStatus struct {
+	Verified     bool    `json:"verified"`
+	PendingEmail *string `json:"pending_email"`
+}
+
+// MarshalJSON sends a missing pending email as "" unless
+// nullOptionalFields is on, like the optional fields of a user.
+func (s VerificationStatus) MarshalJSON() ([]byte, error) {
+	type plainStatus VerificationStatus
+	if s.PendingEmail == nil {
+		s.PendingEmail = optionalField("")
+	}
+	return json.Marshal(plainStatus(s))
+}
+
+func getVerificationStatus(db *sql.DB, id int) (VerificationStatus, error) {
+	var status VerificationStatus
+	queryBuilder := statementBuilder.Select("email_verified", "pending_email").From("users").Where(squirrel.Eq{"id": id, "deleted_at": nil})
+	sql, args, err := queryBuilder.ToSql()
+	if err != nil {
+		return status, err
+	}
+
+	err = db.QueryRow(sql, args...).Scan(&status.Verified, &status.PendingEmail)
+	if err != nil {
+		return status, err
+	}
+	return status, nil
+}
+
+// verificationStatusHandler serves GET /users/:id/verification-status. It
+// must run after RequireAuth and RequireSelfOrRole.
+func verificationStatusHandler(db *sql.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return newAPIError(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
+		}
+		status, err := getVerificationStatus(db, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return newAPIError(http.StatusNotFound, "user_not_found", "User not found")
+			}
+			return newAPIError(http.StatusInternalServerError, "failed_to_retrieve_verification_status", "Failed to retrieve verification status")
+		}
+		return c.JSON(http.StatusOK, status)
+	}
+}
+
+// pruneVerificationTokens clears the verification tokens of users who
+// signed up more than ttl ago without verifying.
+func pruneVerificationTokens(db *sql.DB, ttl time.Duration) (int64, error) {
+	result, err := db.Exec("UPDATE users SET verification_token = NULL WHERE verification_token IS NOT NULL AND created_at < $1", time.Now().Add(-ttl))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
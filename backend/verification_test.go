@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/labstack/echo/v4"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Verification Status", func() {
+	var testUser User
+
+	ginkgo.BeforeEach(func() {
+		testUser = User{Username: "verifyuser", Email: "verifyuser@example.com", Password: "password123"}
+		err := createUser(context.Background(), db, testEmailSender, &testUser)
+		gomega.Expect(err).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("Should report a new user as unverified", func() {
+		status, err := getVerificationStatus(db, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(status.Verified).Should(gomega.BeFalse())
+		gomega.Expect(status.PendingEmail).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("Should report a verified user", func() {
+		_, err := db.Exec("UPDATE users SET email_verified = TRUE WHERE id = $1", testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		status, err := getVerificationStatus(db, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(status.Verified).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("Should report a pending email change", func() {
+		_, err := db.Exec("UPDATE users SET email_verified = TRUE, pending_email = $1 WHERE id = $2", "new@example.com", testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+
+		status, err := getVerificationStatus(db, testUser.ID)
+		gomega.Expect(err).Should(gomega.BeNil())
+		gomega.Expect(status.PendingEmail).ShouldNot(gomega.BeNil())
+		gomega.Expect(*status.PendingEmail).Should(gomega.Equal("new@example.com"))
+	})
+
+	ginkgo.It("Should return ErrNoRows for an unknown user", func() {
+		_, err := getVerificationStatus(db, 999999)
+		gomega.Expect(err).Should(gomega.Equal(sql.ErrNoRows))
+	})
+
+	ginkgo.Context("GET /users/:id/verification-status", func() {
+		get := func(userID int) *httptest.ResponseRecorder {
+			server := echo.New()
+			server.HTTPErrorHandler = httpErrorHandler
+			server.GET("/users/:id/verification-status", verificationStatusHandler(db), RequireAuth(cfg, db), RequireSelfOrRole(db, roleAdmin))
+
+			token, err := issueToken(cfg, userID, roleUser)
+			gomega.Expect(err).Should(gomega.BeNil())
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/users/%d/verification-status", testUser.ID), nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			return rec
+		}
+
+		ginkgo.It("Should let an admin read another user's status", func() {
+			admin := User{Username: "verifyadmin", Email: "verifyadmin@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &admin)).Should(gomega.Succeed())
+			_, err := db.Exec("UPDATE users SET role = $1 WHERE id = $2", roleAdmin, admin.ID)
+			gomega.Expect(err).Should(gomega.BeNil())
+
+			rec := get(admin.ID)
+			gomega.Expect(rec.Code).Should(gomega.Equal(http.StatusOK))
+			var body map[string]interface{}
+			gomega.Expect(json.Unmarshal(rec.Body.Bytes(), &body)).Should(gomega.Succeed())
+			gomega.Expect(body["verified"]).Should(gomega.Equal(false))
+		})
+
+		ginkgo.It("Should not let a regular user read another user's status", func() {
+			other := User{Username: "verifyother", Email: "verifyother@example.com", Password: "password123"}
+			gomega.Expect(createUser(context.Background(), db, testEmailSender, &other)).Should(gomega.Succeed())
+
+			gomega.Expect(get(other.ID).Code).Should(gomega.Equal(http.StatusForbidden))
+		})
+	})
+})
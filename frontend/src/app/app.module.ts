@@ -1,7 +1,7 @@
 import { NgModule } from '@angular/core';
 import { BrowserModule } from '@angular/platform-browser';
 import { BrowserAnimationsModule } from '@angular/platform-browser/animations';
-import { HttpClientModule } from '@angular/common/http';
+import { HTTP_INTERCEPTORS, HttpClientModule } from '@angular/common/http';
 import { ReactiveFormsModule } from '@angular/forms'; 
 
 import { MatButtonModule } from '@angular/material/button';
@@ -16,6 +16,8 @@ import { UserListComponent } from './user-list/user-list.component';
 import { UserCreateComponent } from './user-create/user-create.component';
 import { UserEditComponent } from './user-edit/user-edit.component';
 import { UserDeleteComponent } from './user-delete/user-delete.component'; 
+import { UserLoginComponent } from './user-login/user-login.component';
+import { AuthInterceptor } from './auth.interceptor';
 
 @NgModule({
   declarations: [
@@ -23,7 +25,8 @@ import { UserDeleteComponent } from './user-delete/user-delete.component';
     UserListComponent,
     UserCreateComponent,
     UserEditComponent,
-    UserDeleteComponent 
+    UserDeleteComponent,
+    UserLoginComponent
   ],
   imports: [
     BrowserModule,
@@ -37,7 +40,9 @@ import { UserDeleteComponent } from './user-delete/user-delete.component';
     MatFormFieldModule,
     ReactiveFormsModule 
   ],
-  providers: [], 
+  providers: [
+    { provide: HTTP_INTERCEPTORS, useClass: AuthInterceptor, multi: true }
+  ],
   bootstrap: [AppComponent]
 })
 export class AppModule { }
\ No newline at end of file
@@ -0,0 +1,55 @@
+import { TestBed } from '@angular/core/testing';
+import { HTTP_INTERCEPTORS, HttpClient } from '@angular/common/http';
+import { HttpClientTestingModule, HttpTestingController } from '@angular/common/http/testing';
+
+import { AuthInterceptor } from './auth.interceptor';
+import { AuthService } from './auth.service';
+
+describe('AuthInterceptor', () => {
+  let http: HttpClient;
+  let httpMock: HttpTestingController;
+  let authService: AuthService;
+
+  beforeEach(() => {
+    localStorage.clear();
+    TestBed.configureTestingModule({
+      imports: [HttpClientTestingModule],
+      providers: [{ provide: HTTP_INTERCEPTORS, useClass: AuthInterceptor, multi: true }]
+    });
+    http = TestBed.inject(HttpClient);
+    httpMock = TestBed.inject(HttpTestingController);
+    authService = TestBed.inject(AuthService);
+  });
+
+  afterEach(() => {
+    httpMock.verify();
+    localStorage.clear();
+  });
+
+  it('should send the stored token', () => {
+    localStorage.setItem('access_token', 'token');
+
+    http.get('/users').subscribe();
+
+    const req = httpMock.expectOne('/users');
+    expect(req.request.headers.get('Authorization')).toBe('Bearer token');
+    req.flush([]);
+  });
+
+  it('should not send a header when logged out', () => {
+    http.get('/users').subscribe();
+
+    const req = httpMock.expectOne('/users');
+    expect(req.request.headers.has('Authorization')).toBeFalse();
+    req.flush([]);
+  });
+
+  it('should forget a token the API rejects', () => {
+    localStorage.setItem('access_token', 'expired');
+
+    http.get('/users').subscribe({ error: () => { } });
+
+    httpMock.expectOne('/users').flush({ error: 'invalid_token' }, { status: 401, statusText: 'Unauthorized' });
+    expect(authService.isLoggedIn()).toBeFalse();
+  });
+});
@@ -0,0 +1,25 @@
+import { Injectable } from '@angular/core';
+import { HttpErrorResponse, HttpEvent, HttpHandler, HttpInterceptor, HttpRequest } from '@angular/common/http';
+import { Observable, throwError } from 'rxjs';
+import { catchError } from 'rxjs/operators';
+import { AuthService } from './auth.service';
+
+@Injectable()
+export class AuthInterceptor implements HttpInterceptor {
+  constructor(private authService: AuthService) { }
+
+  intercept(request: HttpRequest<unknown>, next: HttpHandler): Observable<HttpEvent<unknown>> {
+    const token = this.authService.token;
+    if (token && !request.headers.has('Authorization')) {
+      request = request.clone({ setHeaders: { Authorization: `Bearer ${token}` } });
+    }
+    return next.handle(request).pipe(
+      catchError(error => {
+        if (error instanceof HttpErrorResponse && error.status === 401 && token) {
+          this.authService.clearToken();
+        }
+        return throwError(() => error);
+      })
+    );
+  }
+}
@@ -0,0 +1,45 @@
+import { TestBed } from '@angular/core/testing';
+import { HttpClientTestingModule, HttpTestingController } from '@angular/common/http/testing';
+
+import { AuthService } from './auth.service';
+
+describe('AuthService', () => {
+  let service: AuthService;
+  let httpMock: HttpTestingController;
+
+  beforeEach(() => {
+    localStorage.clear();
+    TestBed.configureTestingModule({
+      imports: [HttpClientTestingModule]
+    });
+    service = TestBed.inject(AuthService);
+    httpMock = TestBed.inject(HttpTestingController);
+  });
+
+  afterEach(() => {
+    httpMock.verify();
+    localStorage.clear();
+  });
+
+  it('should store the access token on login', () => {
+    service.login('admin', 'password123').subscribe();
+
+    const req = httpMock.expectOne('http://localhost:8080/api/v1/login');
+    expect(req.request.body).toEqual({ login: 'admin', password: 'password123' });
+    req.flush({ access_token: 'token', token_type: 'Bearer', expires_in: 3600 });
+
+    expect(service.isLoggedIn()).toBeTrue();
+    expect(service.token).toBe('token');
+  });
+
+  it('should forget the token on logout', () => {
+    localStorage.setItem('access_token', 'token');
+
+    service.logout();
+
+    const req = httpMock.expectOne('http://localhost:8080/api/v1/logout');
+    expect(req.request.headers.get('Authorization')).toBe('Bearer token');
+    req.flush(null, { status: 204, statusText: 'No Content' });
+    expect(service.isLoggedIn()).toBeFalse();
+  });
+});
@@ -0,0 +1,51 @@
+import { Injectable } from '@angular/core';
+import { HttpClient, HttpErrorResponse } from '@angular/common/http';
+import { Observable, throwError } from 'rxjs';
+import { catchError, map } from 'rxjs/operators';
+import { TokenResponse } from './user';
+
+@Injectable({
+  providedIn: 'root'
+})
+export class AuthService {
+  private apiUrl = 'http://localhost:8080/api/v1';
+  private tokenKey = 'access_token';
+
+  constructor(private http: HttpClient) { }
+
+  get token(): string | null {
+    return localStorage.getItem(this.tokenKey);
+  }
+
+  isLoggedIn(): boolean {
+    return this.token !== null;
+  }
+
+  login(login: string, password: string): Observable<void> {
+    return this.http.post<TokenResponse>(`${this.apiUrl}/login`, { login, password }).pipe(
+      map(response => localStorage.setItem(this.tokenKey, response.access_token)),
+      catchError(error => {
+        if (error instanceof HttpErrorResponse && error.status === 401) {
+          return throwError(() => new Error('Invalid username, email or password.'));
+        }
+        if (error instanceof HttpErrorResponse && error.status === 423) {
+          return throwError(() => new Error('This account is locked. Please try again later.'));
+        }
+        return throwError(() => new Error('An unexpected error occurred. Please try again later.'));
+      })
+    );
+  }
+
+  logout(): void {
+    const token = this.token;
+    this.clearToken();
+    if (token) {
+      this.http.post(`${this.apiUrl}/logout`, null, { headers: { Authorization: `Bearer ${token}` } })
+        .subscribe({ error: () => { } });
+    }
+  }
+
+  clearToken(): void {
+    localStorage.removeItem(this.tokenKey);
+  }
+}
@@ -1,5 +1,6 @@
 import { Component, OnInit } from '@angular/core';
 import { UserService } from '../user.service';
+import { AuthService } from '../auth.service';
 import { User } from '../user';
 import { MatDialog } from '@angular/material/dialog';
 import { UserCreateComponent } from '../user-create/user-create.component';
@@ -17,7 +18,8 @@ export class UserListComponent implements OnInit {
 
   constructor(
     public dialog: MatDialog,
-    private userService: UserService
+    private userService: UserService,
+    private authService: AuthService
   ) { }
 
   ngOnInit(): void {
@@ -53,6 +55,10 @@ export class UserListComponent implements OnInit {
     });
   }
 
+  logout(): void {
+    this.authService.logout();
+  }
+
   openDeleteDialog(id: number): void {
     const dialogRef = this.dialog.open(UserDeleteComponent, {
       data: { id: id }
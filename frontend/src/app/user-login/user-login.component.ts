@@ -0,0 +1,39 @@
+import { Component } from '@angular/core';
+import { FormBuilder, FormGroup, Validators } from '@angular/forms';
+import { AuthService } from '../auth.service';
+
+@Component({
+  selector: 'app-user-login',
+  templateUrl: './user-login.component.html',
+  styleUrls: ['./user-login.component.css']
+})
+export class UserLoginComponent {
+  loginForm: FormGroup;
+  errorMessage: string | null = null;
+
+  constructor(
+    private fb: FormBuilder,
+    private authService: AuthService
+  ) {
+    this.loginForm = this.fb.group({
+      login: ['', Validators.required],
+      password: ['', Validators.required]
+    });
+  }
+
+  onSubmit() {
+    if (this.loginForm.valid) {
+      const { login, password } = this.loginForm.value;
+      this.authService.login(login, password).subscribe({
+        next: () => {
+          this.errorMessage = null;
+        },
+        error: (err) => {
+          this.errorMessage = err.message;
+        }
+      });
+    } else {
+      this.loginForm.markAllAsTouched();
+    }
+  }
+}
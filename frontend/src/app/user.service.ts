@@ -1,20 +1,21 @@
 import { Injectable } from '@angular/core';
 import { HttpClient, HttpErrorResponse } from '@angular/common/http';
 import { Observable, throwError } from 'rxjs';
-import { catchError, retry } from 'rxjs/operators';
-import { User } from './user';
+import { catchError, map, retry } from 'rxjs/operators';
+import { User, UserPage } from './user';
 
 @Injectable({
   providedIn: 'root'
 })
 export class UserService {
-  private apiUrl = 'http://localhost:8080/users';
+  private apiUrl = 'http://localhost:8080/api/v1/users';
 
   constructor(private http: HttpClient) { }
 
   getUsers(): Observable<User[]> {
-    return this.http.get<User[]>(this.apiUrl)
+    return this.http.get<UserPage>(this.apiUrl)
       .pipe(
+        map(page => page.data),
         retry(3),
         catchError(this.handleError)
       );
@@ -34,6 +35,10 @@ export class UserService {
         if (error instanceof HttpErrorResponse && error.status === 400 && error.error && error.error.error === 'username_or_email_exists') {
           return throwError(() => new Error('Username or email already exists. Please choose another one.'));
         }
+        if (error instanceof HttpErrorResponse && error.status === 400 && error.error && error.error.error === 'validation_failed' && error.error.fields) {
+          const fields: { [field: string]: string } = error.error.fields;
+          return throwError(() => new Error(Object.keys(fields).map(field => `${field} ${fields[field]}`).join('; ')));
+        }
         return throwError(() => new Error('An unexpected error occurred. Please try again later.'));
       })
     );
@@ -46,6 +51,10 @@ export class UserService {
         if (error instanceof HttpErrorResponse && error.status === 400 && error.error && error.error.error === 'username_or_email_exists') {
           return throwError(() => new Error('Username or email already exists. Please choose another one.'));
         }
+        if (error instanceof HttpErrorResponse && error.status === 400 && error.error && error.error.error === 'validation_failed' && error.error.fields) {
+          const fields: { [field: string]: string } = error.error.fields;
+          return throwError(() => new Error(Object.keys(fields).map(field => `${field} ${fields[field]}`).join('; ')));
+        }
         return throwError(() => new Error('An unexpected error occurred. Please try again later.'));
       })
     );
@@ -2,4 +2,19 @@ export interface User {
     id: number;
     username: string;
     email: string;
+  }
+
+export interface UserPage {
+    data: User[];
+    page: number;
+    pageSize: number;
+    total: number;
+    totalPages: number;
+  }
+
+export interface TokenResponse {
+    access_token: string;
+    token_type: string;
+    expires_in: number;
+    refresh_token?: string;
   }
\ No newline at end of file